@@ -0,0 +1,334 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// alerts.go contains the service alerts, the disruptions such as track work,
+// bus bridges and police activity that Caltrain posts to the GTFS-Realtime
+// ServiceAlerts feed
+
+// An AlertSeverity specifies how much an alert affects riders
+type AlertSeverity int
+
+const (
+	// SeverityUnknown is used when the feed doesn't report the severity
+	SeverityUnknown AlertSeverity = iota
+	// SeverityInfo is an alert for information only
+	SeverityInfo
+	// SeverityWarning is an alert that affects some riders
+	SeverityWarning
+	// SeveritySevere is an alert that affects service heavily
+	SeveritySevere
+)
+
+var severities = [...]string{
+	"Unknown",
+	"Info",
+	"Warning",
+	"Severe",
+}
+
+// String returns the string name of the severity. String values are show in
+// the AlertSeverity constant definition
+func (s AlertSeverity) String() string {
+	if SeverityUnknown <= s && s <= SeveritySevere {
+		return severities[s]
+	}
+	return fmt.Sprintf("unknown severity %d", s)
+}
+
+// AlertPeriod is a time range when an alert is active. A zero Start or End
+// leaves that side of the range open
+type AlertPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Alert is a service alert from the ServiceAlerts feed
+type Alert struct {
+	ID          string        // feed entity ID
+	Header      string        // short summary of the alert
+	Description string        // full text of the alert
+	URL         string        // link to more information
+	Cause       string        // cause of the disruption, such as "Construction"
+	Effect      string        // effect on service, such as "Reduced Service"
+	Severity    AlertSeverity // how much the alert affects riders
+	Active      []AlertPeriod // when the alert is active, always if empty
+	Systemwide  bool          // true if the alert affects all of Caltrain
+	Stations    []Station     // stations named by the alert
+	Lines       []Line        // lines named by the alert
+	Trains      []string      // train numbers named by the alert
+	entities    []alertEntity // parts of the service affected by the alert
+}
+
+// alertEntity is one informed entity of an alert. It affects the routes that
+// match all of its set fields, and all routes if none are set
+type alertEntity struct {
+	line       string  // line ID or name
+	station    Station // station the route stops at
+	hasStation bool    // true if station is set
+	train      string  // train number
+}
+
+// ActiveAt returns true if the alert is active at t
+func (a Alert) ActiveAt(t time.Time) bool {
+	return a.activeBetween(t, t)
+}
+
+// activeBetween returns true if the alert is active at any time from start
+// to end
+func (a Alert) activeBetween(start, end time.Time) bool {
+	if len(a.Active) == 0 {
+		return true
+	}
+	for _, p := range a.Active {
+		if (p.Start.IsZero() || !end.Before(p.Start)) && (p.End.IsZero() || !start.After(p.End)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Affects returns true if the alert affects the route, without checking when
+// the alert is active
+func (a Alert) Affects(r *Route) bool {
+	for _, e := range a.entities {
+		if e.line != "" && !strings.EqualFold(e.line, r.Line.Id) && !strings.EqualFold(e.line, r.Line.Name) {
+			continue
+		}
+		if e.train != "" && e.train != r.TrainNum {
+			continue
+		}
+		if e.hasStation && !routeStopsAt(r, e.station) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// routeStopsAt returns true if the route stops at the station
+func routeStopsAt(r *Route, st Station) bool {
+	for _, stop := range r.Stops {
+		if stop.Station == st {
+			return true
+		}
+	}
+	return false
+}
+
+// GetServiceAlerts makes an API call and returns the alerts in the
+// ServiceAlerts feed, including the ones that are not active yet. If the call
+// fails and stale alerts are cached, they are returned along with the error
+func (c *CaltrainClient) GetServiceAlerts(ctx context.Context) ([]Alert, time.Time, error) {
+	logrus.Debug("Getting service alerts...")
+	query := map[string]string{
+		"agency":  "CT",
+		"api_key": c.key,
+	}
+	parse := func(data []byte) (interface{}, error) {
+		alerts, err := c.dataset().parseServiceAlerts(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse service alerts: %w", err)
+		}
+		return alerts, nil
+	}
+	v, t, err := c.fetchLive(ctx, "get service alerts", serviceAlertsURL, serviceAlertsURL, query, parse)
+	alerts, _ := v.([]Alert)
+	return alerts, t, err
+}
+
+// AnnotateAlerts sets the Alerts of each route to the alerts that affect it
+// while it runs on the given date. It makes an API call for the alerts, and
+// the routes are left as they are if it fails
+func (c *CaltrainClient) AnnotateAlerts(ctx context.Context, routes []*Route, date time.Time) error {
+	alerts, _, err := c.GetServiceAlerts(ctx)
+	if alerts == nil {
+		return err
+	}
+	day := serviceDate(date.In(c.tz))
+	for _, r := range routes {
+		r.Alerts = nil
+		if len(r.Stops) == 0 {
+			continue
+		}
+		start := atServiceDate(day, r.Stops[0].Departure)
+		end := atServiceDate(day, r.Stops[len(r.Stops)-1].Arrival)
+		for _, a := range alerts {
+			if a.Affects(r) && a.activeBetween(start, end) {
+				r.Alerts = append(r.Alerts, a)
+			}
+		}
+	}
+	return err
+}
+
+// annotateQuery annotates the routes of a query with the alerts if the
+// WithAlerts option is set
+func (c *CaltrainClient) annotateQuery(ctx context.Context, routes []*Route, date time.Time, opts []QueryOption) error {
+	o := &queryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !o.alerts {
+		return nil
+	}
+	if err := c.AnnotateAlerts(ctx, routes, date); err != nil {
+		return fmt.Errorf("failed to get service alerts: %w", err)
+	}
+	return nil
+}
+
+// parseServiceAlerts unmarshals a GTFS-Realtime FeedMessage and returns its
+// alerts. Stops that are not Caltrain stations are ignored
+func (d *Dataset) parseServiceAlerts(raw []byte) ([]Alert, error) {
+	msg := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(raw, msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+
+	ret := []Alert{}
+	for _, entity := range msg.GetEntity() {
+		ga := entity.GetAlert()
+		if entity.GetIsDeleted() || ga == nil {
+			continue
+		}
+		a := Alert{
+			ID:          entity.GetId(),
+			Header:      translation(ga.GetHeaderText()),
+			Description: translation(ga.GetDescriptionText()),
+			URL:         translation(ga.GetUrl()),
+			Severity:    alertSeverity(ga.GetSeverityLevel()),
+		}
+		if ga.Cause != nil {
+			a.Cause = enumName(ga.GetCause().String())
+		}
+		if ga.Effect != nil {
+			a.Effect = enumName(ga.GetEffect().String())
+		}
+		for _, p := range ga.GetActivePeriod() {
+			period := AlertPeriod{}
+			if p.GetStart() != 0 {
+				period.Start = time.Unix(int64(p.GetStart()), 0).UTC()
+			}
+			if p.GetEnd() != 0 {
+				period.End = time.Unix(int64(p.GetEnd()), 0).UTC()
+			}
+			a.Active = append(a.Active, period)
+		}
+		for _, ie := range ga.GetInformedEntity() {
+			e, ok := d.alertEntity(ie)
+			if !ok {
+				continue
+			}
+			a.entities = append(a.entities, e)
+			a.addEntity(e, d.lines)
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+// alertEntity converts an informed entity into an alertEntity. It returns
+// false if the entity is not part of Caltrain
+func (d *Dataset) alertEntity(ie *gtfs.EntitySelector) (alertEntity, bool) {
+	// a trip is given by its GTFS trip ID, which is not always the train
+	// number
+	e := alertEntity{
+		line:  ie.GetRouteId(),
+		train: d.trainNumber(ie.GetTrip().GetTripId()),
+	}
+	if e.line == "" {
+		e.line = ie.GetTrip().GetRouteId()
+	}
+	if code := ie.GetStopId(); code != "" {
+		st, ok := d.codes[code]
+		if !ok {
+			return e, false
+		}
+		e.station, e.hasStation = st, true
+	}
+	return e, true
+}
+
+// addEntity adds the stations, lines and trains of an entity to the alert
+func (a *Alert) addEntity(e alertEntity, lines []Line) {
+	if e.line == "" && !e.hasStation && e.train == "" {
+		a.Systemwide = true
+		return
+	}
+	if e.hasStation && indexOfStation(a.Stations, e.station) < 0 {
+		a.Stations = append(a.Stations, e.station)
+	}
+	if e.line != "" {
+		line, err := parseLine(e.line, lines)
+		if err != nil {
+			line = Line{Id: e.line, Name: e.line}
+		}
+		if !containsLine(a.Lines, line) {
+			a.Lines = append(a.Lines, line)
+		}
+	}
+	if e.train != "" && !containsString(a.Trains, e.train) {
+		a.Trains = append(a.Trains, e.train)
+	}
+}
+
+// containsLine returns true if lines has line
+func containsLine(lines []Line, line Line) bool {
+	for _, l := range lines {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
+
+// translation returns the English text of a TranslatedString, or the first
+// translation if there is no English one
+func translation(ts *gtfs.TranslatedString) string {
+	ret := ""
+	for i, t := range ts.GetTranslation() {
+		lang := strings.ToLower(t.GetLanguage())
+		if lang == "en" || strings.HasPrefix(lang, "en-") {
+			return t.GetText()
+		}
+		if i == 0 {
+			ret = t.GetText()
+		}
+	}
+	return ret
+}
+
+// alertSeverity converts a GTFS-Realtime severity level
+func alertSeverity(s gtfs.Alert_SeverityLevel) AlertSeverity {
+	switch s {
+	case gtfs.Alert_INFO:
+		return SeverityInfo
+	case gtfs.Alert_WARNING:
+		return SeverityWarning
+	case gtfs.Alert_SEVERE:
+		return SeveritySevere
+	}
+	return SeverityUnknown
+}
+
+// enumName returns a GTFS-Realtime enum name such as REDUCED_SERVICE as
+// "Reduced Service"
+func enumName(name string) string {
+	words := strings.Split(strings.ToLower(name), "_")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
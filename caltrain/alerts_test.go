@@ -0,0 +1,195 @@
+package caltrain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+// translated returns a TranslatedString with the given language and text
+// pairs
+func translated(pairs ...string) *gtfs.TranslatedString {
+	ts := &gtfs.TranslatedString{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ts.Translation = append(ts.Translation, &gtfs.TranslatedString_Translation{
+			Language: proto.String(pairs[i]),
+			Text:     proto.String(pairs[i+1]),
+		})
+	}
+	return ts
+}
+
+// period returns an active period between two times
+func period(start, end time.Time) *gtfs.TimeRange {
+	return &gtfs.TimeRange{Start: proto.Uint64(uint64(start.Unix())), End: proto.Uint64(uint64(end.Unix()))}
+}
+
+// serviceAlertsFixture returns a marshaled FeedMessage of alerts for the
+// GTFS fixture on 2019-11-22
+func serviceAlertsFixture(t *testing.T, tz *time.Location) []byte {
+	t.Helper()
+	day := time.Date(2019, time.November, 22, 0, 0, 0, 0, tz)
+	msg := &gtfs.FeedMessage{
+		Header: &gtfs.FeedHeader{GtfsRealtimeVersion: proto.String("2.0")},
+		Entity: []*gtfs.FeedEntity{
+			{
+				// track work at Hayward Park in the morning
+				Id: proto.String("track"),
+				Alert: &gtfs.Alert{
+					ActivePeriod:    []*gtfs.TimeRange{period(day.Add(6*time.Hour), day.Add(9*time.Hour))},
+					InformedEntity:  []*gtfs.EntitySelector{{StopId: proto.String("70101")}},
+					Cause:           gtfs.Alert_CONSTRUCTION.Enum(),
+					Effect:          gtfs.Alert_REDUCED_SERVICE.Enum(),
+					HeaderText:      translated("es", "Obras en Hayward Park", "en", "Track work at Hayward Park"),
+					DescriptionText: translated("en", "Trains may be delayed up to 10 minutes"),
+					SeverityLevel:   gtfs.Alert_WARNING.Enum(),
+				},
+			},
+			{
+				// the bullets are affected the next day
+				Id: proto.String("bullet"),
+				Alert: &gtfs.Alert{
+					ActivePeriod:   []*gtfs.TimeRange{period(day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))},
+					InformedEntity: []*gtfs.EntitySelector{{RouteId: proto.String("Bullet")}},
+				},
+			},
+			{
+				Id: proto.String("police"),
+				Alert: &gtfs.Alert{
+					InformedEntity: []*gtfs.EntitySelector{{Trip: &gtfs.TripDescriptor{TripId: proto.String("103a")}}},
+					HeaderText:     translated("", "Police activity"),
+					SeverityLevel:  gtfs.Alert_SEVERE.Enum(),
+				},
+			},
+			{
+				// a stop of another agency
+				Id: proto.String("bart"),
+				Alert: &gtfs.Alert{
+					InformedEntity: []*gtfs.EntitySelector{{StopId: proto.String("MLBR")}},
+				},
+			},
+			{
+				Id: proto.String("late"),
+				Alert: &gtfs.Alert{
+					ActivePeriod:   []*gtfs.TimeRange{period(day.Add(23*time.Hour), day.Add(24*time.Hour))},
+					InformedEntity: []*gtfs.EntitySelector{{AgencyId: proto.String("CT")}},
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed to marshal feed: %v", err)
+	}
+	return data
+}
+
+func TestGetServiceAlerts(t *testing.T) {
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientMock{GetResult: serviceAlertsFixture(t, c.tz)}
+
+	alerts, _, err := c.GetServiceAlerts(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(alerts) != 5 {
+		t.Fatalf("Unexpected number of alerts. Expected %d, received %d", 5, len(alerts))
+	}
+
+	track := alerts[0]
+	if track.Header != "Track work at Hayward Park" || track.Description != "Trains may be delayed up to 10 minutes" {
+		t.Fatalf("Unexpected text: %q, %q", track.Header, track.Description)
+	}
+	if track.Cause != "Construction" || track.Effect != "Reduced Service" || track.Severity != SeverityWarning {
+		t.Fatalf("Unexpected cause, effect or severity: %s, %s, %s", track.Cause, track.Effect, track.Severity)
+	}
+	assertStations(t, []Station{StationHaywardPark}, track.Stations)
+	start := time.Date(2019, time.November, 22, 6, 0, 0, 0, c.tz)
+	if len(track.Active) != 1 || !track.Active[0].Start.Equal(start) || !track.ActiveAt(start.Add(time.Hour)) || track.ActiveAt(start.Add(-time.Minute)) {
+		t.Fatalf("Unexpected active periods: %v", track.Active)
+	}
+
+	if bullet := alerts[1]; len(bullet.Lines) != 1 || bullet.Lines[0] != (Line{"Bullet", "Bullet"}) {
+		t.Fatalf("Unexpected lines: %v", bullet.Lines)
+	}
+	if police := alerts[2]; police.Header != "Police activity" || police.Severity != SeveritySevere || len(police.Trains) != 1 || police.Trains[0] != "103" || !police.ActiveAt(start) {
+		t.Fatalf("Unexpected police alert: %+v", police)
+	}
+	if bart := alerts[3]; bart.Systemwide || len(bart.Stations) != 0 {
+		t.Fatalf("Unexpected alert for another agency: %+v", bart)
+	}
+	if late := alerts[4]; !late.Systemwide {
+		t.Fatalf("The agency alert is not systemwide: %+v", late)
+	}
+}
+
+func TestWithAlerts(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientMock{GetResult: serviceAlertsFixture(t, c.tz)}
+	date := time.Date(2019, time.November, 22, 0, 0, 0, 0, c.tz)
+
+	routes, err := c.GetTrainsBetweenStationsForDate(ctx, StationSanJose, StationSanFrancisco, date, WithAlerts())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := map[string][]string{
+		"101": {"track"},
+		"501": {},
+		"103": {"track", "police"},
+		"199": {"late"},
+	}
+	if len(routes) != len(exp) {
+		t.Fatalf("Unexpected number of routes. Expected %d, received %d", len(exp), len(routes))
+	}
+	for _, r := range routes {
+		ids := []string{}
+		for _, a := range r.Alerts {
+			ids = append(ids, a.ID)
+		}
+		if len(ids) != len(exp[r.TrainNum]) {
+			t.Fatalf("Unexpected alerts for %s. Expected %v, received %v", r.TrainNum, exp[r.TrainNum], ids)
+		}
+		for i := range ids {
+			if ids[i] != exp[r.TrainNum][i] {
+				t.Fatalf("Unexpected alerts for %s. Expected %v, received %v", r.TrainNum, exp[r.TrainNum], ids)
+			}
+		}
+	}
+
+	// the station timetable takes the option too
+	routes, err = c.GetStationTimetableContext(ctx, StationSanJose, North, date, WithAlerts())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	police := false
+	for _, r := range routes {
+		for _, a := range r.Alerts {
+			police = police || (r.TrainNum == "103" && a.ID == "police")
+		}
+	}
+	if !police {
+		t.Fatalf("The station timetable is missing the police alert on train 103")
+	}
+
+	// without the option no API call is made
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/missing.pb"}
+	routes, err = c.GetTrainsBetweenStationsForDate(ctx, StationSanJose, StationSanFrancisco, date)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, r := range routes {
+		if r.Alerts != nil {
+			t.Fatalf("Route %s has alerts without WithAlerts", r.TrainNum)
+		}
+	}
+
+	// a failed call returns the routes along with the error
+	routes, err = c.GetTrainsBetweenStationsForDate(ctx, StationSanJose, StationSanFrancisco, date, WithAlerts())
+	if err == nil || len(routes) != len(exp) {
+		t.Fatalf("Unexpected result of a failed alerts call: %d routes, %v", len(routes), err)
+	}
+}
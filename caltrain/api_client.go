@@ -2,32 +2,47 @@ package caltrain
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/sirupsen/logrus"
 )
 
 // APILimitError is returned on a failed API request when the failure
 // reason is that the number of requests has exceeded the rate limit
-type APILimitError struct{}
+type APILimitError struct {
+	RetryAfter time.Duration // time until a request is expected to succeed, if known
+}
 
 func (a *APILimitError) Error() string {
+	if a.RetryAfter > 0 {
+		return fmt.Sprintf("API call limit to 511.org has been reached, retry after %s", a.RetryAfter.Round(time.Second))
+	}
 	return "API call limit to 511.org has been reached"
 }
 
 // APIError is returned on a failed API request for any reason other
 // than too many requests
 type APIError struct {
-	Status string
-	Code   int
-	Url    string
-	Query  map[string]string
+	Status   string
+	Code     int
+	Url      string
+	Query    map[string]string
+	Attempts int // number of requests made before giving up
 }
 
 func (a *APIError) Error() string {
+	if a.Attempts > 1 {
+		return fmt.Sprintf("API error: %s after %d attempts", a.Status, a.Attempts)
+	}
 	return fmt.Sprintf("API error: %s", a.Status)
 }
 
@@ -36,24 +51,147 @@ type APIClient interface {
 	Get(ctx context.Context, url string, query map[string]string) ([]byte, error)
 }
 
-// APIClient511 implements APIClient with 511.org requests
-type APIClient511 struct{}
+// RetryPolicy configures the retries of APIClient511. Server errors and
+// transport errors are retried with exponential backoff and jitter. Too many
+// requests errors are never retried since a retry would use more of the
+// budget
+type RetryPolicy struct {
+	MaxAttempts int           // total number of requests to make, 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry, doubled for each retry after
+	MaxDelay    time.Duration // longest delay between attempts, including a Retry-After header
+}
+
+// DefaultRetryPolicy is the RetryPolicy of NewClient
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// APIClient511 implements APIClient with 511.org requests. Requests are
+// paced to stay under the 511.org rate limit, see SetRateLimit, and failed
+// requests are retried, see SetRetryPolicy
+type APIClient511 struct {
+	client    *http.Client
+	baseURL   string // replaces DefaultBaseURL in request urls
+	userAgent string
+	limiter   *rateLimiter // nil if rate limiting is disabled
+	retry     RetryPolicy
 
-// NewClient returns an instance of the APIClient511 struct
-func NewClient() *APIClient511 {
-	return &APIClient511{}
+	clock clock.Clock // time package for unit testing
 }
 
-// Get makes a GET request to the 511.org API and returns the request body
+// NewClient returns an instance of the APIClient511 struct, limited to the
+// 60 requests an hour of the 511.org free tier. Requests are made over HTTPS
+// to DefaultBaseURL unless changed by the options
+func NewClient(opts ...ClientOption) *APIClient511 {
+	o := &clientOptions{
+		baseURL:   DefaultBaseURL,
+		userAgent: DefaultUserAgent,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &APIClient511{
+		client:    o.httpClient(),
+		baseURL:   o.baseURL,
+		userAgent: o.userAgent,
+		limiter:   newRateLimiter(defaultRateLimit, defaultRatePeriod),
+		retry:     DefaultRetryPolicy,
+		clock:     clock.New(),
+	}
+}
+
+// SetRateLimit sets the number of requests allowed in each period. A limit
+// of zero disables the rate limiting
+func (a *APIClient511) SetRateLimit(limit int, period time.Duration) {
+	if limit <= 0 || period <= 0 {
+		a.limiter = nil
+		return
+	}
+	a.limiter = newRateLimiter(limit, period)
+}
+
+// SetRetryPolicy sets how failed requests are retried
+func (a *APIClient511) SetRetryPolicy(p RetryPolicy) {
+	if p.MaxAttempts < 1 {
+		p.MaxAttempts = 1
+	}
+	a.retry = p
+}
+
+// RateLimitStatus returns the remaining request budget. It is read from the
+// Ratelimit-Limit and Ratelimit-Remaining headers of the last response, and
+// estimated from the local rate limit before the first response
+func (a *APIClient511) RateLimitStatus() RateLimitStatus {
+	if a.limiter == nil {
+		return RateLimitStatus{}
+	}
+	return a.limiter.rateLimitStatus()
+}
+
+// Get makes a GET request to the 511.org API and returns the request body.
+// If the rate limit is close to being reached, the request is delayed or
+// refused with an APILimitError depending on the priority set on ctx with
+// WithPriority. Server and transport errors are retried according to the
+// RetryPolicy, and the final error includes the number of attempts
 func (a *APIClient511) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		body, retryAfter, err := a.get(ctx, url, query)
+		if err == nil {
+			return body, nil
+		}
+
+		var apiErr *APIError
+		isAPIErr := errors.As(err, &apiErr)
+		if isAPIErr {
+			apiErr.Attempts = attempt
+		}
+		if attempt >= a.retry.MaxAttempts || !retryable(ctx, err) {
+			if isAPIErr || attempt == 1 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("request failed after %d attempts: %w", attempt, err)
+		}
+
+		delay := a.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if delay > a.retry.MaxDelay {
+			// the server asked for a longer wait than we are willing to block
+			return nil, err
+		}
+		logrus.Debugf("Retrying %s in %s after attempt %d: %v", url, delay, attempt, err)
+		timer := a.clock.Timer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// get makes a single request. It returns the Retry-After header of a failed
+// response, or zero if it was not set
+func (a *APIClient511) get(ctx context.Context, url string, query map[string]string) ([]byte, time.Duration, error) {
+	if a.limiter != nil {
+		if err := a.limiter.wait(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	url = a.rewriteURL(url)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
-	// TODO: handle 500 errors?
-
 	req.Header.Set("Content-Type", "application/json")
+	if a.userAgent != "" {
+		req.Header.Set("User-Agent", a.userAgent)
+	}
 
 	// update the url with the required query parameters
 	q := req.URL.Query()
@@ -62,34 +200,86 @@ func (a *APIClient511) Get(ctx context.Context, url string, query map[string]str
 	}
 	req.URL.RawQuery = q.Encode()
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := a.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if a.limiter != nil {
+		a.limiter.update(resp)
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		logrus.Debugf("API error - %s", resp.Status)
+		// drain the body so the connection can be reused
+		io.Copy(ioutil.Discard, resp.Body)
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), a.clock.Now())
 		// return a specific error for too many requests
 		if resp.StatusCode == http.StatusTooManyRequests {
-			return nil, &APILimitError{}
+			return nil, retryAfter, &APILimitError{RetryAfter: retryAfter}
 		}
-		return nil, &APIError{Status: resp.Status, Code: resp.StatusCode, Url: url, Query: query}
+		return nil, retryAfter, &APIError{Status: resp.Status, Code: resp.StatusCode, Url: url, Query: query}
 	}
 
-	// TODO: return the number of tries left? It exists in the header under
-	// the Ratelimit-Limit and Ratelimit-Remaining keys. The api appears to
-	// have be volatile on how many remaining calls can be made
-	//
-	// remain, err := strconv.Atoi(resp.Header["Ratelimit-Remaining"][0])
-
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read body: %w", err)
+		return nil, 0, fmt.Errorf("failed to read body: %w", err)
 	}
-	resp.Body.Close()
+	return body, 0, nil
+}
 
-	return body, nil
+// backoff returns the delay before the retry after the given attempt. The
+// delay doubles with each attempt, and half of it is random so that clients
+// that failed together don't retry together
+func (a *APIClient511) backoff(attempt int) time.Duration {
+	d := a.retry.BaseDelay << uint(attempt-1)
+	if d <= 0 || d > a.retry.MaxDelay {
+		d = a.retry.MaxDelay
+	}
+	half := d / 2
+	if half <= 0 {
+		return d
+	}
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// retryable returns true if a failed request should be tried again. Server
+// errors and transport errors are retried. Client errors, including too many
+// requests, and canceled contexts are not
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var limErr *APILimitError
+	if errors.As(err, &limErr) {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500
+	}
+	return true
+}
+
+// parseRetryAfter returns the duration of a Retry-After header, which is
+// either a number of seconds or an http date. It returns zero if the header
+// is empty or invalid
+func parseRetryAfter(h string, now time.Time) time.Duration {
+	if h == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
 }
 
 type apiClientMock struct {
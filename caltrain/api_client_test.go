@@ -0,0 +1,226 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newRetryServer returns a server that responds with each code in turn,
+// repeating the last one, and counts the requests
+func newRetryServer(t *testing.T, header http.Header, codes ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		if n > len(codes) {
+			n = len(codes)
+		}
+		for k, v := range header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(codes[n-1])
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func newRetryClient() *APIClient511 {
+	a := NewClient()
+	a.SetRateLimit(0, 0)
+	a.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	return a
+}
+
+func TestAPIClient511Retry(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		name     string
+		codes    []int
+		header   http.Header
+		calls    int32
+		attempts int // attempts on the APIError, 0 for success
+		limit    bool
+	}{
+		{name: "success", codes: []int{200}, calls: 1},
+		{name: "recovers", codes: []int{500, 503, 200}, calls: 3},
+		{name: "gives up", codes: []int{502}, calls: 3, attempts: 3},
+		{name: "client error", codes: []int{404}, calls: 1, attempts: 1},
+		{name: "too many requests", codes: []int{429, 200}, calls: 1, limit: true},
+		{name: "retry after", codes: []int{503, 200}, header: http.Header{"Retry-After": {"0"}}, calls: 2},
+		{name: "retry after too long", codes: []int{503, 200}, header: http.Header{"Retry-After": {"120"}}, calls: 1, attempts: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server, calls := newRetryServer(t, tc.header, tc.codes...)
+			_, err := newRetryClient().Get(ctx, server.URL, nil)
+			if n := atomic.LoadInt32(calls); n != tc.calls {
+				t.Fatalf("Unexpected number of requests. Expected %d, received %d", tc.calls, n)
+			}
+
+			var apiErr *APIError
+			var limErr *APILimitError
+			switch {
+			case tc.limit:
+				if !errors.As(err, &limErr) {
+					t.Fatalf("Expected an APILimitError, received %v", err)
+				}
+			case tc.attempts > 0:
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("Expected an APIError, received %v", err)
+				}
+				if apiErr.Attempts != tc.attempts {
+					t.Fatalf("Unexpected attempts. Expected %d, received %d", tc.attempts, apiErr.Attempts)
+				}
+			case err != nil:
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAPIClient511RetryTransportError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	_, err := newRetryClient().Get(context.Background(), url, nil)
+	if err == nil {
+		t.Fatalf("Expected an error from a closed server")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Fatalf("Expected the error to include the attempts, received %v", err)
+	}
+}
+
+func TestAPIClient511RetryBudget(t *testing.T) {
+	// every attempt takes a token, so retries stop when the budget runs out
+	server, calls := newRetryServer(t, nil, 500)
+	a := newRetryClient()
+	a.SetRateLimit(12, time.Hour)
+
+	high := WithPriority(context.Background(), PriorityHigh)
+	a.SetRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 50 * time.Millisecond})
+	if _, err := a.Get(high, server.URL, nil); err == nil {
+		t.Fatalf("Expected an error")
+	}
+	if status := a.RateLimitStatus(); status.Tokens >= 8 {
+		t.Fatalf("Expected each attempt to use a token: %+v", status)
+	}
+	if n := atomic.LoadInt32(calls); n != 5 {
+		t.Fatalf("Unexpected number of requests. Expected %d, received %d", 5, n)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tc := range testCases {
+		if d := parseRetryAfter(tc.header, now); d != tc.expected {
+			t.Fatalf("Unexpected duration for %q. Expected %s, received %s", tc.header, tc.expected, d)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestClientOptions(t *testing.T) {
+	var path, agent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, agent = r.URL.Path, r.UserAgent()
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// the base url replaces the 511.org host, keeping the api path
+	a := NewClient(WithBaseURL(server.URL+"/511/"), WithUserAgent("test-agent"))
+	if _, err := a.Get(ctx, linesURL, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/511/transit/lines" || agent != "test-agent" {
+		t.Fatalf("Unexpected request. Path %q, User-Agent %q", path, agent)
+	}
+
+	// urls of other hosts are not rewritten
+	if _, err := NewClient().Get(ctx, server.URL+"/other", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/other" || agent != DefaultUserAgent {
+		t.Fatalf("Unexpected request. Path %q, User-Agent %q", path, agent)
+	}
+
+	// the proxy receives the request for the 511.org url
+	a = NewClient(WithProxy(mustParseURL(t, server.URL)))
+	a.SetRetryPolicy(RetryPolicy{MaxAttempts: 1})
+	if _, err := a.Get(ctx, "http://api.511.org/transit/lines", nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if path != "/transit/lines" {
+		t.Fatalf("The request was not sent through the proxy, path %q", path)
+	}
+}
+
+func TestClientOptionsTransport(t *testing.T) {
+	var host string
+	rt := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		host = r.URL.Scheme + "://" + r.URL.Host
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Status:     "200 OK",
+			Header:     http.Header{},
+			Body:       http.NoBody,
+		}, nil
+	})
+
+	base := &http.Client{Timeout: time.Minute}
+	a := NewClient(WithHTTPClient(base), WithTransport(rt))
+	if _, err := a.Get(context.Background(), linesURL, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if host != DefaultBaseURL {
+		t.Fatalf("Unexpected host. Expected %s, received %s", DefaultBaseURL, host)
+	}
+	if a.client == base || base.Transport != nil {
+		t.Fatalf("The http client passed to WithHTTPClient was modified")
+	}
+	if a.client.Timeout != time.Minute {
+		t.Fatalf("Unexpected timeout. Expected %s, received %s", time.Minute, a.client.Timeout)
+	}
+
+	if c := NewClient(); c.client.Timeout != defaultClientTimeout {
+		t.Fatalf("Unexpected default timeout %s", c.client.Timeout)
+	}
+	if c := NewClient(WithHTTPClient(base), WithTimeout(0)); c.client.Timeout != 0 {
+		t.Fatalf("Expected WithTimeout to override the client timeout, received %s", c.client.Timeout)
+	}
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return u
+}
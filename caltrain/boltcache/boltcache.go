@@ -0,0 +1,108 @@
+// Package boltcache provides a caltrain.Cache backed by a bbolt database
+// file.
+//
+// bbolt locks the database file while it is open, so the file is opened for
+// each operation instead of for the life of the Cache. This lets several
+// processes on one host share the file, and the responses it holds, at the
+// cost of opening the file on each request
+package boltcache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/efritz09/go-caltrain/caltrain"
+	bolt "go.etcd.io/bbolt"
+)
+
+// bucket is the bbolt bucket that holds the responses
+var bucket = []byte("responses")
+
+// defaultTimeout is how long to wait for another process to release the file
+const defaultTimeout = 5 * time.Second
+
+// Cache is a caltrain.Cache that stores the responses in a bbolt database
+type Cache struct {
+	path    string
+	timeout time.Duration
+}
+
+// New returns a Cache that stores the responses in the database at path,
+// creating it if it doesn't exist
+func New(path string) (*Cache, error) {
+	c := &Cache{path: path, timeout: defaultTimeout}
+	err := c.update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cache database: %w", err)
+	}
+	return c, nil
+}
+
+// Get returns the body and the time it was set, or caltrain.ErrCacheMiss.
+// The value is the set time in unix nanoseconds followed by the body
+func (c *Cache) Get(key string) ([]byte, time.Time, error) {
+	var body []byte
+	var t time.Time
+	err := c.view(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucket).Get([]byte(key))
+		if v == nil {
+			return caltrain.ErrCacheMiss
+		}
+		if len(v) < 8 {
+			return fmt.Errorf("cache value for %s is corrupt", key)
+		}
+		t = time.Unix(0, int64(binary.BigEndian.Uint64(v[:8])))
+		// the value is only valid during the transaction
+		body = append([]byte{}, v[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return body, t, nil
+}
+
+// Set stores the body and the time it was set
+func (c *Cache) Set(key string, body []byte, t time.Time) error {
+	v := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint64(v, uint64(t.UnixNano()))
+	v = append(v, body...)
+	return c.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucket).Put([]byte(key), v)
+	})
+}
+
+// Clear removes every response
+func (c *Cache) Clear() error {
+	return c.update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(bucket)
+		return err
+	})
+}
+
+// view runs fn in a read transaction
+func (c *Cache) view(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(c.path, 0644, &bolt.Options{Timeout: c.timeout, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+// update runs fn in a read-write transaction
+func (c *Cache) update(fn func(tx *bolt.Tx) error) error {
+	db, err := bolt.Open(c.path, 0644, &bolt.Options{Timeout: c.timeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
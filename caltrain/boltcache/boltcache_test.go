@@ -0,0 +1,50 @@
+package boltcache
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/efritz09/go-caltrain/caltrain"
+)
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var _ caltrain.Cache = c
+
+	if _, _, err := c.Get("a"); !errors.Is(err, caltrain.ErrCacheMiss) {
+		t.Fatalf("Expected a cache miss, received %v", err)
+	}
+
+	now := time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC)
+	body := []byte("delays")
+	if err := c.Set("a", body, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// a second cache on the same file shares the responses
+	other, err := New(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	v, set, err := other.Get("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(v, body) || !set.Equal(now) {
+		t.Fatalf("Unexpected entry. Expected %s at %s, received %s at %s", body, now, v, set)
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := other.Get("a"); !errors.Is(err, caltrain.ErrCacheMiss) {
+		t.Fatalf("Expected a cache miss after Clear, received %v", err)
+	}
+}
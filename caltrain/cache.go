@@ -1,54 +1,176 @@
 package caltrain
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/benbjohnson/clock"
+	"github.com/sirupsen/logrus"
 )
 
 const (
 	defaultCacheTimeout = 5 * time.Minute
+	// defaultStaticCacheTimeout is the expiration of the lines, stations,
+	// timetable and holidays responses
+	defaultStaticCacheTimeout = 24 * time.Hour
+	// defaultCacheMaxEntries and defaultCacheMaxBytes limit the size of the
+	// in-memory cache
+	defaultCacheMaxEntries = 1000
+	defaultCacheMaxBytes   = 64 << 20
 )
 
+// ErrCacheMiss is returned by a Cache when the key is not stored
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache is a store for API responses that can be passed to SetupCache to
+// persist them or share them between processes. The client handles the
+// expiration, so a Cache only keeps the body and the time it was set.
+// Implementations must be safe for concurrent use
+type Cache interface {
+	// Get returns the body and the time it was set, or ErrCacheMiss
+	Get(key string) ([]byte, time.Time, error)
+	// Set stores the body and the time it was set
+	Set(key string, body []byte, t time.Time) error
+	// Clear removes every key
+	Clear() error
+}
+
+// An Endpoint is a 511.org API endpoint whose responses are cached
+type Endpoint string
+
+// The endpoints of the responses cached by the client
+const (
+	EndpointLines            Endpoint = linesURL
+	EndpointStations         Endpoint = stationsURL
+	EndpointTimetable        Endpoint = timetableURL
+	EndpointHolidays         Endpoint = holidaysURL
+	EndpointStopMonitoring   Endpoint = delayURL
+	EndpointTripUpdates      Endpoint = tripUpdatesURL
+	EndpointVehiclePositions Endpoint = vehiclesURL
+	EndpointServiceAlerts    Endpoint = serviceAlertsURL
+)
+
+type refreshKey struct{}
+
+// WithRefresh returns a context that makes the static data requests skip the
+// cached responses, so that a scheduled refresh always gets new data from
+// the API. The new responses are still cached
+func WithRefresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+// isRefresh returns true if ctx is from WithRefresh
+func isRefresh(ctx context.Context) bool {
+	refresh, _ := ctx.Value(refreshKey{}).(bool)
+	return refresh
+}
+
 type cache interface {
 	set(key string, body []byte)
 	get(key string) ([]byte, time.Time, bool)
 	clearCache()
+	sweep(grace time.Duration) int
+}
+
+// cacheTTLs holds the expiration of the responses of each endpoint. The
+// static data changes rarely, so it is kept far longer than the live status
+type cacheTTLs struct {
+	def       time.Duration // expiration of the live status and any other key
+	endpoints map[Endpoint]time.Duration
+	lock      sync.RWMutex
+}
+
+func newCacheTTLs(def time.Duration) *cacheTTLs {
+	return &cacheTTLs{
+		def: def,
+		endpoints: map[Endpoint]time.Duration{
+			EndpointLines:     defaultStaticCacheTimeout,
+			EndpointStations:  defaultStaticCacheTimeout,
+			EndpointTimetable: defaultStaticCacheTimeout,
+			EndpointHolidays:  defaultStaticCacheTimeout,
+		},
+	}
+}
+
+// ttl returns the expiration of a key. Keys start with the endpoint url, so
+// the longest endpoint that prefixes the key is used
+func (t *cacheTTLs) ttl(key string) time.Duration {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	ttl, match := t.def, ""
+	for e, d := range t.endpoints {
+		if strings.HasPrefix(key, string(e)) && len(e) > len(match) {
+			ttl, match = d, string(e)
+		}
+	}
+	return ttl
+}
+
+// setDefault sets the expiration of the keys without an endpoint TTL
+func (t *cacheTTLs) setDefault(d time.Duration) {
+	t.lock.Lock()
+	t.def = d
+	t.lock.Unlock()
 }
 
+// setEndpoint sets the expiration of an endpoint's keys
+func (t *cacheTTLs) setEndpoint(e Endpoint, d time.Duration) {
+	t.lock.Lock()
+	t.endpoints[e] = d
+	t.lock.Unlock()
+}
+
+// caltrainCache is an in-memory cache. When it holds more than maxEntries
+// responses, or more than maxBytes of them, the least recently used are
+// evicted
 type caltrainCache struct {
-	cache   map[string]cacheData // map of endpoint to body data and timestamp
-	timeout time.Duration
-	lock    sync.RWMutex
+	cache      map[string]*list.Element // map of endpoint to an element of lru holding a *cacheData
+	lru        *list.List               // entries from most to least recently used
+	size       int64                    // total bytes of the bodies
+	maxEntries int                      // 0 for no limit
+	maxBytes   int64                    // 0 for no limit
+	ttls       *cacheTTLs
+	lock       sync.Mutex
 
 	clock clock.Clock // time package for unit testing
 }
 
 type cacheData struct {
-	body       []byte    // response body
-	entryTime  time.Time // time that 'body' was stored
-	expiration int64     // expiration time
+	key       string    // key of the entry, to remove it from the map on eviction
+	body      []byte    // response body
+	entryTime time.Time // time that 'body' was stored
 }
 
 func newCache(expire time.Duration) *caltrainCache {
 	return &caltrainCache{
-		cache:   make(map[string]cacheData),
-		timeout: expire,
-		clock:   clock.New(),
+		cache:      make(map[string]*list.Element),
+		lru:        list.New(),
+		maxEntries: defaultCacheMaxEntries,
+		maxBytes:   defaultCacheMaxBytes,
+		ttls:       newCacheTTLs(expire),
+		clock:      clock.New(),
 	}
 }
 
-// set calculates the key's expiration and sets the cacheData for that key
+// set stores the body for the key as the most recently used entry, evicting
+// the least recently used entries if the cache is over its limits
 func (c *caltrainCache) set(key string, body []byte) {
-	exp := c.clock.Now().Add(c.timeout).UnixNano()
 	c.lock.Lock()
-	c.cache[key] = cacheData{
-		body:       body,
-		entryTime:  time.Now(),
-		expiration: exp,
+	defer c.lock.Unlock()
+	if e, ok := c.cache[key]; ok {
+		c.remove(e)
 	}
-	c.lock.Unlock()
+	c.cache[key] = c.lru.PushFront(&cacheData{
+		key:       key,
+		body:      body,
+		entryTime: c.clock.Now(),
+	})
+	c.size += int64(len(body))
+	c.evict()
 }
 
 // get will query the cache for an endpoint. if the endpoint exists, it will
@@ -56,15 +178,17 @@ func (c *caltrainCache) set(key string, body []byte) {
 // it will return false. It always returns the body and entryTime for use in
 // case the API limit has been reached
 func (c *caltrainCache) get(key string) ([]byte, time.Time, bool) {
-	c.lock.RLock()
-	defer c.lock.RUnlock()
-	data, ok := c.cache[key]
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.cache[key]
 	var t time.Time
 	if !ok {
 		return nil, t, false
 	}
+	c.lru.MoveToFront(e)
 
-	if c.clock.Now().UnixNano() > data.expiration {
+	data := e.Value.(*cacheData)
+	if c.clock.Now().Sub(data.entryTime) > c.ttls.ttl(key) {
 		return data.body, data.entryTime, false
 	}
 	return data.body, data.entryTime, true
@@ -73,10 +197,112 @@ func (c *caltrainCache) get(key string) ([]byte, time.Time, bool) {
 // clearCache clears the cache by creating a new cache map
 func (c *caltrainCache) clearCache() {
 	c.lock.Lock()
-	c.cache = make(map[string]cacheData)
+	c.cache = make(map[string]*list.Element)
+	c.lru.Init()
+	c.size = 0
 	c.lock.Unlock()
 }
 
+// setLimits sets the maximum number of entries and bytes, evicting entries
+// if the cache is already over them
+func (c *caltrainCache) setLimits(maxEntries int, maxBytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxEntries, c.maxBytes = maxEntries, maxBytes
+	c.evict()
+}
+
+// sweep removes the entries that expired more than grace ago, and returns the
+// number removed. Entries within grace are kept to be served as stale data
+func (c *caltrainCache) sweep(grace time.Duration) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.clock.Now()
+	removed := 0
+	for e := c.lru.Back(); e != nil; {
+		prev := e.Prev()
+		data := e.Value.(*cacheData)
+		if now.Sub(data.entryTime) > c.ttls.ttl(data.key)+grace {
+			c.remove(e)
+			removed++
+		}
+		e = prev
+	}
+	return removed
+}
+
+// evict removes the least recently used entries until the cache is within
+// its limits. The lock must be held
+func (c *caltrainCache) evict() {
+	for c.lru.Len() > 0 {
+		over := (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) || (c.maxBytes > 0 && c.size > c.maxBytes)
+		if !over {
+			return
+		}
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove deletes an entry. The lock must be held
+func (c *caltrainCache) remove(e *list.Element) {
+	data := c.lru.Remove(e).(*cacheData)
+	delete(c.cache, data.key)
+	c.size -= int64(len(data.body))
+}
+
+// backendCache adds the expiration to a Cache
+type backendCache struct {
+	backend Cache
+	ttls    *cacheTTLs
+
+	clock clock.Clock // time package for unit testing
+}
+
+func newBackendCache(backend Cache, expire time.Duration) *backendCache {
+	return &backendCache{
+		backend: backend,
+		ttls:    newCacheTTLs(expire),
+		clock:   clock.New(),
+	}
+}
+
+// set stores the body in the backend. A failure is logged rather than
+// returned since the response is still usable
+func (c *backendCache) set(key string, body []byte) {
+	if err := c.backend.Set(key, body, c.clock.Now()); err != nil {
+		logrus.Warnf("failed to cache %s: %v", key, err)
+	}
+}
+
+// get works the same as caltrainCache.get, using the time the body was set
+// to find if it has expired
+func (c *backendCache) get(key string) ([]byte, time.Time, bool) {
+	body, t, err := c.backend.Get(key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			logrus.Warnf("failed to read %s from the cache: %v", key, err)
+		}
+		return nil, time.Time{}, false
+	}
+	if c.clock.Now().Sub(t) > c.ttls.ttl(key) {
+		return body, t, false
+	}
+	return body, t, true
+}
+
+// clearCache clears the backend
+func (c *backendCache) clearCache() {
+	if err := c.backend.Clear(); err != nil {
+		logrus.Warnf("failed to clear the cache: %v", err)
+	}
+}
+
+// sweep does nothing since the Cache interface can't remove single keys. The
+// backend is responsible for its own size
+func (c *backendCache) sweep(grace time.Duration) int {
+	return 0
+}
+
 type mockCache struct {
 	SetFunc func(string, []byte)
 	GetFunc func(string) ([]byte, time.Time, bool)
@@ -96,3 +322,5 @@ func (c *mockCache) get(key string) ([]byte, time.Time, bool) {
 }
 
 func (c *mockCache) clearCache() {}
+
+func (c *mockCache) sweep(grace time.Duration) int { return 0 }
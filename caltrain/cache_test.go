@@ -2,6 +2,7 @@ package caltrain
 
 import (
 	"bytes"
+	"context"
 	"testing"
 	"time"
 
@@ -143,3 +144,139 @@ func TestClearCache(t *testing.T) {
 		t.Fatalf("cache is not empty! %v", c.cache)
 	}
 }
+
+func TestCacheEviction(t *testing.T) {
+	c := newCache(defaultCacheTimeout)
+	c.setLimits(2, 0)
+	c.set("a", d1)
+	c.set("b", d2)
+	// using 'a' makes 'b' the least recently used
+	c.get("a")
+	c.set("c", d3)
+	if _, _, ok := c.get("b"); ok {
+		t.Fatalf("'b' was not evicted")
+	}
+	if _, _, ok := c.get("a"); !ok {
+		t.Fatalf("'a' was evicted")
+	}
+
+	// the byte limit evicts until the bodies fit
+	c.setLimits(0, int64(len(d1)+len(d3)))
+	c.set("b", d2)
+	if len(c.cache) != 2 || c.size > int64(len(d1)+len(d3)) {
+		t.Fatalf("Unexpected cache size: %d entries, %d bytes", len(c.cache), c.size)
+	}
+	if _, _, ok := c.get("c"); ok {
+		t.Fatalf("'c' was not evicted")
+	}
+
+	c.clearCache()
+	if c.size != 0 || c.lru.Len() != 0 {
+		t.Fatalf("Cache was not cleared: %d entries, %d bytes", c.lru.Len(), c.size)
+	}
+}
+
+func TestCacheTTLs(t *testing.T) {
+	c := newCache(defaultCacheTimeout)
+	mock := clock.NewMock()
+	c.clock = mock
+	c.set(stationStatusURL+"70011", d1)
+	c.set(timetableURL+"Local", d2)
+
+	mock.Add(time.Hour)
+	if _, _, ok := c.get(stationStatusURL + "70011"); ok {
+		t.Fatalf("The live status has not expired")
+	}
+	if _, _, ok := c.get(timetableURL + "Local"); !ok {
+		t.Fatalf("The timetable has expired")
+	}
+
+	c.ttls.setEndpoint(EndpointTimetable, 30*time.Minute)
+	if _, _, ok := c.get(timetableURL + "Local"); ok {
+		t.Fatalf("The timetable has not expired with a shorter TTL")
+	}
+}
+
+func TestCacheSweep(t *testing.T) {
+	c := newCache(defaultCacheTimeout)
+	mock := clock.NewMock()
+	c.clock = mock
+	c.set("a", d1)
+	c.set(holidaysURL, d2)
+	mock.Add(defaultCacheTimeout + time.Minute)
+	c.set("b", d3)
+
+	// 'a' expired a minute ago, within the grace period
+	if n := c.sweep(time.Hour); n != 0 {
+		t.Fatalf("Unexpected number of entries swept. Expected %d, received %d", 0, n)
+	}
+	if n := c.sweep(0); n != 1 {
+		t.Fatalf("Unexpected number of entries swept. Expected %d, received %d", 1, n)
+	}
+	if _, ok := c.cache["a"]; ok || len(c.cache) != 2 {
+		t.Fatalf("Unexpected entries after the sweep: %v", c.cache)
+	}
+}
+
+func TestRunCacheJanitor(t *testing.T) {
+	c := New(fakeKey)
+	c.SetupCache(defaultCacheTimeout)
+	c.SetMaxStale(0)
+	mock := clock.NewMock()
+	c.clock = mock
+	m := c.cache.(*caltrainCache)
+	m.clock = mock
+	m.set("a", d1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.RunCacheJanitor(ctx, time.Minute)
+		close(done)
+	}()
+	// advance until the janitor has swept the expired entry
+	for i := 0; i < 1000; i++ {
+		mock.Add(time.Minute)
+		m.lock.Lock()
+		n := len(m.cache)
+		m.lock.Unlock()
+		if n == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if len(m.cache) != 0 {
+		t.Fatalf("The janitor did not remove the expired entry")
+	}
+}
+
+func TestStaticCache(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	c := New(fakeKey)
+	c.APIClient = &apiClientCounter{file: "testdata/lines.json", calls: &calls}
+	c.SetupCache(defaultCacheTimeout)
+	mock := clock.NewMock()
+	c.cache.(*caltrainCache).clock = mock
+
+	for i := 0; i < 2; i++ {
+		if err := c.UpdateLines(ctx); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Unexpected number of API calls. Expected %d, received %d", 1, calls)
+	}
+
+	// the lines are kept far longer than the live status
+	mock.Add(time.Hour)
+	if err := c.UpdateLines(ctx); err != nil || calls != 1 {
+		t.Fatalf("Expected the cached lines, received %d calls: %v", calls, err)
+	}
+	c.SetCacheTTL(EndpointLines, 30*time.Minute)
+	if err := c.UpdateLines(ctx); err != nil || calls != 2 {
+		t.Fatalf("Expected a new request, received %d calls: %v", calls, err)
+	}
+}
@@ -7,36 +7,52 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/singleflight"
 )
 
 const (
-	delayURL         = "http://api.511.org/transit/StopMonitoring"
-	holidaysURL      = "http://api.511.org/transit/holidays"
-	linesURL         = "http://api.511.org/transit/lines"
-	stationsURL      = "http://api.511.org/transit/stops"
-	stationStatusURL = "http://api.511.org/transit/StopMonitoring"
-	timetableURL     = "http://api.511.org/transit/timetable"
+	delayURL         = DefaultBaseURL + "/transit/StopMonitoring"
+	holidaysURL      = DefaultBaseURL + "/transit/holidays"
+	linesURL         = DefaultBaseURL + "/transit/lines"
+	stationsURL      = DefaultBaseURL + "/transit/stops"
+	stationStatusURL = DefaultBaseURL + "/transit/StopMonitoring"
+	timetableURL     = DefaultBaseURL + "/transit/timetable"
+	tripUpdatesURL   = DefaultBaseURL + "/transit/tripupdates"
+	vehiclesURL      = DefaultBaseURL + "/transit/vehiclepositions"
+	serviceAlertsURL = DefaultBaseURL + "/transit/servicealerts"
 )
 
 // CaltrainClient provides the means for querying information about caltrain
 // schedules, getting route information between stations, or getting live train
 // status updates
 type CaltrainClient struct {
-	timetable  map[string][]timetableFrame // map of line name to slice of service journeys
-	dayService map[string][]string         // map of id to days of the week that the id corresponds to
-	ttLock     sync.RWMutex                // lock in case someone tries to access the timetable during an update
-	stations   map[Station]*stationInfo    // station information map
-	sLock      sync.RWMutex                // lock in case someone tries to access the stations during an update
-	lines      []Line                      // slice of available lines
-	lLock      sync.RWMutex                // lock in case someone tries to access the lines during an update
-	useCache   bool                        // set by calling the SetupCache method
-	holidays   []time.Time                 // slice of days that are on a holiday schedule
-	tz         *time.Location              // constant America/LosAngeles time
-	key        string                      // API key for 511.org
-	cache      cache                       // interface for caching recent request results
+	data          atomic.Pointer[Dataset] // timetable, stations, lines and holidays, swapped by update
+	dsLock        sync.Mutex              // lock to serialize the updates of data
+	stationPolicy UnknownStationPolicy    // handling of unknown stops in the stops feed
+	useCache      bool                    // set by calling the SetupCache method
+	tz            *time.Location          // constant America/LosAngeles time
+	key           string                  // API key for 511.org
+	cache         cache                   // interface for caching recent request results
+	liveFeed      LiveFeed                // feed used for live train status. Default SIRIFeed
+	maxAge        time.Duration           // age at which a loaded snapshot is flagged as stale
+	cacheTTLs     *cacheTTLs              // expiration of the cache entries of each endpoint
+	cacheEntries  int                     // maximum number of entries in the in-memory cache
+	cacheBytes    int64                   // maximum size of the in-memory cache
+	maxStale      time.Duration           // oldest cached live status returned on a failure
+	revalidate    bool                    // return stale live status while refreshing it in the background
+	revalidating  map[string]bool         // cache keys being refreshed in the background
+	rvLock        sync.Mutex              // lock for revalidating
+	rvWait        sync.WaitGroup          // background refreshes for unit testing
+	requests      singleflight.Group      // concurrent identical API calls
+	arStatus      AutoRefreshStatus       // status of the refreshes started by StartAutoRefresh
+	arLock        sync.Mutex              // lock for arStatus
+	arWait        sync.WaitGroup          // auto refresh goroutine for unit testing
+	clock         clock.Clock             // time package for unit testing
 
 	APIClient APIClient // API client for making caltrain queries. Default APIClient511
 }
@@ -44,15 +60,20 @@ type CaltrainClient struct {
 // New returns an instantiated CaltrainClient struct
 func New(key string) *CaltrainClient {
 	tz, _ := time.LoadLocation("America/Los_Angeles")
-	return &CaltrainClient{
-		timetable:  make(map[string][]timetableFrame),
-		dayService: make(map[string][]string),
-		stations:   make(map[Station]*stationInfo),
-		lines:      []Line{},
-		key:        key,
-		tz:         tz,
-		APIClient:  NewClient(),
-	}
+	c := &CaltrainClient{
+		key:          key,
+		tz:           tz,
+		maxAge:       defaultSnapshotMaxAge,
+		maxStale:     defaultMaxStale,
+		cacheTTLs:    newCacheTTLs(defaultCacheTimeout),
+		cacheEntries: defaultCacheMaxEntries,
+		cacheBytes:   defaultCacheMaxBytes,
+		revalidating: make(map[string]bool),
+		APIClient:    NewClient(),
+		clock:        clock.New(),
+	}
+	c.data.Store(newDataset())
+	return c
 }
 
 // Initialize makes the 511.org API calls to populate the stations and
@@ -80,185 +101,287 @@ func (c *CaltrainClient) Initialize(ctx context.Context) (e error) {
 // called before UpdateTimeTable to ensure the time table data is accurate
 func (c *CaltrainClient) UpdateLines(ctx context.Context) error {
 	logrus.Debug("Updating train lines...")
-	c.lLock.Lock()
-	defer c.lLock.Unlock()
-
 	query := map[string]string{
 		"operator_id": "CT",
 		"api_key":     c.key,
 	}
-	data, err := c.APIClient.Get(ctx, linesURL, query)
-	if err != nil {
-		return fmt.Errorf("failed to make 'update lines' request: %w", err)
-	}
-
-	lines, err := parseLines(data)
+	var lines []Line
+	_, err := c.getStatic(ctx, "update lines", linesURL, linesURL, query, func(data []byte) (err error) {
+		if lines, err = parseLines(data); err != nil {
+			return fmt.Errorf("failed to parse lines: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to parse lines: %w", err)
+		return err
 	}
 	if len(lines) == 0 {
 		return errors.New("unable to populate the lines: none found")
 	}
-	c.lines = lines
-	return nil
+	return c.update(func(d *Dataset) error {
+		d.lines = lines
+		return nil
+	})
 }
 
 // UpdateTimeTable makes an API call to refresh the timetable data. This
-// should be called periodically to ensure correct information.
+// should be called periodically to ensure correct information. The timetable
+// of every line is fetched before any of it is used, so a failure keeps the
+// previous timetable
 func (c *CaltrainClient) UpdateTimeTable(ctx context.Context) error {
 	logrus.Debug("Updating time tables...")
-	c.ttLock.Lock()
-	defer c.ttLock.Unlock()
-	// request the timetable for each line
-	for _, line := range c.lines {
+	lines := c.dataset().lines
+
+	timetable := make(map[string][]timetableFrame, len(lines))
+	dayService := make(map[string][]string)
+	patterns := [][]string{}
+	var fetched time.Time
+	for _, line := range lines {
 		logrus.Debugf("Fetching time table for %s-%s trains", line.Id, line.Name)
 		query := map[string]string{
 			"operator_id": "CT",
 			"line_id":     line.Id,
 			"api_key":     c.key,
 		}
-		data, err := c.APIClient.Get(ctx, timetableURL, query)
+		var journeys []timetableFrame
+		var services map[string][]string
+		var routes [][]string
+		t, err := c.getStatic(ctx, "update timetable", timetableURL+line.Id, timetableURL, query, func(data []byte) (err error) {
+			if journeys, services, routes, err = parseTimetable(data); err != nil {
+				return fmt.Errorf("failed to parse timetable: %w", err)
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("failed to make 'update timetable' request: %w", err)
+			return err
 		}
-
-		journeys, services, err := parseTimetable(data)
-		if err != nil {
-			return fmt.Errorf("failed to parse timetable: %w", err)
+		// the timetable is as old as its oldest line
+		if fetched.IsZero() || t.Before(fetched) {
+			fetched = t
 		}
-		// store the timetable
-		c.timetable[line.Id] = journeys
-
-		// overwrite the known data with the timetable's ServiceCalendarFrame
+		timetable[line.Id] = journeys
 		for key, value := range services {
-			c.dayService[key] = value
+			dayService[key] = value
 		}
+		patterns = append(patterns, routes...)
 	}
 
-	if len(c.timetable) == 0 {
+	if len(timetable) == 0 {
 		return errors.New("unable to populate the timetables: none found")
 	}
-
-	return nil
+	if fetched.IsZero() {
+		fetched = c.clock.Now()
+	}
+	return c.update(func(d *Dataset) error {
+		// overwrite the known data with the timetable's ServiceCalendarFrame
+		services := make(map[string][]string, len(d.dayService)+len(dayService))
+		for key, value := range d.dayService {
+			services[key] = value
+		}
+		for key, value := range dayService {
+			services[key] = value
+		}
+		d.timetable = timetable
+		d.dayService = services
+		d.patterns = patterns
+		d.trips = nil
+		d.fetched = fetched
+		return nil
+	})
 }
 
 // UpdateStations makes an API call to refresh the station information.
 // This should only need to be called during Initialization.
 func (c *CaltrainClient) UpdateStations(ctx context.Context) error {
 	logrus.Debug("Updating stations...")
-	c.sLock.Lock()
-	defer c.sLock.Unlock()
-
 	query := map[string]string{
 		"operator_id": "CT",
 		"api_key":     c.key,
 	}
-	data, err := c.APIClient.Get(ctx, stationsURL, query)
-	if err != nil {
-		return fmt.Errorf("failed to make 'update stations' request: %w", err)
-	}
-
-	stations, err := parseStations(data)
+	var stops []scheduledStopPoint
+	_, err := c.getStatic(ctx, "update stations", stationsURL, stationsURL, query, func(data []byte) (err error) {
+		if stops, err = parseStopPoints(data); err != nil {
+			return fmt.Errorf("failed to parse stations: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to parse stations: %w", err)
-	}
-	if len(stations) == 0 {
-		return errors.New("unable to populate the station list: none found")
+		return err
 	}
-	c.stations = stations
-	return nil
+	return c.update(func(d *Dataset) error {
+		// unknown stations are registered in the registry of the new Dataset
+		r := d.registry.clone()
+		stations, err := buildStations(stops, c.stationPolicy, r)
+		if err != nil {
+			return fmt.Errorf("failed to parse stations: %w", err)
+		}
+		if len(stations) == 0 {
+			return errors.New("unable to populate the station list: none found")
+		}
+		d.stations = stations
+		d.registry = r
+		return nil
+	})
 }
 
 // UpdateHolidays makes an API call to refresh the holiday data. This can
 // be updated multiple times a year so this should be called periodically.
 func (c *CaltrainClient) UpdateHolidays(ctx context.Context) error {
 	logrus.Debug("Updating holidays...")
-	c.sLock.Lock()
-	defer c.sLock.Unlock()
-
 	query := map[string]string{
 		"operator_id": "CT",
 		"api_key":     c.key,
 	}
-	data, err := c.APIClient.Get(ctx, holidaysURL, query)
+	var holidays []time.Time
+	_, err := c.getStatic(ctx, "update holidays", holidaysURL, holidaysURL, query, func(data []byte) (err error) {
+		if holidays, err = parseHolidays(data); err != nil {
+			return fmt.Errorf("failed to parse holidays: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to make 'update holidays' request: %w", err)
+		return err
 	}
+	return c.update(func(d *Dataset) error {
+		d.holidays = holidays
+		return nil
+	})
+}
 
-	holidays, err := parseHolidays(data)
+// getStatic makes the request for static data and passes the response to
+// parse. With caching enabled, an unexpired cached response is used instead
+// unless ctx is from WithRefresh, and a new response is only cached once it
+// parses. It returns the time the response was fetched. name describes the
+// request in errors
+func (c *CaltrainClient) getStatic(ctx context.Context, name, key, url string, query map[string]string, parse func([]byte) error) (time.Time, error) {
+	if c.useCache && !isRefresh(ctx) {
+		if body, t, ok := c.cache.get(key); ok {
+			return t, parse(body)
+		}
+	}
+
+	t := c.clock.Now()
+	data, err := c.get(ctx, url, query)
 	if err != nil {
-		return fmt.Errorf("failed to parse holidays: %w", err)
+		return t, fmt.Errorf("failed to make '%s' request: %w", name, err)
+	}
+	if err := parse(data); err != nil {
+		return t, err
 	}
-	c.holidays = holidays
-	return nil
+	if c.useCache {
+		c.cache.set(key, data)
+	}
+	return t, nil
 }
 
 // SetupCache enables the use of API caching to prevent going over the API
-// limit. Users set the caching expire time.
-func (c *CaltrainClient) SetupCache(expire time.Duration) {
-	c.cache = newCache(expire)
+// limit. Users set the caching expire time of the live status, and the static
+// data is kept for 24 hours unless changed with SetCacheTTL. The responses
+// are kept in memory unless a Cache backend is passed, such as a FileCache
+func (c *CaltrainClient) SetupCache(expire time.Duration, backend ...Cache) {
+	c.cacheTTLs.setDefault(expire)
+	if len(backend) > 0 && backend[0] != nil {
+		b := newBackendCache(backend[0], expire)
+		b.ttls = c.cacheTTLs
+		c.cache = b
+	} else {
+		m := newCache(expire)
+		m.ttls = c.cacheTTLs
+		m.setLimits(c.cacheEntries, c.cacheBytes)
+		c.cache = m
+	}
 	c.useCache = true
 }
 
+// SetCacheTTL sets how long the responses of an endpoint are cached. It
+// overrides the expiration passed to SetupCache for the live endpoints, and
+// the 24 hour default of the static ones
+func (c *CaltrainClient) SetCacheTTL(e Endpoint, ttl time.Duration) {
+	c.cacheTTLs.setEndpoint(e, ttl)
+}
+
+// SetCacheLimits sets the maximum number of responses and total bytes kept by
+// the in-memory cache. The least recently used responses are evicted when
+// either is exceeded, and zero means no limit. The defaults are 1000 responses
+// and 64 MiB. A Cache backend passed to SetupCache manages its own size
+func (c *CaltrainClient) SetCacheLimits(maxEntries int, maxBytes int64) {
+	c.cacheEntries, c.cacheBytes = maxEntries, maxBytes
+	if m, ok := c.cache.(*caltrainCache); ok {
+		m.setLimits(maxEntries, maxBytes)
+	}
+}
+
+// RunCacheJanitor removes expired responses from the in-memory cache every
+// interval until ctx is done. Responses within the max stale age set with
+// SetMaxStale are kept so they can still be returned when the API fails
+func (c *CaltrainClient) RunCacheJanitor(ctx context.Context, interval time.Duration) {
+	ticker := c.clock.Ticker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.useCache {
+				if n := c.cache.sweep(c.maxStale); n > 0 {
+					logrus.Debugf("Removed %d expired responses from the cache", n)
+				}
+			}
+		}
+	}
+}
+
 // GetDelays makes an API call and returns a slice of TrainStatus who's
 // delay into their next station is greater than the time.Duration argument
 func (c *CaltrainClient) GetDelays(ctx context.Context, threshold time.Duration) ([]TrainStatus, time.Time, error) {
 	logrus.Debug("Checking for delayed trains...")
+	trains, t, err := c.getLiveTrains(ctx)
+	if trains != nil {
+		trains = filterDelays(trains, threshold)
+	}
+	return trains, t, err
+}
+
+// getLiveTrains makes an API call and returns a slice of TrainStatus for
+// every train in the live feed
+func (c *CaltrainClient) getLiveTrains(ctx context.Context) ([]TrainStatus, time.Time, error) {
 	query := map[string]string{
 		"agency":  "CT",
 		"api_key": c.key,
 	}
-	t := time.Now()
-
-	var cacheData []TrainStatus
-	var cacheTime time.Time
-	var cacheError error
 
-	if c.useCache {
-		var data []byte
-		var ok bool
-		data, cacheTime, ok = c.cache.get(delayURL)
-		if ok {
-			c.lLock.RLock()
-			cacheData, cacheError = parseDelays(data, threshold, c.lines)
-			c.lLock.RUnlock()
-			return cacheData, cacheTime, cacheError
+	url := delayURL
+	parse := func(data []byte) (interface{}, error) {
+		trains, err := c.dataset().getTrains(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse delay data: %w", err)
 		}
-	}
-
-	data, err := c.APIClient.Get(ctx, delayURL, query)
-	if err != nil {
-		return nil, t, fmt.Errorf("failed to make 'get delays' request: %w", err)
-	}
-
-	// Now parse the body json string
-	c.lLock.RLock()
-	trains, err := parseDelays(data, threshold, c.lines)
-	c.lLock.RUnlock()
-	if err != nil {
-		if c.useCache {
-			var limErr *APILimitError
-			var apiErr *APIError
-			if errors.As(err, &limErr) || errors.As(err, &apiErr) {
-				return cacheData, cacheTime, err
+		return trains, nil
+	}
+	if c.liveFeed == GTFSRealtimeFeed {
+		url = tripUpdatesURL
+		vehicles := c.getVehiclePositions(ctx)
+		parse = func(data []byte) (interface{}, error) {
+			trains, err := c.parseGTFSRealtime("", data, vehicles)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse delay data: %w", err)
 			}
-			return cacheData, cacheTime, fmt.Errorf("failed to parse delay data: %w", err)
+			return trains, nil
 		}
-		return nil, t, fmt.Errorf("failed to parse delay data: %w", err)
 	}
 
-	if c.useCache {
-		c.cache.set(delayURL, data)
-	}
-	return trains, t, nil
+	v, t, err := c.fetchLive(ctx, "get delays", url, url, query, parse)
+	trains, _ := v.([]TrainStatus)
+	return trains, t, err
 }
 
 // GetStationStatus makes an API call and returns a slice of TrainsStatus
-// who have a status reported for the given station and direction.
-func (c *CaltrainClient) GetStationStatus(ctx context.Context, stationName Station, direction Direction) ([]TrainStatus, time.Time, error) {
+// who have a status reported for the given station and direction. The
+// options sort or filter the trains by how crowded they are
+func (c *CaltrainClient) GetStationStatus(ctx context.Context, stationName Station, direction Direction, opts ...StatusOption) ([]TrainStatus, time.Time, error) {
 	logrus.Debugf("Getting station status for %s...", stationName.String())
 	t := time.Now()
-	code, err := c.getStationCode(stationName, direction)
+	code, err := c.dataset().getStationCode(stationName, direction)
 	if err != nil {
 		return nil, t, fmt.Errorf("failed to get station code: %w", err)
 	}
@@ -268,48 +391,36 @@ func (c *CaltrainClient) GetStationStatus(ctx context.Context, stationName Stati
 		"api_key":  c.key,
 	}
 
-	var cacheData []TrainStatus
-	var cacheTime time.Time
-	var cacheError error
-
 	// cache key is stationStatusURL plus the stop code
-	if c.useCache {
-		var data []byte
-		var ok bool
-		data, cacheTime, ok = c.cache.get(stationStatusURL + code)
-		if ok {
-			c.lLock.RLock()
-			cacheData, cacheError = getTrains(data, c.lines)
-			c.lLock.RUnlock()
-			return cacheData, cacheTime, cacheError
+	url, key := stationStatusURL, stationStatusURL+code
+	parse := func(data []byte) (interface{}, error) {
+		trains, err := c.dataset().getTrains(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trains: %w", err)
 		}
-	}
-
-	data, err := c.APIClient.Get(ctx, stationStatusURL, query)
-	if err != nil {
-		return nil, t, fmt.Errorf("failed to make 'get station status' request: %w", err)
-	}
-
-	// Now parse the body json string
-	c.lLock.RLock()
-	trains, err := getTrains(data, c.lines)
-	c.lLock.RUnlock()
-	if err != nil {
-		if c.useCache {
-			var limErr *APILimitError
-			var apiErr *APIError
-			if errors.As(err, &limErr) || errors.As(err, &apiErr) {
-				return cacheData, cacheTime, err
+		return trains, nil
+	}
+	if c.liveFeed == GTFSRealtimeFeed {
+		// the trip updates contain every train, so the whole feed is cached
+		// and filtered by the stop code
+		url, key = tripUpdatesURL, tripUpdatesURL
+		delete(query, "stopCode")
+		vehicles := c.getVehiclePositions(ctx)
+		parse = func(data []byte) (interface{}, error) {
+			trains, err := c.parseGTFSRealtime(code, data, vehicles)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse trains: %w", err)
 			}
-			return cacheData, cacheTime, fmt.Errorf("failed to parse trains: %w", err)
+			return trains, nil
 		}
-		return nil, t, fmt.Errorf("failed to parse trains: %w", err)
 	}
 
-	if c.useCache {
-		c.cache.set(stationStatusURL+code, data)
+	v, t, err := c.fetchLive(ctx, "get station status", key, url, query, parse)
+	trains, _ := v.([]TrainStatus)
+	if trains != nil {
+		trains = filterStatus(trains, opts)
 	}
-	return trains, t, nil
+	return trains, t, err
 }
 
 // GetTrainsBetweenStationsForWeekday returns a slice of Routes that travel
@@ -317,17 +428,39 @@ func (c *CaltrainClient) GetStationStatus(ctx context.Context, stationName Stati
 // does not make an API call
 func (c *CaltrainClient) GetTrainsBetweenStationsForWeekday(ctx context.Context, src, dst Station, weekday time.Weekday) ([]*Route, error) {
 	logrus.Debugf("Getting trains between stations '%s' and '%s' for a '%s'", src.String(), dst.String(), weekday.String())
-	c.ttLock.RLock()
-	defer c.ttLock.RUnlock()
+	return c.dataset().getRoutesBetweenStations(src, dst, weekday)
+}
+
+// GetTrainsBetweenStationsForDate returns a slice of Routes that travel
+// from src to dst for a given date, sorted by departure time from src. It
+// uses the cached timetable and does not make an API call. It checks against
+// the known holidays. Date must be in the correct time zone
+func (c *CaltrainClient) GetTrainsBetweenStationsForDate(ctx context.Context, src, dst Station, date time.Time, opts ...QueryOption) ([]*Route, error) {
+	logrus.Debugf("Getting trains between stations '%s' and '%s' for %s", src.String(), dst.String(), date.Format("2006-01-02"))
+	d := c.dataset()
+	weekday := date.Weekday()
+	if d.isHoliday(date) {
+		weekday = time.Sunday
+	}
+	routes, err := d.getRoutesBetweenStations(src, dst, weekday)
+	if err != nil {
+		return routes, err
+	}
+	routes = filterRoutes(routes, date, opts, src, dst)
+	return routes, c.annotateQuery(ctx, routes, date, opts)
+}
 
-	journeys, err := c.getTrainRoutesBetweenStations(src, dst, weekday)
+// getRoutesBetweenStations returns a slice of Routes that travel from src to
+// dst on the given weekday
+func (d *Dataset) getRoutesBetweenStations(src, dst Station, weekday time.Weekday) ([]*Route, error) {
+	journeys, err := d.getTrainRoutesBetweenStations(src, dst, weekday)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Train Routes: %w", err)
 	}
 
 	routes := make([]*Route, len(journeys))
 	for i, journey := range journeys {
-		r, err := c.journeyToRoute(journey)
+		r, err := d.journeyToRoute(journey)
 		if err != nil {
 			return routes, fmt.Errorf("failed to get Train Routes: %w", err)
 		}
@@ -336,106 +469,114 @@ func (c *CaltrainClient) GetTrainsBetweenStationsForWeekday(ctx context.Context,
 	return routes, nil
 }
 
-// GetTrainsBetweenStationsForDate returns a slice of Routes that travel
-// from src to dst for a given date. It uses the cached timetable and does
-// not make an API call. It checks against the known holidays. Date must be
-// in the correct time zone
-func (c *CaltrainClient) GetTrainsBetweenStationsForDate(ctx context.Context, src, dst Station, date time.Time) ([]*Route, error) {
-	if c.IsHoliday(date) {
-		return c.GetTrainsBetweenStationsForWeekday(ctx, src, dst, time.Sunday)
-	}
-	return c.GetTrainsBetweenStationsForWeekday(ctx, src, dst, date.Weekday())
-}
-
 // IsHoliday returns true if the date passed in is a holiday
 func (c *CaltrainClient) IsHoliday(date time.Time) bool {
-	d := date.Truncate(24 * time.Hour)
-	c.sLock.RLock()
-	defer c.sLock.RUnlock()
-	for _, h := range c.holidays {
-		if d.Equal(h.Truncate(24 * time.Hour)) {
+	return c.dataset().isHoliday(date)
+}
+
+// isHoliday returns true if the date passed in is a holiday
+func (d *Dataset) isHoliday(date time.Time) bool {
+	day := date.Truncate(24 * time.Hour)
+	for _, h := range d.holidays {
+		if day.Equal(h.Truncate(24 * time.Hour)) {
 			return true
 		}
 	}
 	return false
 }
 
+// Holidays returns a slice of the days that are on a holiday schedule
+func (c *CaltrainClient) Holidays() []time.Time {
+	return c.dataset().Holidays()
+}
+
 // GetRoutesForAllStops works the same as GetTrainsBetweenStationsForDate
-// except many stations will be checked instead of just two
-func (c *CaltrainClient) GetRoutesForAllStops(ctx context.Context, stops []Station, dir Direction, date time.Time) ([]*Route, error) {
-	c.ttLock.RLock()
-	defer c.ttLock.RUnlock()
-
-	var d time.Weekday
-	if c.IsHoliday(date) {
-		d = time.Sunday
+// except many stations will be checked instead of just two. The source and
+// destination of each route are the first and last of the stops it reaches
+func (c *CaltrainClient) GetRoutesForAllStops(ctx context.Context, stops []Station, dir Direction, date time.Time, opts ...QueryOption) ([]*Route, error) {
+	d := c.dataset()
+	var day time.Weekday
+	if d.isHoliday(date) {
+		day = time.Sunday
 	} else {
-		d = date.Weekday()
+		day = date.Weekday()
 	}
 
-	journeys, err := c.getTrainRoutesForAllStops(stops, dir, d)
+	journeys, err := d.getTrainRoutesForAllStops(stops, dir, day)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Train Routes: %w", err)
 	}
 
 	routes := make([]*Route, len(journeys))
 	for i, journey := range journeys {
-		r, err := c.journeyToRoute(journey)
+		r, err := d.journeyToRoute(journey)
 		if err != nil {
 			return routes, fmt.Errorf("failed to get Train Routes: %w", err)
 		}
 		routes[i] = r
 	}
-	return routes, nil
+	routes = filterRoutes(routes, date, opts, stops...)
+	return routes, c.annotateQuery(ctx, routes, date, opts)
 }
 
 // GetStationTimetable returns the routes that stop at a given station in the
-// given direction
-func (c *CaltrainClient) GetStationTimetable(st Station, dir Direction, date time.Time) ([]*Route, error) {
-	c.ttLock.RLock()
-	defer c.ttLock.RUnlock()
+// given direction, sorted by departure time from the station. It is the same
+// as GetStationTimetableContext with a background context
+func (c *CaltrainClient) GetStationTimetable(st Station, dir Direction, date time.Time, opts ...QueryOption) ([]*Route, error) {
+	return c.GetStationTimetableContext(context.Background(), st, dir, date, opts...)
+}
 
-	code, err := c.getStationCode(st, dir)
+// GetStationTimetableContext returns the routes that stop at a given station
+// in the given direction, sorted by departure time from the station. ctx is
+// used by the API call of the WithAlerts option
+func (c *CaltrainClient) GetStationTimetableContext(ctx context.Context, st Station, dir Direction, date time.Time, opts ...QueryOption) ([]*Route, error) {
+	routes, err := c.dataset().getStationTimetable(st, dir, date, opts)
+	if err != nil {
+		return routes, err
+	}
+	return routes, c.annotateQuery(ctx, routes, date, opts)
+}
+
+// getStationTimetable returns the routes that stop at a given station in the
+// given direction, sorted by departure time from the station
+func (d *Dataset) getStationTimetable(st Station, dir Direction, date time.Time, opts []QueryOption) ([]*Route, error) {
+	code, err := d.getStationCode(st, dir)
 	if err != nil {
 		return nil, err
 	}
 	weekday := date.Weekday()
-	if c.IsHoliday(date) {
+	if d.isHoliday(date) {
 		weekday = time.Sunday
 	}
-	journeys, err := c.getTimetableForStation(code, dir, weekday)
+	journeys, err := d.getTimetableForStation(code, dir, weekday)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Train Routes: %w", err)
 	}
 
 	routes := make([]*Route, len(journeys))
 	for i, journey := range journeys {
-		r, err := c.journeyToRoute(journey)
+		r, err := d.journeyToRoute(journey)
 		if err != nil {
 			return routes, fmt.Errorf("failed to get Train Routes: %w", err)
 		}
 		routes[i] = r
 	}
-	return routes, nil
+	return filterRoutes(routes, date, opts, st), nil
 }
 
 // GetTrainRoute returns the Route for a given train
 func (c *CaltrainClient) GetTrainRoute(trainNum string) (*Route, error) {
-	c.ttLock.RLock()
-	defer c.ttLock.RUnlock()
-	journey, err := c.getRouteForTrain(trainNum)
+	d := c.dataset()
+	journey, err := d.getRouteForTrain(trainNum)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Train Route: %w", err)
 	}
-	return c.journeyToRoute(journey)
+	return d.journeyToRoute(journey)
 }
 
 // getStationCode returns the code for a given station and direction
-func (c *CaltrainClient) getStationCode(st Station, dir Direction) (string, error) {
-	// first validate the direction
-	c.sLock.RLock()
-	defer c.sLock.RUnlock()
-	station, ok := c.stations[st]
+func (d *Dataset) getStationCode(st Station, dir Direction) (string, error) {
+	station, ok := d.stations[st]
 	if !ok {
 		return "", fmt.Errorf("unknown station %s", st)
 	}
@@ -450,12 +591,8 @@ func (c *CaltrainClient) getStationCode(st Station, dir Direction) (string, erro
 }
 
 // getLine returns a Line struc for a given line ID
-func (c *CaltrainClient) getLine(id string) (Line, error) {
-	// first validate the direction
-	c.lLock.RLock()
-	defer c.lLock.RUnlock()
-
-	for _, l := range c.lines {
+func (d *Dataset) getLine(id string) (Line, error) {
+	for _, l := range d.lines {
 		if l.Id == id {
 			return l, nil
 		}
@@ -466,19 +603,17 @@ func (c *CaltrainClient) getLine(id string) (Line, error) {
 
 // getRouteCodes returns the proper station codes for a route given a
 // source and destination station name
-func (c *CaltrainClient) getRouteCodes(src, dst Station) (string, string, error) {
-	c.sLock.RLock()
-	defer c.sLock.RUnlock()
-	srcSt, ok := c.stations[src]
+func (d *Dataset) getRouteCodes(src, dst Station) (string, string, error) {
+	srcSt, ok := d.stations[src]
 	if !ok {
 		return "", "", fmt.Errorf("unknown station %s", src)
 	}
-	dstSt, ok := c.stations[dst]
+	dstSt, ok := d.stations[dst]
 	if !ok {
 		return "", "", fmt.Errorf("unknown station %s", dst)
 	}
 
-	dir, err := GetDirectionFromSrcToDst(src, dst)
+	dir, err := d.GetDirectionFromSrcToDst(src, dst)
 	if err != nil {
 		return "", "", err
 	}
@@ -491,10 +626,8 @@ func (c *CaltrainClient) getRouteCodes(src, dst Station) (string, string, error)
 }
 
 // journeyToRoute converts a timetableRouteJourney into a Route
-func (c *CaltrainClient) journeyToRoute(r timetableRouteJourney) (*Route, error) {
-	c.lLock.RLock()
-	line, err := parseLine(r.Line, c.lines)
-	c.lLock.RUnlock()
+func (d *Dataset) journeyToRoute(r timetableRouteJourney) (*Route, error) {
+	line, err := parseLine(r.Line, d.lines)
 	if err != nil {
 		return nil, err
 	}
@@ -528,7 +661,7 @@ func (c *CaltrainClient) journeyToRoute(r timetableRouteJourney) (*Route, error)
 		}
 		t := TrainStop{
 			Order:     order,
-			Station:   c.getStationFromCode(s.ScheduledStopPointRef.Ref),
+			Station:   d.getStationFromCode(s.ScheduledStopPointRef.Ref),
 			Arrival:   arr,
 			Departure: dep,
 		}
@@ -539,46 +672,58 @@ func (c *CaltrainClient) journeyToRoute(r timetableRouteJourney) (*Route, error)
 
 // getStationFromCode returns the station name associated with the code
 // TODO: unit test this
-func (c *CaltrainClient) getStationFromCode(code string) Station {
-	c.sLock.RLock()
-	defer c.sLock.RUnlock()
-	for name, st := range c.stations {
-		if st.northCode == code || st.southCode == code {
-			return name
-		}
+func (d *Dataset) getStationFromCode(code string) Station {
+	return d.codes[code]
+}
+
+// RateLimitStatus returns the remaining 511.org request budget of the
+// APIClient. It returns false if the APIClient does not track the budget
+func (c *CaltrainClient) RateLimitStatus() (RateLimitStatus, bool) {
+	limited, ok := c.APIClient.(interface{ RateLimitStatus() RateLimitStatus })
+	if !ok {
+		return RateLimitStatus{}, false
 	}
-	return 0
+	return limited.RateLimitStatus(), true
 }
 
 // AllLines returns a slice of all available train lines
 func (c *CaltrainClient) AllLines() []Line {
-	c.lLock.RLock()
-	defer c.lLock.RUnlock()
-	return c.lines
+	return c.dataset().Lines()
 }
 
 // GetDirectionFromSrcToDst returns the direction the train would go to get
-// from src to dst. Value is either North or South
+// from src to dst. Value is either North or South. Only the well known
+// stations are accepted, see the GetDirectionFromSrcToDst method of a client
 func GetDirectionFromSrcToDst(src, dst Station) (Direction, error) {
+	return newStationRegistry().direction(src, dst)
+}
+
+// direction returns the direction from src to dst by the order of the
+// stations from north to south
+func (r *stationRegistry) direction(src, dst Station) (Direction, error) {
 	var dir Direction
 	if src == dst {
-		return dir, fmt.Errorf("The stations are the same: %s to %s", src, dst)
+		return dir, fmt.Errorf("The stations are the same: %s to %s", r.name(src), r.name(dst))
+	}
+	srcPos, srcOk := r.position(src)
+	dstPos, dstOk := r.position(dst)
+	if !srcOk || !dstOk {
+		return dir, fmt.Errorf("could not determine direction from %s to %s", r.name(src), r.name(dst))
 	}
-	// Station is an int, with the southern station having a larger value than
-	// the northern station
-	if src > dst {
+	if srcPos > dstPos {
 		return North, nil
-	} else if dst > src {
+	} else if dstPos > srcPos {
 		return South, nil
 	} else {
-		return dir, fmt.Errorf("could not determine direction from %s to %s", src, dst)
+		return dir, fmt.Errorf("could not determine direction from %s to %s", r.name(src), r.name(dst))
 	}
 }
 
-// GetStations returns a slice of all recognized stations in order from North
-// to South
+// GetStations returns a slice of the well known stations in order from North
+// to South. The stations of the loaded data, including the ones registered
+// from the stops feed, are returned by the Stations method of the Dataset
 func GetStations() []Station {
-	return stationSlice
+	return newStationRegistry().stations()
 }
 
 // getDirFromChar returns the proper direction string for a given character.
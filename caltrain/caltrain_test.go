@@ -22,6 +22,23 @@ var allLines []Line = []Line{
 	{Id: "Special", Name: "Special"},
 }
 
+// setLines replaces the lines of the client's dataset
+func setLines(c *CaltrainClient, lines []Line) {
+	c.update(func(d *Dataset) error {
+		d.lines = lines
+		return nil
+	})
+}
+
+// keepTimetable removes every line but id from the client's timetable. The
+// mock API client returns the same schedule for every line
+func keepTimetable(c *CaltrainClient, id string) {
+	c.update(func(d *Dataset) error {
+		d.timetable = map[string][]timetableFrame{id: d.timetable[id]}
+		return nil
+	})
+}
+
 func TestGetStations(t *testing.T) {
 	exp := map[Station]struct{}{
 		Station22ndStreet:   {},
@@ -71,7 +88,7 @@ func TestGetStations(t *testing.T) {
 func TestGetTrainRoute(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -84,11 +101,7 @@ func TestGetTrainRoute(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	exp := &Route{
 		TrainNum:  "801",
@@ -127,7 +140,7 @@ func TestGetTrainRoute(t *testing.T) {
 func TestGetTrainsBetweenStationsForWeekday(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -140,11 +153,7 @@ func TestGetTrainsBetweenStationsForWeekday(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	tests := []struct {
 		src  Station
@@ -193,7 +202,7 @@ func TestGetTrainsBetweenStationsForWeekday(t *testing.T) {
 func TestGetTrainsBetweenStationsForDate(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -210,11 +219,7 @@ func TestGetTrainsBetweenStationsForDate(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	tests := []struct {
 		name string
@@ -262,7 +267,7 @@ func TestGetTrainsBetweenStationsForDate(t *testing.T) {
 func TestGetDelays(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	tests := []struct {
 		name   string
 		data   string
@@ -296,7 +301,7 @@ func TestGetDelays(t *testing.T) {
 func TestGetDelaysCache(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/parseDelayData1.json"
 	c.APIClient = m
@@ -346,7 +351,7 @@ func TestGetDelaysCache(t *testing.T) {
 func TestGetStationStatus(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/stations.json"
 	c.APIClient = m
@@ -363,7 +368,7 @@ func TestGetStationStatus(t *testing.T) {
 func TestGetStationStatusCache(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/stations.json"
 	c.APIClient = m
@@ -446,7 +451,7 @@ func TestUpdateTimeTable(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			c := New(fakeKey)
-			c.lines = allLines
+			setLines(c, allLines)
 			m := &apiClientMock{}
 			m.GetResultFilePath = tt.filepath
 			c.APIClient = m
@@ -462,7 +467,7 @@ func TestUpdateTimeTable(t *testing.T) {
 func TestGetStationTimetable(t *testing.T) {
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/stations.json"
 	c.APIClient = m
@@ -0,0 +1,107 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cassette.go contains the APIClients that record responses to a directory
+// and serve them back, for tests that need realistic 511.org traffic
+
+// cassetteExt is the extension of the files written by a RecordingClient
+const cassetteExt = ".cassette"
+
+// RecordingClient is an APIClient that passes each request to another
+// APIClient and writes the successful responses to a cassette directory for a
+// ReplayClient to serve. The api_key is not part of the file names, so the
+// directory can be shared without leaking the key
+type RecordingClient struct {
+	client APIClient
+	dir    string
+}
+
+// NewRecordingClient returns a RecordingClient that records the responses of
+// client to dir, creating it if it doesn't exist
+func NewRecordingClient(client APIClient, dir string) (*RecordingClient, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	return &RecordingClient{client: client, dir: dir}, nil
+}
+
+// Get makes the request with the wrapped client and records the response.
+// Errors are returned without being recorded
+func (r *RecordingClient) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	body, err := r.client.Get(ctx, url, query)
+	if err != nil {
+		return body, err
+	}
+	path := filepath.Join(r.dir, cassetteName(url, query))
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		return body, fmt.Errorf("failed to record response: %w", err)
+	}
+	return body, nil
+}
+
+// ReplayClient is an APIClient that serves the responses recorded by a
+// RecordingClient. Requests that were not recorded return an error that
+// matches os.ErrNotExist
+type ReplayClient struct {
+	dir string
+}
+
+// NewReplayClient returns a ReplayClient that serves the responses in dir
+func NewReplayClient(dir string) *ReplayClient {
+	return &ReplayClient{dir: dir}
+}
+
+// Get returns the recorded response for the endpoint and query
+func (r *ReplayClient) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	name := cassetteName(url, query)
+	body, err := ioutil.ReadFile(filepath.Join(r.dir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no recording for %s: %w", name, os.ErrNotExist)
+		}
+		return nil, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return body, nil
+}
+
+// cassetteName returns the file name for a request. It is the segments of the
+// endpoint path joined by commas, then an @ and the sorted query parameters
+// without the api_key joined by ampersands, for example
+// transit,timetable@line_id=Local&operator_id=CT.cassette. Every segment, key
+// and value is query escaped, so the separators can't appear in them and two
+// requests never share a name. The host is left out so recordings can be
+// replayed against any base url
+func cassetteName(rawURL string, query map[string]string) string {
+	path := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		path = u.Path
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, s := range segments {
+		segments[i] = url.QueryEscape(s)
+	}
+
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		if k != "api_key" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = url.QueryEscape(k) + "=" + url.QueryEscape(query[k])
+	}
+	return strings.Join(segments, ",") + "@" + strings.Join(pairs, "&") + cassetteExt
+}
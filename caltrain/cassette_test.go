@@ -0,0 +1,126 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// apiClientRouter returns the testdata file for each endpoint and line
+type apiClientRouter struct{}
+
+func (apiClientRouter) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	files := map[string]string{
+		linesURL:    "testdata/lines.json",
+		stationsURL: "testdata/stations.json",
+		holidaysURL: "testdata/holiday.json",
+	}
+	lines := map[string]string{
+		"Local": "testdata/localSchedule.json",
+		"LTD A": "testdata/limitedASchedule.json",
+		"LTD B": "testdata/limitedBSchedule.json",
+	}
+	file, ok := files[url]
+	if url == timetableURL {
+		file, ok = lines[query["line_id"]]
+	}
+	if !ok {
+		return nil, &APIError{Status: "404 Not Found", Code: 404, Url: url, Query: query}
+	}
+	return ioutil.ReadFile(file)
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	rec, err := NewRecordingClient(apiClientRouter{}, dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c := New(fakeKey)
+	c.APIClient = rec
+	if err := c.Initialize(ctx); err != nil {
+		t.Fatalf("Unexpected error recording: %v", err)
+	}
+
+	// one file per endpoint and line, none containing the key
+	files, err := filepath.Glob(filepath.Join(dir, "*"+cassetteExt))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(files) != 6 {
+		t.Fatalf("Unexpected number of recordings. Expected %d, received %d: %v", 6, len(files), files)
+	}
+	for _, f := range files {
+		if strings.Contains(f, fakeKey) || strings.Contains(f, "api_key") {
+			t.Fatalf("The api key was recorded in %s", f)
+		}
+	}
+
+	// a new client replays every line from the recordings
+	replay := New("another-key")
+	replay.APIClient = NewReplayClient(dir)
+	if err := replay.Initialize(ctx); err != nil {
+		t.Fatalf("Unexpected error replaying: %v", err)
+	}
+	for _, id := range []string{"Local", "LTD A", "LTD B"} {
+		if len(replay.dataset().timetable[id]) == 0 {
+			t.Fatalf("The timetable for %s was not replayed", id)
+		}
+	}
+	if len(replay.Holidays()) != len(c.Holidays()) {
+		t.Fatalf("Unexpected holidays. Expected %v, received %v", c.Holidays(), replay.Holidays())
+	}
+
+	_, err = replay.APIClient.Get(ctx, delayURL, map[string]string{"agency": "CT"})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected a missing recording error, received %v", err)
+	}
+}
+
+func TestCassetteName(t *testing.T) {
+	testCases := []struct {
+		url      string
+		query    map[string]string
+		expected string
+	}{
+		{linesURL, map[string]string{"operator_id": "CT", "api_key": fakeKey}, "transit,lines@operator_id=CT.cassette"},
+		{timetableURL, map[string]string{"line_id": "LTD A", "operator_id": "CT"}, "transit,timetable@line_id=LTD+A&operator_id=CT.cassette"},
+		{"http://localhost:8511/transit/lines", nil, "transit,lines@.cassette"},
+	}
+	for _, tc := range testCases {
+		if name := cassetteName(tc.url, tc.query); name != tc.expected {
+			t.Fatalf("Unexpected name. Expected %s, received %s", tc.expected, name)
+		}
+	}
+}
+
+func TestCassetteNameCollisions(t *testing.T) {
+	requests := []struct {
+		url   string
+		query map[string]string
+	}{
+		{"a/b", map[string]string{"c": "d"}},
+		{"a_b", map[string]string{"c": "d"}},
+		{"a", map[string]string{"b_c": "d"}},
+		{"a", map[string]string{"b": "c_d"}},
+		{"a", map[string]string{"b": "c", "d": "e"}},
+		{"a", map[string]string{"b": "c&d=e"}},
+		{"a,b", nil},
+		{"a@b", nil},
+		{"a//b", nil},
+	}
+	seen := make(map[string]int)
+	for i, r := range requests {
+		name := cassetteName(r.url, r.query)
+		if j, ok := seen[name]; ok {
+			t.Fatalf("Requests %d and %d share the name %s", j, i, name)
+		}
+		seen[name] = i
+	}
+}
@@ -0,0 +1,123 @@
+package caltrain
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client_options.go contains the options for configuring the http client and
+// endpoints of an APIClient511
+
+const (
+	// DefaultBaseURL is the scheme and host of the 511.org API
+	DefaultBaseURL = "https://api.511.org"
+	// DefaultUserAgent is the User-Agent header sent with each request
+	DefaultUserAgent = "go-caltrain"
+	// defaultClientTimeout is the time limit of a single request
+	defaultClientTimeout = 30 * time.Second
+)
+
+// A ClientOption configures an APIClient511 created with NewClient
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	client    *http.Client
+	transport http.RoundTripper
+	baseURL   string
+	timeout   time.Duration
+	setTime   bool // set by WithTimeout, so a zero timeout disables the default
+	userAgent string
+	proxy     *url.URL
+}
+
+// WithHTTPClient makes requests with c instead of a new http.Client. The
+// client is copied, so later changes to c have no effect
+func WithHTTPClient(c *http.Client) ClientOption {
+	return func(o *clientOptions) {
+		o.client = c
+	}
+}
+
+// WithTransport makes requests with rt, for example to add logging or to
+// serve responses from memory in tests
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(o *clientOptions) {
+		o.transport = rt
+	}
+}
+
+// WithBaseURL sends the requests to base instead of DefaultBaseURL, for
+// example a local server standing in for 511.org. Any path on base is
+// prefixed to the API paths
+func WithBaseURL(base string) ClientOption {
+	return func(o *clientOptions) {
+		o.baseURL = strings.TrimSuffix(base, "/")
+	}
+}
+
+// WithTimeout sets the time limit of each request, including reading the
+// body. The default is 30 seconds, or the Timeout of the client passed to
+// WithHTTPClient, and zero means no limit
+func WithTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.timeout = d
+		o.setTime = true
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with each request
+func WithUserAgent(ua string) ClientOption {
+	return func(o *clientOptions) {
+		o.userAgent = ua
+	}
+}
+
+// WithProxy sends the requests through the proxy at u. Without this option
+// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used. It
+// has no effect if the transport is not an *http.Transport
+func WithProxy(u *url.URL) ClientOption {
+	return func(o *clientOptions) {
+		o.proxy = u
+	}
+}
+
+// httpClient builds the http.Client from the options
+func (o *clientOptions) httpClient() *http.Client {
+	c := &http.Client{}
+	if o.client != nil {
+		copied := *o.client
+		c = &copied
+	}
+	if o.transport != nil {
+		c.Transport = o.transport
+	}
+	if o.proxy != nil {
+		rt := c.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		if t, ok := rt.(*http.Transport); ok {
+			t = t.Clone()
+			t.Proxy = http.ProxyURL(o.proxy)
+			c.Transport = t
+		}
+	}
+	switch {
+	case o.setTime:
+		c.Timeout = o.timeout
+	case o.client == nil:
+		c.Timeout = defaultClientTimeout
+	}
+	return c
+}
+
+// rewriteURL replaces DefaultBaseURL at the start of u with the configured
+// base url. Other urls are left as they are
+func (a *APIClient511) rewriteURL(u string) string {
+	if a.baseURL == DefaultBaseURL || !strings.HasPrefix(u, DefaultBaseURL) {
+		return u
+	}
+	return a.baseURL + strings.TrimPrefix(u, DefaultBaseURL)
+}
@@ -0,0 +1,39 @@
+package caltrain
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// coalesce.go contains the request coalescing that shares one API call
+// between concurrent identical requests
+
+// get makes an API call with the APIClient. Concurrent calls for the same url,
+// query and priority wait for a single request and share its result, so a
+// burst of cache misses only uses one request of the rate limit. Calls at
+// different priorities don't share a request, since a low priority request
+// can be refused when the budget is low. The request is not
+// canceled when one of the callers' contexts is done, but that caller stops
+// waiting for it
+func (c *CaltrainClient) get(ctx context.Context, u string, query map[string]string) ([]byte, error) {
+	ch := c.requests.DoChan(requestKey(priorityFrom(ctx), u, query), func() (interface{}, error) {
+		return c.APIClient.Get(context.WithoutCancel(ctx), u, query)
+	})
+	select {
+	case res := <-ch:
+		body, _ := res.Val.([]byte)
+		return body, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// requestKey identifies a request by its priority, url and query
+func requestKey(p Priority, u string, query map[string]string) string {
+	v := make(url.Values, len(query))
+	for k, val := range query {
+		v.Set(k, val)
+	}
+	return strconv.Itoa(int(p)) + " " + u + "?" + v.Encode()
+}
@@ -0,0 +1,185 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// apiClientBlocking returns the file for every request once release is
+// closed, and counts the requests
+type apiClientBlocking struct {
+	file    string
+	calls   int32
+	started chan struct{} // closed by the first request
+	release chan struct{}
+	once    sync.Once
+}
+
+func newAPIClientBlocking(file string) *apiClientBlocking {
+	return &apiClientBlocking{file: file, started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (a *apiClientBlocking) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	atomic.AddInt32(&a.calls, 1)
+	a.once.Do(func() { close(a.started) })
+	<-a.release
+	return ioutil.ReadFile(a.file)
+}
+
+// runConcurrently calls f from n goroutines and releases the API client once
+// they have all had time to join the first request
+func runConcurrently(t *testing.T, a *apiClientBlocking, n int, f func() error) {
+	t.Helper()
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- f() }()
+	}
+	<-a.started
+	time.Sleep(50 * time.Millisecond)
+	close(a.release)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&a.calls); n != 1 {
+		t.Fatalf("Unexpected number of API calls. Expected %d, received %d", 1, n)
+	}
+}
+
+func TestCoalesceRequests(t *testing.T) {
+	ctx := context.Background()
+	const n = 10
+
+	t.Run("GetStationStatus", func(t *testing.T) {
+		c := New(fakeKey)
+		setLines(c, allLines)
+		c.APIClient = &apiClientMock{GetResultFilePath: "testdata/stations.json"}
+		if err := c.UpdateStations(ctx); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		c.SetupCache(defaultCacheTimeout)
+		a := newAPIClientBlocking("testdata/parseHillsdaleNorth.json")
+		c.APIClient = a
+		runConcurrently(t, a, n, func() error {
+			_, _, err := c.GetStationStatus(ctx, StationHillsdale, North)
+			return err
+		})
+	})
+
+	t.Run("GetDelays", func(t *testing.T) {
+		c := New(fakeKey)
+		setLines(c, allLines)
+		a := newAPIClientBlocking("testdata/parseDelayData1.json")
+		c.APIClient = a
+		runConcurrently(t, a, n, func() error {
+			d, _, err := c.GetDelays(ctx, defaultDelayThreshold)
+			if err == nil && len(d) != 2 {
+				return errors.New("unexpected number of delays")
+			}
+			return err
+		})
+	})
+
+	t.Run("UpdateLines", func(t *testing.T) {
+		c := New(fakeKey)
+		a := newAPIClientBlocking("testdata/lines.json")
+		c.APIClient = a
+		runConcurrently(t, a, n, func() error { return c.UpdateLines(ctx) })
+		if len(c.AllLines()) != 3 {
+			t.Fatalf("Unexpected lines: %v", c.AllLines())
+		}
+	})
+
+	t.Run("UpdateTimeTable", func(t *testing.T) {
+		c := New(fakeKey)
+		setLines(c, []Line{{Id: "Local", Name: "Local"}})
+		a := newAPIClientBlocking("testdata/localSchedule.json")
+		c.APIClient = a
+		runConcurrently(t, a, n, func() error { return c.UpdateTimeTable(ctx) })
+	})
+}
+
+func TestCoalesceCancel(t *testing.T) {
+	c := New(fakeKey)
+	setLines(c, allLines)
+	a := newAPIClientBlocking("testdata/parseDelayData1.json")
+	c.APIClient = a
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.GetDelays(context.Background(), defaultDelayThreshold)
+		done <- err
+	}()
+	<-a.started
+
+	// a caller that gives up doesn't cancel the shared request
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := c.GetDelays(ctx, defaultDelayThreshold); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a canceled error, received %v", err)
+	}
+	close(a.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+// apiClientPriority refuses low priority requests once release is closed, as
+// the rate limiter does when the budget is low, and counts the requests
+type apiClientPriority struct {
+	calls   int32
+	started chan struct{} // closed by the first request
+	release chan struct{}
+	once    sync.Once
+}
+
+func (a *apiClientPriority) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	atomic.AddInt32(&a.calls, 1)
+	a.once.Do(func() { close(a.started) })
+	<-a.release
+	if priorityFrom(ctx) == PriorityLow {
+		return nil, &APILimitError{RetryAfter: time.Minute}
+	}
+	return ioutil.ReadFile("testdata/parseDelayData1.json")
+}
+
+func TestCoalescePriority(t *testing.T) {
+	c := New(fakeKey)
+	a := &apiClientPriority{started: make(chan struct{}), release: make(chan struct{})}
+	c.APIClient = a
+	query := map[string]string{"agency": "CT"}
+
+	low := make(chan error, 1)
+	go func() {
+		_, err := c.get(context.Background(), delayURL, query)
+		low <- err
+	}()
+	<-a.started
+
+	// a high priority call doesn't join the low priority request, so it
+	// isn't refused with it
+	high := make(chan error, 1)
+	go func() {
+		_, err := c.get(WithPriority(context.Background(), PriorityHigh), delayURL, query)
+		high <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(a.release)
+
+	if err := <-high; err != nil {
+		t.Fatalf("Unexpected error for the high priority call: %v", err)
+	}
+	var limErr *APILimitError
+	if err := <-low; !errors.As(err, &limErr) {
+		t.Fatalf("Expected an APILimitError for the low priority call, received %v", err)
+	}
+	if n := atomic.LoadInt32(&a.calls); n != 2 {
+		t.Fatalf("Unexpected number of API calls. Expected %d, received %d", 2, n)
+	}
+}
@@ -0,0 +1,237 @@
+package caltrain
+
+import (
+	"fmt"
+	"time"
+)
+
+// dataset.go contains the Dataset, the static data that the queries are
+// answered from. A Dataset is never modified once it is in use. Updates build
+// a new one off to the side and swap it in, so queries never wait on the
+// network and never see a mix of old and new data
+
+// Dataset is the static data of a CaltrainClient: the timetable, stations,
+// lines and holidays. It is immutable, so the values returned by its methods
+// agree with each other even if the client is updated in between
+type Dataset struct {
+	timetable  map[string][]timetableFrame // map of line name to slice of service journeys
+	dayService map[string][]string         // map of id to days of the week that the id corresponds to
+	patterns   [][]string                  // stop codes of the timetable routes, from north to south
+	trips      map[string]string           // map of GTFS trip ID to train number, nil if they are the same
+	stations   map[Station]*stationInfo    // station information map
+	codes      map[string]Station          // map of stop code to station
+	order      []Station                   // stations from north to south
+	routed     map[Station]bool            // stations that the timetable routes stop at
+	registry   *stationRegistry            // names and order of the stations, including the registered ones
+	lines      []Line                      // slice of available lines
+	holidays   []time.Time                 // slice of days that are on a holiday schedule
+	fetched    time.Time                   // time the timetable was last fetched
+}
+
+// newDataset returns an empty Dataset
+func newDataset() *Dataset {
+	return &Dataset{
+		timetable:  make(map[string][]timetableFrame),
+		dayService: make(map[string][]string),
+		stations:   make(map[Station]*stationInfo),
+		codes:      make(map[string]Station),
+		registry:   newStationRegistry(),
+		lines:      []Line{},
+	}
+}
+
+// Dataset returns the current static data. It is not changed by later
+// updates, so several queries can be made against the same data
+func (c *CaltrainClient) Dataset() *Dataset {
+	return c.dataset()
+}
+
+// dataset returns the current Dataset without blocking
+func (c *CaltrainClient) dataset() *Dataset {
+	return c.data.Load()
+}
+
+// update applies fn to a copy of the current Dataset, then indexes and
+// validates the copy and swaps it in. fn must replace the fields it changes
+// rather than modify them, since readers may still be using the current
+// Dataset. Updates are serialized, and the current Dataset is kept if fn or
+// the validation fails
+func (c *CaltrainClient) update(fn func(d *Dataset) error) error {
+	c.dsLock.Lock()
+	defer c.dsLock.Unlock()
+
+	d := *c.dataset()
+	if err := fn(&d); err != nil {
+		return err
+	}
+	if err := d.index(); err != nil {
+		return fmt.Errorf("invalid dataset: %w", err)
+	}
+	d.registry = d.registry.place(d.order, d.routed)
+	c.data.Store(&d)
+	return nil
+}
+
+// index resolves the platforms of the stations against the timetable, and
+// places the stations that are new to the registry on the line from the
+// routes of the timetable. It returns an error if two stations share a stop
+// code
+func (d *Dataset) index() error {
+	patterns := d.patterns
+	if len(patterns) == 0 {
+		patterns = journeyPatterns(d.timetable)
+	}
+	served := make(map[string]bool)
+	for _, p := range patterns {
+		for _, code := range p {
+			served[code] = true
+		}
+	}
+
+	stations := make(map[Station]*stationInfo, len(d.stations))
+	codes := make(map[string]Station)
+	for st, info := range d.stations {
+		s := *info
+		pickPlatforms(&s, served)
+		if len(s.codes) == 0 {
+			return fmt.Errorf("station %s has no stop codes", st)
+		}
+		for _, code := range s.codes {
+			if other, ok := codes[code]; ok && other != st {
+				return fmt.Errorf("stop code %s is used by %s and %s", code, other, st)
+			}
+			codes[code] = st
+		}
+		stations[st] = &s
+	}
+	d.stations = stations
+	d.codes = codes
+
+	sequences := make([][]Station, 0, len(patterns))
+	d.routed = make(map[Station]bool)
+	for _, p := range patterns {
+		seq := []Station{}
+		for _, code := range p {
+			st, ok := codes[code]
+			if ok && indexOfStation(seq, st) < 0 {
+				seq = append(seq, st)
+				d.routed[st] = true
+			}
+		}
+		sequences = append(sequences, seq)
+	}
+	// the order of the known stations is kept, the routes place the new ones
+	placed, unplaced := d.registry.split(stations)
+	d.order = orderStations(placed, unplaced, sequences)
+	return nil
+}
+
+// journeyPatterns returns the stop codes of every journey in the timetable,
+// from north to south. It is used when the routes of the timetable are not
+// known, such as for a GTFS feed
+func journeyPatterns(timetable map[string][]timetableFrame) [][]string {
+	ret := [][]string{}
+	for _, frames := range timetable {
+		for _, frame := range frames {
+			for _, journey := range frame.VehicleJourneys.TimetableRouteJourney {
+				codes := make([]string, 0, len(journey.Calls.Call))
+				for _, call := range journey.Calls.Call {
+					codes = append(codes, call.ScheduledStopPointRef.Ref)
+				}
+				if getDirFromChar(journey.JourneyPatternView.DirectionRef.Ref) == North {
+					reverseCodes(codes)
+				}
+				ret = append(ret, codes)
+			}
+		}
+	}
+	return ret
+}
+
+// reverseCodes reverses a slice of stop codes in place
+func reverseCodes(codes []string) {
+	for i, j := 0, len(codes)-1; i < j; i, j = i+1, j-1 {
+		codes[i], codes[j] = codes[j], codes[i]
+	}
+}
+
+// pickPlatforms sets the north and south codes of a station from its stop
+// codes. When a station has several stops for a direction, the stop served
+// by the timetable is used, then the shortest code
+func pickPlatforms(s *stationInfo, served map[string]bool) {
+	if len(s.codes) == 0 {
+		for _, code := range []string{s.northCode, s.southCode} {
+			if code != "" {
+				s.codes = append(s.codes, code)
+			}
+		}
+		return
+	}
+	var north, south string
+	for _, code := range s.codes {
+		isNorth, err := isCodeNorth(code)
+		if err != nil {
+			continue
+		}
+		if isNorth {
+			north = betterPlatform(north, code, served)
+		} else {
+			south = betterPlatform(south, code, served)
+		}
+	}
+	s.northCode, s.southCode = north, south
+}
+
+// betterPlatform returns the preferred of two stop codes for a direction
+func betterPlatform(cur, code string, served map[string]bool) string {
+	if cur == "" {
+		return code
+	}
+	if served[cur] != served[code] {
+		if served[code] {
+			return code
+		}
+		return cur
+	}
+	if len(code) < len(cur) || (len(code) == len(cur) && code < cur) {
+		return code
+	}
+	return cur
+}
+
+// trainNumber returns the train number of a GTFS-Realtime trip ID. The
+// 511.org timetable uses the train numbers as trip IDs, but a GTFS static
+// feed has its own IDs and the train number in trip_short_name
+func (d *Dataset) trainNumber(tripID string) string {
+	if num, ok := d.trips[tripID]; ok {
+		return num
+	}
+	return tripID
+}
+
+// Fetched returns the time that the timetable was fetched
+func (d *Dataset) Fetched() time.Time {
+	return d.fetched
+}
+
+// Lines returns a slice of all available train lines
+func (d *Dataset) Lines() []Line {
+	ret := make([]Line, len(d.lines))
+	copy(ret, d.lines)
+	return ret
+}
+
+// Holidays returns a slice of the days that are on a holiday schedule
+func (d *Dataset) Holidays() []time.Time {
+	ret := make([]time.Time, len(d.holidays))
+	copy(ret, d.holidays)
+	return ret
+}
+
+// Stations returns the stations of the stops feed in order from North to
+// South
+func (d *Dataset) Stations() []Station {
+	ret := make([]Station, len(d.order))
+	copy(ret, d.order)
+	return ret
+}
@@ -0,0 +1,145 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// apiClientFailLine returns the file for every request except the timetable
+// of one line, which fails
+type apiClientFailLine struct {
+	file string
+	line string
+}
+
+func (a *apiClientFailLine) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	if query["line_id"] == a.line {
+		return nil, &APIError{Status: "500 Internal Server Error", Code: 500, Url: url, Query: query}
+	}
+	return ioutil.ReadFile(a.file)
+}
+
+func newBulletClient(t *testing.T) *CaltrainClient {
+	t.Helper()
+	ctx := context.Background()
+	c := New(fakeKey)
+	setLines(c, allLines)
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/stations.json"}
+	if err := c.UpdateStations(ctx); err != nil {
+		t.Fatalf("Unexpected error loading stations: %v", err)
+	}
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/bulletSchedule.json"}
+	if err := c.UpdateTimeTable(ctx); err != nil {
+		t.Fatalf("Unexpected error loading timetable: %v", err)
+	}
+	return c
+}
+
+func TestUpdateTimeTableAtomic(t *testing.T) {
+	ctx := context.Background()
+	c := newBulletClient(t)
+	before := c.Dataset()
+
+	// a failure on the last line leaves the whole timetable as it was
+	c.APIClient = &apiClientFailLine{file: "testdata/localSchedule.json", line: "Special"}
+	var apiErr *APIError
+	if err := c.UpdateTimeTable(ctx); !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an APIError, received %v", err)
+	}
+	if c.Dataset() != before {
+		t.Fatalf("A failed update replaced the dataset")
+	}
+	if _, err := c.GetTrainRoute("801"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestUpdateTimeTableReaders(t *testing.T) {
+	ctx := context.Background()
+	c := newBulletClient(t)
+	before := c.Dataset()
+
+	a := newAPIClientBlocking("testdata/localSchedule.json")
+	c.APIClient = a
+	done := make(chan error, 1)
+	go func() { done <- c.UpdateTimeTable(ctx) }()
+	<-a.started
+
+	// queries are answered from the current dataset while the update waits
+	// on the API
+	if _, err := c.GetTrainRoute("801"); err != nil {
+		t.Fatalf("Unexpected error during the update: %v", err)
+	}
+	close(a.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the new timetable is swapped in, and the old dataset is unchanged
+	var notFound *TrainNotFoundError
+	if _, err := c.GetTrainRoute("801"); !errors.As(err, &notFound) {
+		t.Fatalf("Expected a TrainNotFoundError after the update, received %v", err)
+	}
+	if _, err := before.getRouteForTrain("801"); err != nil {
+		t.Fatalf("The previous dataset was modified: %v", err)
+	}
+}
+
+func TestDatasetStationCodes(t *testing.T) {
+	c := newBulletClient(t)
+	d := c.Dataset()
+
+	// San Jose and Tamien have an extra stop in the stops feed. The
+	// platforms served by the timetable are used
+	tests := []struct {
+		station Station
+		north   string
+		south   string
+	}{
+		{station: StationSanJose, north: "70261", south: "70262"},
+		{station: StationTamien, north: "70271", south: "70272"},
+		{station: StationStanford, north: "2537740", south: "2537744"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.station.String(), func(t *testing.T) {
+			if code, _ := d.getStationCode(tt.station, North); code != tt.north {
+				t.Fatalf("Unexpected north code. Expected %s, received %s", tt.north, code)
+			}
+			if code, _ := d.getStationCode(tt.station, South); code != tt.south {
+				t.Fatalf("Unexpected south code. Expected %s, received %s", tt.south, code)
+			}
+		})
+	}
+	if st := d.getStationFromCode("777402"); st != StationSanJose {
+		t.Fatalf("Unexpected station for the extra San Jose stop: %s", st)
+	}
+
+	if stations := d.Stations(); len(stations) != len(knownStations) {
+		t.Fatalf("Unexpected number of stations. Expected %d, received %d", len(knownStations), len(stations))
+	}
+	for i, st := range d.Stations() {
+		if st != Station(i) {
+			t.Fatalf("Unexpected station order at %d. Expected %s, received %s", i, Station(i), st)
+		}
+	}
+}
+
+func TestDatasetDuplicateCode(t *testing.T) {
+	c := New(fakeKey)
+	before := c.Dataset()
+	err := c.update(func(d *Dataset) error {
+		d.stations = map[Station]*stationInfo{
+			StationHillsdale: {name: StationHillsdale, northCode: "70111", southCode: "70112"},
+			StationBelmont:   {name: StationBelmont, northCode: "70111", southCode: "70122"},
+		}
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("update improperly succeeded with a stop code used by two stations")
+	}
+	if c.Dataset() != before {
+		t.Fatalf("An invalid dataset was swapped in")
+	}
+}
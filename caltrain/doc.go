@@ -24,6 +24,20 @@ methods. While these values should not change on a day to day basis, they can
 change on a month to month basis, so these methods should be called
 periodically to keep the data accurate.
 
+StartAutoRefresh does this in the background. The holidays are refreshed
+daily and the lines, stations and timetable nightly between 2am and 4am
+pacific time. Failed refreshes are retried with backoff, refreshes are skipped
+when the rate limit is low, and AutoRefreshStatus reports the last success
+and error. Refreshes skip the cached static responses, and a context from
+WithRefresh does the same for calls to the Update methods.
+
+	c.StartAutoRefresh(ctx, caltrain.RefreshPolicy{TimetableHour: 2})
+
+An update fetches and checks all of its data before it is used, then swaps
+it in at once. Queries never wait on the API and never see a mix of old and
+new data, and a failed update keeps the previous data. Dataset returns the
+current data for several queries that must agree with each other.
+
 Time And Time Zones
 
 Since the Caltrain is in the Bay Area, all of the static timetable times are in
@@ -35,6 +49,102 @@ TrainStop.
 However, the live status updates use UTC, so all live time events will be
 returned in UTC. This includes the time components of TrainStatus.
 
+Stations
+
+The Station constants are the well known stations. Stations in the stops
+feed that are not known, such as a new stop, are registered by the client
+when the stations are updated, and their place on the line is taken from the
+routes of the timetable. ParseStation accepts the well known names and a few
+aliases. The ParseStation, StationName and GetDirectionFromSrcToDst methods of
+a client also know its registered stations, and AddStationAlias adds more
+names to a client, such as the new name of a renamed stop.
+SetUnknownStationPolicy skips unknown stops or fails the update instead.
+
+	c.SetUnknownStationPolicy(caltrain.UnknownStationFail)
+	err := c.AddStationAlias("Diridon Station", caltrain.StationSanJose)
+
+ParseStation also accepts abbreviations, such as "SF" or "S. San Francisco",
+and a prefix or misspelling that clearly matches one station. Otherwise it
+returns a StationNotFoundError with the closest stations. ResolveStation
+returns every candidate for a name, ranked by confidence.
+
+	matches := caltrain.ResolveStation("Milbrae")
+	fmt.Println(matches[0].Station, matches[0].Confidence)
+
+The stops feed gives the location of each station. NearestStations and
+StationsWithin find the stations around a location by great-circle distance,
+StationLocation returns the location of a station, and GetNextTrainsNear
+returns the next trains from the nearest station, so a GPS fix is enough to
+find a train.
+
+	routes, st, err := c.GetNextTrainsNear(ctx, 37.5374, -122.2978, caltrain.North, caltrain.NextN(3))
+
+GTFS Feeds
+
+Instead of calling Initialize, the timetable, stations, lines, and holidays can
+be loaded from a Caltrain GTFS static feed with LoadGTFS. This does not use
+the API, so the timetable queries work offline and without an API key.
+
+	c := caltrain.New("")
+	err := c.LoadGTFS("caltrain-ca-us.zip")
+
+By default, live train status comes from the SIRI StopMonitoring feed. Use
+SetLiveFeed(GTFSRealtimeFeed) to query the GTFS-Realtime TripUpdates and
+VehiclePositions feeds instead, which takes two requests per call. Trip IDs
+are mapped to train numbers through the trips of a loaded GTFS feed. Saved
+GTFS-Realtime TripUpdates and VehiclePositions can be parsed with
+ParseGTFSRealtime.
+
+Snapshots
+
+Initialize makes several API calls. To avoid spending them on every restart,
+the loaded data can be saved with SaveSnapshot and restored with LoadSnapshot,
+which makes no API calls. If the snapshot is older than the maximum age set
+with SetSnapshotMaxAge, seven days by default, the data is still loaded but a
+SnapshotAgeError is returned so the caller knows to refresh it.
+
+	err := c.SaveSnapshot(f)
+	...
+	err := c.LoadSnapshot(f)
+
+Watching Delays
+
+Watch polls the live status of the trains and streams TrainEvents on a
+channel until the context is done. Events are sent when a train becomes
+delayed, its delay changes, it arrives at a station, or it leaves the feed.
+Polls are at least 2 minutes apart, and with the default APIClient511 they
+are spread so that a watcher uses at most half of the request budget above
+the reserve, see Rate Limiting. They back off when an APILimitError is
+returned.
+
+	events := c.Watch(ctx, caltrain.WatchOptions{Stations: []caltrain.Station{caltrain.StationPaloAlto}})
+	for e := range events {
+		fmt.Println(e.Type, e.Train.TrainNum, e.Train.Delay)
+	}
+
+The TrainStatus values carry the vehicle, GPS location and bearing when the
+feed reports them. GetTrainPositions returns the location of every train in
+the live feed for a map. Trains without a GPS location are placed between
+their previous and next stations from the schedule and their delay, and are
+marked Estimated.
+
+	positions, updated, err := c.GetTrainPositions(ctx)
+
+The Occupancy of a TrainStatus is how crowded the train is, from either
+feed, or OccupancyUnknown when it is not reported. GetStationStatus takes
+options to put the least crowded trains first or leave out the crowded ones.
+
+	trains, _, err := c.GetStationStatus(ctx, caltrain.StationPaloAlto, caltrain.North, caltrain.SortByOccupancy(), caltrain.MaxOccupancy(caltrain.OccupancyFewSeats))
+
+GetServiceAlerts returns the disruptions in the ServiceAlerts feed, such as
+track work or police activity, with the stations, lines and trains they
+affect and when they are active. The WithAlerts option sets the Alerts of the
+routes returned by a query to the active alerts that affect them. It makes an
+API call, which is cached like the live status. GetStationTimetableContext
+passes a context to the call.
+
+	routes, err := c.GetTrainsBetweenStationsForDate(ctx, src, dst, date, caltrain.WithAlerts())
+
 Caching
 
 The free API keys provided by 511.org have a 60 request/hour limit. To help
@@ -43,12 +153,85 @@ the response for a given request for the time specified, using SetupCache. If a
 request is denied due to the limit being reached, the calling method will
 return an APILimitError
 
+The expiration passed to SetupCache applies to the live status. The lines,
+stations, timetable and holidays responses are cached for 24 hours, and
+SetCacheTTL changes the expiration of any Endpoint. The in-memory cache keeps
+at most 1000 responses and 64 MiB, evicting the least recently used, which
+SetCacheLimits changes. RunCacheJanitor periodically removes expired responses
+that are too old to be served as stale data.
+
+	c.SetCacheTTL(caltrain.EndpointTimetable, 7*24*time.Hour)
+	go c.RunCacheJanitor(ctx, time.Minute)
+
+Concurrent calls that need the same API request, such as a burst of
+GetStationStatus calls for one station, share a single request and its result.
+
+A Cache backend can be passed to SetupCache to keep the responses somewhere
+other than memory. NewFileCache stores them in a directory that several
+processes on one host can share, and the boltcache package stores them in a
+bbolt database file.
+
+	backend, err := caltrain.NewFileCache("/var/cache/caltrain")
+	c.SetupCache(5*time.Minute, backend)
+
+With SetStaleWhileRevalidate, an expired live status within the max stale age
+is returned right away while it is refreshed in the background. Freshness
+reports the age of the data from the time returned with it, and whether it is
+stale.
+
+	trains, fetched, err := c.GetDelays(ctx, 5*time.Minute)
+	if c.Freshness(fetched).Stale {
+		...
+	}
+
+Rate Limiting
+
+The default APIClient511 keeps a token bucket sized for the 60 requests an
+hour of the free tier, corrected by the Ratelimit-Remaining header of each
+response. The last 10 requests of the budget are reserved for live status
+calls, so timetable refreshes are paced or refused with an APILimitError
+before they can starve GetDelays and GetStationStatus. Requests made with a
+context from WithPriority(ctx, PriorityHigh) can also use the reserve. The
+remaining budget is returned by RateLimitStatus.
+
+	status, ok := c.RateLimitStatus()
+
+HTTP Client
+
+NewClient accepts options to change how the 511.org API is reached. Requests
+go to DefaultBaseURL over HTTPS with a 30 second timeout. WithBaseURL points
+the client at another server, such as a local stand-in for tests, and
+WithHTTPClient, WithTransport, WithTimeout, WithUserAgent and WithProxy
+configure the requests. Without WithProxy, the proxy is read from the
+HTTPS_PROXY environment variable.
+
+	c.APIClient = caltrain.NewClient(caltrain.WithBaseURL("http://localhost:8511"), caltrain.WithTimeout(10*time.Second))
+
+Recording Responses
+
+RecordingClient wraps an APIClient and writes each response to a cassette
+directory, named by endpoint and query without the api_key. ReplayClient
+serves those files back, so tests can run against realistic 511.org traffic
+without a key or network access.
+
+	rec, err := caltrain.NewRecordingClient(caltrain.NewClient(), "testdata/cassettes")
+	c.APIClient = rec
+	...
+	c.APIClient = caltrain.NewReplayClient("testdata/cassettes")
+
 API Errors
 
 All calls that use the APIClient have the possibility of returning an APIError
-or an APILimitError. If caching is implemented and the APIClient call returns
-one of these errors, the method will return the stale cached value in addition
-to the error if it exists for the user to use if desired. If caching is not
-implemented or the request has not been cached, the value will be nil.
+or an APILimitError. If caching is implemented and the APIClient call fails for
+any reason, the live status methods will return the stale cached value in
+addition to the error if it exists and is newer than the max stale age set
+with SetMaxStale, 30 minutes by default. If caching is not implemented or the
+request has not been cached, the value will be nil.
+
+Server errors and transport errors are retried up to 3 times with exponential
+backoff and jitter, waiting for the Retry-After header if the server sets one.
+Too many requests errors are not retried. The APIError of the last attempt has
+the number of attempts in its Attempts field. The retries can be changed with
+APIClient511.SetRetryPolicy.
 */
 package caltrain
@@ -0,0 +1,157 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/efritz09/go-caltrain/internal/fake511"
+)
+
+// newFakeClient returns a client that makes its requests to a fake 511.org
+// server with the test fixtures
+func newFakeClient(t *testing.T) (*CaltrainClient, *fake511.Server) {
+	t.Helper()
+	s := fake511.New("testdata")
+	t.Cleanup(s.Close)
+
+	a := NewClient(WithBaseURL(s.URL))
+	a.SetRateLimit(0, 0)
+	a.SetRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond})
+	c := New(fakeKey)
+	c.APIClient = a
+	return c, s
+}
+
+func TestEndToEndInitialize(t *testing.T) {
+	ctx := context.Background()
+	c, s := newFakeClient(t)
+	s.SetBOM(true)
+	if err := c.Initialize(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(c.AllLines()) != 3 || len(c.Holidays()) == 0 {
+		t.Fatalf("Unexpected data. Lines %v, holidays %v", c.AllLines(), c.Holidays())
+	}
+	if n := s.Calls(fake511.TimetablePath); n != 3 {
+		t.Fatalf("Unexpected number of timetable requests. Expected %d, received %d", 3, n)
+	}
+
+	// the key is required
+	c.key = ""
+	var apiErr *APIError
+	if err := c.UpdateLines(ctx); !errors.As(err, &apiErr) || apiErr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected an unauthorized APIError, received %v", err)
+	}
+}
+
+func TestEndToEndDelays(t *testing.T) {
+	ctx := context.Background()
+	c, s := newFakeClient(t)
+	setLines(c, allLines)
+
+	delays, _, err := c.GetDelays(ctx, defaultDelayThreshold)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(delays) != 0 {
+		t.Fatalf("Unexpected delays: %v", delays)
+	}
+
+	s.DelayTrain("381", 12*time.Minute)
+	delays, _, err = c.GetDelays(ctx, defaultDelayThreshold)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(delays) != 1 || delays[0].TrainNum != "381" || delays[0].Delay != 12*time.Minute {
+		t.Fatalf("Expected train 381 to be delayed 12m, received %+v", delays)
+	}
+}
+
+func TestEndToEndErrors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Rate limit", func(t *testing.T) {
+		c, s := newFakeClient(t)
+		s.LimitAfter(1)
+		if err := c.UpdateLines(ctx); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var limErr *APILimitError
+		if err := c.UpdateHolidays(ctx); !errors.As(err, &limErr) {
+			t.Fatalf("Expected an APILimitError, received %v", err)
+		}
+		if limErr.RetryAfter != time.Hour {
+			t.Fatalf("Unexpected retry after. Expected %s, received %s", time.Hour, limErr.RetryAfter)
+		}
+		if n := s.TotalCalls(); n != 2 {
+			t.Fatalf("The 429 was retried, %d requests were made", n)
+		}
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		c, s := newFakeClient(t)
+		setLines(c, allLines)
+		c.SetupCache(defaultCacheTimeout)
+		mock := clock.NewMock()
+		mock.Set(time.Now())
+		c.clock = mock
+		c.cache.(*caltrainCache).clock = mock
+		s.DelayTrain("381", 12*time.Minute)
+		delays, fetched, err := c.GetDelays(ctx, defaultDelayThreshold)
+		if err != nil || len(delays) != 1 {
+			t.Fatalf("Unexpected delays %v: %v", delays, err)
+		}
+
+		// the cached response is used without another request
+		s.Fail(fake511.StopMonitoringPath, http.StatusTooManyRequests)
+		if _, _, err := c.GetDelays(ctx, defaultDelayThreshold); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if n := s.Calls(fake511.StopMonitoringPath); n != 1 {
+			t.Fatalf("Unexpected number of requests. Expected %d, received %d", 1, n)
+		}
+
+		// once the entry expires the request fails, and the stale delays are
+		// returned along with the error
+		mock.Add(defaultCacheTimeout + time.Minute)
+		stale, t2, err := c.GetDelays(ctx, defaultDelayThreshold)
+		var limErr *APILimitError
+		if !errors.As(err, &limErr) {
+			t.Fatalf("Expected an APILimitError, received %v", err)
+		}
+		if len(stale) != 1 || stale[0].TrainNum != "381" || !t2.Equal(fetched) {
+			t.Fatalf("Expected the stale delays from %s, received %v from %s", fetched, stale, t2)
+		}
+		if f := c.Freshness(t2); !f.Stale {
+			t.Fatalf("The stale delays are not reported as stale: %+v", f)
+		}
+		if n := s.Calls(fake511.StopMonitoringPath); n != 2 {
+			t.Fatalf("Unexpected number of requests. Expected %d, received %d", 2, n)
+		}
+	})
+
+	t.Run("Server error", func(t *testing.T) {
+		c, s := newFakeClient(t)
+		s.Fail(fake511.LinesPath, http.StatusBadGateway)
+		var apiErr *APIError
+		if err := c.UpdateLines(ctx); !errors.As(err, &apiErr) || apiErr.Attempts != 3 {
+			t.Fatalf("Expected an APIError after 3 attempts, received %v", err)
+		}
+		if n := s.Calls(fake511.LinesPath); n != 3 {
+			t.Fatalf("Unexpected number of requests. Expected %d, received %d", 3, n)
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		c, s := newFakeClient(t)
+		setLines(c, allLines)
+		s.Malform(fake511.StopMonitoringPath)
+		if _, _, err := c.GetDelays(ctx, defaultDelayThreshold); err == nil {
+			t.Fatalf("Expected an error parsing malformed JSON")
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package caltrain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileCacheExt is the extension of the files written by a FileCache
+const fileCacheExt = ".cache"
+
+// FileCache is a Cache that keeps each response in a file in a directory.
+// Files are replaced atomically, so several processes on one host can share
+// a directory and the API limit
+type FileCache struct {
+	dir string
+}
+
+// NewFileCache returns a FileCache that stores the responses in dir, creating
+// it if it doesn't exist
+func NewFileCache(dir string) (*FileCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &FileCache{dir: dir}, nil
+}
+
+// path returns the file for a key. Keys are urls, so they are hashed to get a
+// valid file name
+func (f *FileCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+fileCacheExt)
+}
+
+// Get returns the body and the time it was set, or ErrCacheMiss. The file
+// is the set time in unix nanoseconds followed by the body
+func (f *FileCache) Get(key string) ([]byte, time.Time, error) {
+	data, err := ioutil.ReadFile(f.path(key))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, time.Time{}, ErrCacheMiss
+		}
+		return nil, time.Time{}, err
+	}
+	if len(data) < 8 {
+		return nil, time.Time{}, fmt.Errorf("cache file for %s is corrupt", key)
+	}
+	t := time.Unix(0, int64(binary.BigEndian.Uint64(data[:8])))
+	return data[8:], t, nil
+}
+
+// Set writes the body to a temporary file and renames it over the key's file
+// so readers never see a partial write
+func (f *FileCache) Set(key string, body []byte, t time.Time) error {
+	tmp, err := ioutil.TempFile(f.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, uint64(t.UnixNano()))
+	if _, err := tmp.Write(append(header, body...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+// Clear removes every cache file in the directory
+func (f *FileCache) Clear() error {
+	files, err := filepath.Glob(filepath.Join(f.dir, "*"+fileCacheExt))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
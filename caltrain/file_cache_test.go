@@ -0,0 +1,115 @@
+package caltrain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestFileCache(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileCache(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if _, _, err := f.Get("a"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Expected a cache miss, received %v", err)
+	}
+
+	now := time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC)
+	if err := f.Set("a", d1, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := f.Set(delayURL, d2, now); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// a second cache in the same directory shares the responses
+	other, err := NewFileCache(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	body, set, err := other.Get(delayURL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(body, d2) || !set.Equal(now) {
+		t.Fatalf("Unexpected entry. Expected %b at %s, received %b at %s", d2, now, body, set)
+	}
+
+	if err := f.Clear(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err := other.Get("a"); !errors.Is(err, ErrCacheMiss) {
+		t.Fatalf("Expected a cache miss after Clear, received %v", err)
+	}
+}
+
+func TestBackendCacheExpiration(t *testing.T) {
+	f, err := NewFileCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	c := newBackendCache(f, defaultCacheTimeout)
+	mock := clock.NewMock()
+	c.clock = mock
+
+	c.set("a", d1)
+	mock.Add(defaultCacheTimeout - time.Second)
+	if v, _, ok := c.get("a"); !ok || !bytes.Equal(v, d1) {
+		t.Fatalf("unexpected value for 'a': Expected %b, received %b", d1, v)
+	}
+
+	// expired values are still returned for use when the API limit is hit
+	mock.Add(2 * time.Second)
+	if v, _, ok := c.get("a"); ok || !bytes.Equal(v, d1) {
+		t.Fatalf("'a' has not timed out or was not returned: %b", v)
+	}
+
+	c.clearCache()
+	if v, _, ok := c.get("a"); ok || v != nil {
+		t.Fatalf("'a' was not cleared: %b", v)
+	}
+}
+
+func TestSetupCacheBackend(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	calls := 0
+	api := &apiClientCounter{file: "testdata/parseDelayData1.json", calls: &calls}
+
+	for i := 0; i < 2; i++ {
+		// each client shares the directory, like separate processes
+		f, err := NewFileCache(dir)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		c := New(fakeKey)
+		setLines(c, allLines)
+		c.APIClient = api
+		c.SetupCache(defaultCacheTimeout, f)
+		if _, _, err := c.GetDelays(ctx, defaultDelayThreshold); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Unexpected number of API calls. Expected %d, received %d", 1, calls)
+	}
+}
+
+// apiClientCounter counts the calls made to the mock
+type apiClientCounter struct {
+	file  string
+	calls *int
+}
+
+func (a *apiClientCounter) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	*a.calls++
+	m := &apiClientMock{GetResultFilePath: a.file}
+	return m.Get(ctx, url, query)
+}
@@ -0,0 +1,147 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// freshness.go contains the stale-while-revalidate and stale-if-error
+// handling of the cached live status
+
+// defaultMaxStale is the oldest cached live status that is returned when the
+// API can't be reached
+const defaultMaxStale = 30 * time.Minute
+
+// Freshness describes the age of the live status returned with a fetch time
+type Freshness struct {
+	Fetched time.Time     // time the data was fetched from the API
+	Age     time.Duration // time since the data was fetched
+	Stale   bool          // true if the data is older than the cache expiration
+}
+
+// Freshness returns the freshness of live status fetched at the given time,
+// such as the time returned by GetDelays or GetStationStatus. Data is stale
+// once it is older than the cache expiration of the live feed
+func (c *CaltrainClient) Freshness(fetched time.Time) Freshness {
+	key := delayURL
+	if c.liveFeed == GTFSRealtimeFeed {
+		key = tripUpdatesURL
+	}
+	age := c.clock.Now().Sub(fetched)
+	if age < 0 {
+		age = 0
+	}
+	return Freshness{
+		Fetched: fetched,
+		Age:     age,
+		Stale:   c.useCache && age > c.cacheTTLs.ttl(key),
+	}
+}
+
+// SetMaxStale sets the oldest cached live status that is returned when the
+// API call fails, along with the error. The default is 30 minutes and zero
+// never returns stale data
+func (c *CaltrainClient) SetMaxStale(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	c.maxStale = d
+}
+
+// SetStaleWhileRevalidate makes the live status calls return expired cached
+// data right away, up to the max stale age, while it is refreshed in the
+// background for the next call. It is disabled by default
+func (c *CaltrainClient) SetStaleWhileRevalidate(enabled bool) {
+	c.revalidate = enabled
+}
+
+// fetchLive returns the parsed live status at url, using the cache if it is
+// enabled. Expired cache entries up to the max stale age are returned right
+// away when revalidating in the background, or along with the error if the
+// API call fails. name describes the request in errors
+func (c *CaltrainClient) fetchLive(ctx context.Context, name, key, url string, query map[string]string, parse func([]byte) (interface{}, error)) (interface{}, time.Time, error) {
+	t := c.clock.Now()
+	if !c.useCache {
+		data, err := c.get(WithPriority(ctx, PriorityHigh), url, query)
+		if err != nil {
+			return nil, t, fmt.Errorf("failed to make '%s' request: %w", name, err)
+		}
+		v, err := parse(data)
+		return v, t, err
+	}
+
+	body, entry, ok := c.cache.get(key)
+	if ok {
+		v, err := parse(body)
+		return v, entry, err
+	}
+
+	var stale interface{}
+	hasStale := false
+	if body != nil && c.maxStale > 0 && t.Sub(entry) <= c.maxStale {
+		if v, err := parse(body); err == nil {
+			stale, hasStale = v, true
+		}
+	}
+	if hasStale && c.revalidate {
+		c.revalidateLive(name, key, url, query, parse)
+		return stale, entry, nil
+	}
+
+	data, err := c.get(WithPriority(ctx, PriorityHigh), url, query)
+	if err == nil {
+		var v interface{}
+		if v, err = parse(data); err == nil {
+			c.cache.set(key, data)
+			// report the entry time so that it matches the later cache hits
+			if _, e, ok := c.cache.get(key); ok {
+				t = e
+			}
+			return v, t, nil
+		}
+	} else {
+		err = fmt.Errorf("failed to make '%s' request: %w", name, err)
+	}
+
+	if hasStale {
+		logrus.Debugf("Returning stale %s from %s: %v", key, entry, err)
+		return stale, entry, err
+	}
+	return nil, t, err
+}
+
+// revalidateLive refreshes the cache entry for key in the background. Only
+// one refresh runs for each key at a time
+func (c *CaltrainClient) revalidateLive(name, key, url string, query map[string]string, parse func([]byte) (interface{}, error)) {
+	c.rvLock.Lock()
+	if c.revalidating[key] {
+		c.rvLock.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.rvLock.Unlock()
+
+	c.rvWait.Add(1)
+	go func() {
+		defer c.rvWait.Done()
+		defer func() {
+			c.rvLock.Lock()
+			delete(c.revalidating, key)
+			c.rvLock.Unlock()
+		}()
+
+		data, err := c.get(WithPriority(context.Background(), PriorityHigh), url, query)
+		if err != nil {
+			logrus.Warnf("failed to make '%s' request in the background: %v", name, err)
+			return
+		}
+		if _, err := parse(data); err != nil {
+			logrus.Warnf("failed to revalidate %s: %v", key, err)
+			return
+		}
+		c.cache.set(key, data)
+	}()
+}
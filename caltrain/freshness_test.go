@@ -0,0 +1,112 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// newStaleClient returns a client with a 5 minute in-memory cache and a mock
+// clock shared by the client and the cache
+func newStaleClient(t *testing.T) (*CaltrainClient, *clock.Mock) {
+	t.Helper()
+	c := New(fakeKey)
+	setLines(c, allLines)
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/parseDelayData1.json"}
+	c.SetupCache(defaultCacheTimeout)
+	mock := clock.NewMock()
+	mock.Set(time.Date(2019, time.December, 25, 0, 50, 0, 0, time.UTC))
+	c.clock = mock
+	c.cache.(*caltrainCache).clock = mock
+	return c, mock
+}
+
+func TestStaleIfError(t *testing.T) {
+	ctx := context.Background()
+	c, mock := newStaleClient(t)
+	_, fetched, err := c.GetDelays(ctx, defaultDelayThreshold)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if f := c.Freshness(fetched); f.Stale || f.Age != 0 {
+		t.Fatalf("Unexpected freshness of new data: %+v", f)
+	}
+
+	// the cache has expired and the API fails, so the stale data is returned
+	// with the error
+	apiErr := errors.New("connection refused")
+	c.APIClient = &apiClientSequence{err: apiErr}
+	mock.Add(10 * time.Minute)
+	delays, t2, err := c.GetDelays(ctx, defaultDelayThreshold)
+	if !errors.Is(err, apiErr) {
+		t.Fatalf("Expected the API error, received %v", err)
+	}
+	if len(delays) != 2 || !t2.Equal(fetched) {
+		t.Fatalf("Expected the stale delays from %s, received %v from %s", fetched, delays, t2)
+	}
+	if f := c.Freshness(t2); !f.Stale || f.Age != 10*time.Minute {
+		t.Fatalf("Unexpected freshness of stale data: %+v", f)
+	}
+
+	// the status of the trains is stale too
+	routes, err := c.AnnotateRoutes(ctx, []*Route{{TrainNum: "258"}})
+	if !errors.Is(err, apiErr) || len(routes) != 1 || !routes[0].Updated.Equal(fetched) {
+		t.Fatalf("Expected the stale live routes, received %v, %v", routes, err)
+	}
+
+	// past the max stale age nothing is returned
+	mock.Add(defaultMaxStale)
+	if delays, _, err := c.GetDelays(ctx, defaultDelayThreshold); delays != nil || !errors.Is(err, apiErr) {
+		t.Fatalf("Expected no data past the max stale age, received %v, %v", delays, err)
+	}
+
+	c.SetMaxStale(time.Hour)
+	if delays, _, _ := c.GetDelays(ctx, defaultDelayThreshold); delays == nil {
+		t.Fatalf("Expected stale data within the new max stale age")
+	}
+	c.SetMaxStale(0)
+	if delays, _, _ := c.GetDelays(ctx, defaultDelayThreshold); delays != nil {
+		t.Fatalf("Expected no stale data with a max stale age of zero")
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	ctx := context.Background()
+	c, mock := newStaleClient(t)
+	c.SetStaleWhileRevalidate(true)
+	_, fetched, err := c.GetDelays(ctx, defaultDelayThreshold)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the stale data is returned right away and refreshed in the background
+	calls := 0
+	c.APIClient = &apiClientCounter{file: "testdata/parseDelayData2.json", calls: &calls}
+	mock.Add(10 * time.Minute)
+	delays, t2, err := c.GetDelays(ctx, defaultDelayThreshold)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(delays) != 2 || !t2.Equal(fetched) || !c.Freshness(t2).Stale {
+		t.Fatalf("Expected the stale delays, received %v from %s", delays, t2)
+	}
+	c.rvWait.Wait()
+	if calls != 1 {
+		t.Fatalf("Unexpected number of API calls. Expected %d, received %d", 1, calls)
+	}
+
+	// the next call gets the refreshed data from the cache
+	delays, t3, err := c.GetDelays(ctx, defaultDelayThreshold)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(delays) != 0 || !t3.Equal(mock.Now()) || c.Freshness(t3).Stale {
+		t.Fatalf("Expected the refreshed delays, received %v from %s", delays, t3)
+	}
+	if calls != 1 {
+		t.Fatalf("Unexpected number of API calls. Expected %d, received %d", 1, calls)
+	}
+}
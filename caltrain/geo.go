@@ -0,0 +1,130 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// geo.go contains the geospatial queries, which find stations from the
+// locations in the stops feed. Distances are great-circle distances in meters
+
+// earthRadius is the mean radius of the Earth in meters
+const earthRadius = 6371000
+
+// Location is a point given by its latitude and longitude in degrees
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// StationDistance is a station and its distance from a location
+type StationDistance struct {
+	Station Station // station name
+	Meters  float64 // distance from the location in meters
+}
+
+// haversine returns the great-circle distance between two locations in meters
+func haversine(a, b Location) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// stationDistances returns every station of the dataset with its distance
+// from loc, nearest first
+func (d *Dataset) stationDistances(loc Location) []StationDistance {
+	ret := make([]StationDistance, 0, len(d.order))
+	for _, st := range d.order {
+		info := d.stations[st]
+		ret = append(ret, StationDistance{
+			Station: st,
+			Meters:  haversine(loc, Location{Latitude: info.latitude, Longitude: info.longitude}),
+		})
+	}
+	// ties are broken by the order of the stations from north to south
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Meters < ret[j].Meters
+	})
+	return ret
+}
+
+// NearestStations returns the n stations nearest to a location, nearest
+// first. It returns every station if n is less than 1 or more than the number
+// of stations
+func (d *Dataset) NearestStations(lat, lon float64, n int) []StationDistance {
+	ret := d.stationDistances(Location{Latitude: lat, Longitude: lon})
+	if n > 0 && len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
+
+// StationsWithin returns the stations within the given number of meters of a
+// location, nearest first
+func (d *Dataset) StationsWithin(lat, lon, meters float64) []StationDistance {
+	all := d.stationDistances(Location{Latitude: lat, Longitude: lon})
+	ret := []StationDistance{}
+	for _, sd := range all {
+		if sd.Meters > meters {
+			break
+		}
+		ret = append(ret, sd)
+	}
+	return ret
+}
+
+// StationLocation returns the location of a station
+func (d *Dataset) StationLocation(st Station) (Location, error) {
+	info, ok := d.stations[st]
+	if !ok {
+		return Location{}, fmt.Errorf("unknown station %s", st)
+	}
+	return Location{Latitude: info.latitude, Longitude: info.longitude}, nil
+}
+
+// NearestStations returns the n stations nearest to a location, nearest
+// first. It returns every station if n is less than 1
+func (c *CaltrainClient) NearestStations(lat, lon float64, n int) []StationDistance {
+	return c.dataset().NearestStations(lat, lon, n)
+}
+
+// StationsWithin returns the stations within the given number of meters of a
+// location, nearest first
+func (c *CaltrainClient) StationsWithin(lat, lon, meters float64) []StationDistance {
+	return c.dataset().StationsWithin(lat, lon, meters)
+}
+
+// StationLocation returns the location of a station from the stops feed
+func (c *CaltrainClient) StationLocation(st Station) (Location, error) {
+	return c.dataset().StationLocation(st)
+}
+
+// GetNextTrainsNear returns the routes that depart the station nearest to a
+// location in the given direction from now on, sorted by departure time, and
+// the station. Stations that have no platform for the direction are passed
+// over. The options filter the routes as for GetStationTimetable. It uses the
+// cached timetable and does not make an API call
+func (c *CaltrainClient) GetNextTrainsNear(ctx context.Context, lat, lon float64, dir Direction, opts ...QueryOption) ([]*Route, Station, error) {
+	d := c.dataset()
+	now := c.clock.Now().In(c.tz)
+	for _, sd := range d.stationDistances(Location{Latitude: lat, Longitude: lon}) {
+		if code, err := d.getStationCode(sd.Station, dir); err != nil || code == "" {
+			continue
+		}
+		logrus.Debugf("Getting next %s trains at %s, %.0fm away", dir, sd.Station, sd.Meters)
+		opts = append([]QueryOption{DepartAfter(now)}, opts...)
+		routes, err := d.getStationTimetable(sd.Station, dir, now, opts)
+		if err != nil {
+			return routes, sd.Station, err
+		}
+		return routes, sd.Station, c.annotateQuery(ctx, routes, now, opts)
+	}
+	return nil, 0, fmt.Errorf("no stations with %s platforms", dir)
+}
@@ -0,0 +1,144 @@
+package caltrain
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestHaversine(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Location
+		b    Location
+		exp  float64
+	}{
+		{name: "Same", a: Location{37.5, -122.3}, b: Location{37.5, -122.3}, exp: 0},
+		{name: "OneDegreeLatitude", a: Location{37, -122}, b: Location{38, -122}, exp: 111195},
+		{name: "OneDegreeLongitudeEquator", a: Location{0, 0}, b: Location{0, 1}, exp: 111195},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := haversine(tt.a, tt.b); math.Abs(d-tt.exp) > 1 {
+				t.Fatalf("Unexpected distance. Expected %.0f, received %.0f", tt.exp, d)
+			}
+		})
+	}
+}
+
+func TestNearestStations(t *testing.T) {
+	c := newBulletClient(t)
+	loc, err := c.StationLocation(StationHillsdale)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if loc.Latitude == 0 || loc.Longitude == 0 {
+		t.Fatalf("Hillsdale has no location: %v", loc)
+	}
+
+	nearest := c.NearestStations(loc.Latitude, loc.Longitude, 3)
+	if len(nearest) != 3 || nearest[0].Station != StationHillsdale || nearest[0].Meters > 1 {
+		t.Fatalf("Unexpected nearest stations: %v", nearest)
+	}
+	for i := 1; i < len(nearest); i++ {
+		if nearest[i].Meters < nearest[i-1].Meters {
+			t.Fatalf("Nearest stations are not sorted: %v", nearest)
+		}
+	}
+	if all := c.NearestStations(loc.Latitude, loc.Longitude, 0); len(all) != len(knownStations) {
+		t.Fatalf("Unexpected number of stations. Expected %d, received %d", len(knownStations), len(all))
+	}
+
+	within := c.StationsWithin(loc.Latitude, loc.Longitude, nearest[1].Meters)
+	if len(within) != 2 || within[0].Station != StationHillsdale || within[1].Station != nearest[1].Station {
+		t.Fatalf("Unexpected stations within %.0fm: %v", nearest[1].Meters, within)
+	}
+	if within := c.StationsWithin(0, 0, 1000); len(within) != 0 {
+		t.Fatalf("Unexpected stations far from the line: %v", within)
+	}
+
+	if _, err := New(fakeKey).StationLocation(StationHillsdale); err == nil {
+		t.Fatalf("StationLocation improperly succeeded without stations")
+	}
+}
+
+func TestGetNextTrainsNear(t *testing.T) {
+	c := newBulletClient(t)
+	mock := clock.NewMock()
+	c.clock = mock
+	now := time.Date(2019, time.December, 30, 8, 0, 0, 0, c.tz)
+	mock.Set(now)
+
+	loc, err := c.StationLocation(StationHillsdale)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// a little north of the station
+	routes, st, err := c.GetNextTrainsNear(context.Background(), loc.Latitude+0.001, loc.Longitude, North)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if st != StationHillsdale {
+		t.Fatalf("Unexpected station. Expected %s, received %s", StationHillsdale, st)
+	}
+	if len(routes) == 0 {
+		t.Fatalf("No trains found after %s", now)
+	}
+	for _, r := range routes {
+		for _, stop := range r.Stops {
+			if stop.Station == StationHillsdale && stop.Departure.Sub(timetableDay) < 8*time.Hour {
+				t.Fatalf("Train %s departs before %s", r.TrainNum, now)
+			}
+		}
+	}
+
+	next, _, err := c.GetNextTrainsNear(context.Background(), loc.Latitude, loc.Longitude, North, NextN(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(next) != 1 || next[0].TrainNum != routes[0].TrainNum {
+		t.Fatalf("Unexpected next train: %v", next)
+	}
+}
+
+func TestGetNextTrainsNearOnePlatform(t *testing.T) {
+	c := newBulletClient(t)
+	mock := clock.NewMock()
+	c.clock = mock
+	mock.Set(time.Date(2019, time.December, 30, 8, 0, 0, 0, c.tz))
+
+	// Hillsdale only has its southbound platform
+	if err := c.update(func(d *Dataset) error {
+		stations := make(map[Station]*stationInfo, len(d.stations))
+		for st, info := range d.stations {
+			stations[st] = info
+		}
+		hd := *d.stations[StationHillsdale]
+		hd.northCode = ""
+		hd.codes = []string{hd.southCode}
+		stations[StationHillsdale] = &hd
+		d.stations = stations
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	loc, err := c.StationLocation(StationHillsdale)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the nearest station with a northbound platform is used instead
+	_, st, err := c.GetNextTrainsNear(context.Background(), loc.Latitude, loc.Longitude, North)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if st == StationHillsdale {
+		t.Fatalf("A station without a northbound platform was used for northbound trains")
+	}
+	if _, st, err := c.GetNextTrainsNear(context.Background(), loc.Latitude, loc.Longitude, South); err != nil || st != StationHillsdale {
+		t.Fatalf("Unexpected station for southbound trains: %s, %v", st, err)
+	}
+}
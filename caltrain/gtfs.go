@@ -0,0 +1,412 @@
+package caltrain
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gtfs.go contains the loader for GTFS static feeds. The feed is converted
+// into the same structures that the 511.org json responses populate, so every
+// query method works the same regardless of where the data came from
+
+// gtfsData holds everything parsed out of a GTFS static feed
+type gtfsData struct {
+	lines      []Line
+	stops      []scheduledStopPoint
+	holidays   []time.Time
+	timetable  map[string][]timetableFrame
+	dayService map[string][]string
+	trips      map[string]string
+}
+
+// LoadGTFS reads the GTFS zip archive at path and replaces the lines,
+// stations, holidays, and timetable with its contents. It does not make an
+// API call, so it can be used in place of Initialize
+func (c *CaltrainClient) LoadGTFS(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open GTFS feed: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open GTFS feed: %w", err)
+	}
+	return c.LoadGTFSReader(f, info.Size())
+}
+
+// LoadGTFSReader works the same as LoadGTFS except the zip archive is read
+// from r, which must contain size bytes
+func (c *CaltrainClient) LoadGTFSReader(r io.ReaderAt, size int64) error {
+	logrus.Debug("Loading GTFS feed...")
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read GTFS feed: %w", err)
+	}
+
+	data, err := parseGTFS(zr)
+	if err != nil {
+		return fmt.Errorf("failed to parse GTFS feed: %w", err)
+	}
+
+	fetched := c.clock.Now()
+	err = c.update(func(d *Dataset) error {
+		r := d.registry.clone()
+		stations, err := buildStations(data.stops, c.stationPolicy, r)
+		if err != nil {
+			return fmt.Errorf("failed to parse stops: %w", err)
+		}
+		if len(stations) == 0 {
+			return errors.New("no stations found")
+		}
+		d.lines = data.lines
+		d.stations = stations
+		d.registry = r
+		d.holidays = data.holidays
+		d.timetable = data.timetable
+		d.dayService = data.dayService
+		d.patterns = nil
+		d.trips = data.trips
+		d.fetched = fetched
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to load GTFS feed: %w", err)
+	}
+	return nil
+}
+
+// parseGTFS converts the files of a GTFS feed into the internal structures
+func parseGTFS(zr *zip.Reader) (*gtfsData, error) {
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		// some feeds are zipped with a top level directory
+		files[f.Name[strings.LastIndex(f.Name, "/")+1:]] = f
+	}
+
+	var err error
+	data := &gtfsData{}
+	required := []string{"stops.txt", "routes.txt", "trips.txt", "stop_times.txt", "calendar.txt"}
+	records := make(map[string][]map[string]string)
+	for _, name := range append(required, "calendar_dates.txt") {
+		f, ok := files[name]
+		if !ok {
+			if name == "calendar_dates.txt" {
+				continue
+			}
+			return nil, fmt.Errorf("missing %s", name)
+		}
+		if records[name], err = readGTFSFile(f); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+	}
+
+	data.stops = parseGTFSStops(records["stops.txt"])
+	if len(data.stops) == 0 {
+		return nil, errors.New("no stations found")
+	}
+
+	data.lines = parseGTFSRoutes(records["routes.txt"])
+	if len(data.lines) == 0 {
+		return nil, errors.New("no lines found")
+	}
+
+	if data.dayService, err = parseGTFSCalendar(records["calendar.txt"]); err != nil {
+		return nil, err
+	}
+
+	if data.holidays, err = parseGTFSCalendarDates(records["calendar_dates.txt"], data.dayService); err != nil {
+		return nil, err
+	}
+
+	if data.timetable, err = parseGTFSTrips(records["trips.txt"], records["stop_times.txt"], data.lines); err != nil {
+		return nil, err
+	}
+	if len(data.timetable) == 0 {
+		return nil, errors.New("no trips found")
+	}
+	data.trips = parseGTFSTrainNumbers(records["trips.txt"])
+	return data, nil
+}
+
+// readGTFSFile reads a GTFS csv file and returns a map of column name to
+// value for every row
+func readGTFSFile(f *zip.File) ([]map[string]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	raw, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+
+	r := csv.NewReader(bytes.NewReader(raw))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	ret := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]string, len(header))
+		for i, col := range header {
+			if i < len(row) {
+				record[strings.TrimSpace(col)] = strings.TrimSpace(row[i])
+			}
+		}
+		ret = append(ret, record)
+	}
+	return ret, nil
+}
+
+// parseGTFSStops converts the platforms in stops.txt into stop points, which
+// are turned into stations by buildStations
+func parseGTFSStops(stops []map[string]string) []scheduledStopPoint {
+	names := make(map[string]string)
+	for _, stop := range stops {
+		names[stop["stop_id"]] = stop["stop_name"]
+	}
+
+	points := []scheduledStopPoint{}
+	for _, stop := range stops {
+		// only platforms (location type 0 or empty) have a direction
+		if t := stop["location_type"]; t != "" && t != "0" {
+			continue
+		}
+		name := stop["stop_name"]
+		if parent, ok := names[stop["parent_station"]]; ok {
+			name = parent
+		}
+		point := scheduledStopPoint{
+			ID:   stop["stop_id"],
+			Name: gtfsStationName(name) + " Caltrain Station",
+		}
+		point.Location.Latitude = stop["stop_lat"]
+		point.Location.Longitude = stop["stop_lon"]
+		points = append(points, point)
+	}
+
+	return points
+}
+
+// gtfsStationName strips the decorations from a GTFS stop name so that it can
+// be passed to ParseStation
+func gtfsStationName(name string) string {
+	name = strings.TrimSpace(name)
+	for _, suffix := range []string{" Northbound", " Southbound", " Station", " Caltrain"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return name
+}
+
+// parseGTFSRoutes converts routes.txt into lines
+func parseGTFSRoutes(routes []map[string]string) []Line {
+	ret := make([]Line, 0, len(routes))
+	for _, route := range routes {
+		name := route["route_long_name"]
+		if name == "" {
+			name = route["route_short_name"]
+		}
+		if name == "" {
+			name = route["route_id"]
+		}
+		ret = append(ret, Line{Id: route["route_id"], Name: name})
+	}
+	return ret
+}
+
+// parseGTFSCalendar returns a map of service ID to the days of the week that
+// the service runs, matching the format of the ServiceCalendarFrame
+func parseGTFSCalendar(calendar []map[string]string) (map[string][]string, error) {
+	days := []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+	ret := make(map[string][]string)
+	for _, service := range calendar {
+		id := service["service_id"]
+		if id == "" {
+			return nil, errors.New("calendar entry is missing a service_id")
+		}
+		ret[id] = []string{}
+		for _, d := range days {
+			if service[d] == "1" {
+				ret[id] = append(ret[id], d)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseGTFSCalendarDates returns the dates that run a Sunday service on a day
+// that is not a Sunday. Those dates are treated as holidays
+func parseGTFSCalendarDates(dates []map[string]string, services map[string][]string) ([]time.Time, error) {
+	seen := make(map[time.Time]struct{})
+	ret := []time.Time{}
+	for _, d := range dates {
+		// exception type 1 adds the service for the date
+		if d["exception_type"] != "1" {
+			continue
+		}
+		date, err := time.Parse("20060102", d["date"])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse calendar date %s: %w", d["date"], err)
+		}
+		if date.Weekday() == time.Sunday {
+			continue
+		}
+		if _, ok := seen[date]; ok {
+			continue
+		}
+		for _, day := range services[d["service_id"]] {
+			if day == "sunday" {
+				seen[date] = struct{}{}
+				ret = append(ret, date)
+				break
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Before(ret[j]) })
+	return ret, nil
+}
+
+// parseGTFSTrips groups the trips by line, service, and direction into
+// timetable frames keyed by line ID
+func parseGTFSTrips(trips, stopTimes []map[string]string, lines []Line) (map[string][]timetableFrame, error) {
+	calls := make(map[string][]map[string]string)
+	for _, st := range stopTimes {
+		calls[st["trip_id"]] = append(calls[st["trip_id"]], st)
+	}
+
+	ret := make(map[string][]timetableFrame)
+	frames := make(map[string]int) // frame ID to index in ret[line]
+	for _, trip := range trips {
+		line, err := parseLine(trip["route_id"], lines)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trip %s: %w", trip["trip_id"], err)
+		}
+		stops := calls[trip["trip_id"]]
+		if len(stops) == 0 {
+			logrus.Debugf("skipping GTFS trip %s with no stop times", trip["trip_id"])
+			continue
+		}
+		journey, err := gtfsJourney(trip, stops)
+		if err != nil {
+			return nil, err
+		}
+
+		dir := strings.TrimSpace(journey.JourneyPatternView.DirectionRef.Ref)
+		service := trip["service_id"]
+		id := line.Id + ":" + dir + ":" + service
+		i, ok := frames[id]
+		if !ok {
+			frame := timetableFrame{
+				ID:   id,
+				Name: fmt.Sprintf("%s:%s :%s", line.Id, dir, service),
+			}
+			frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref = service
+			ret[line.Id] = append(ret[line.Id], frame)
+			i = len(ret[line.Id]) - 1
+			frames[id] = i
+		}
+		vj := &ret[line.Id][i].VehicleJourneys
+		vj.TimetableRouteJourney = append(vj.TimetableRouteJourney, journey)
+	}
+	return ret, nil
+}
+
+// parseGTFSTrainNumbers returns a map of trip ID to train number for the trips
+// whose trip_short_name differs from the trip ID
+func parseGTFSTrainNumbers(trips []map[string]string) map[string]string {
+	ret := make(map[string]string)
+	for _, trip := range trips {
+		if num := trip["trip_short_name"]; num != "" && num != trip["trip_id"] {
+			ret[trip["trip_id"]] = num
+		}
+	}
+	return ret
+}
+
+// gtfsJourney converts a trip and its stop times into a timetableRouteJourney
+func gtfsJourney(trip map[string]string, stops []map[string]string) (timetableRouteJourney, error) {
+	journey := timetableRouteJourney{ID: trip["trip_short_name"]}
+	if journey.ID == "" {
+		journey.ID = trip["trip_id"]
+	}
+	journey.SiriVehicleJourneyRef = journey.ID
+	journey.JourneyPatternView.RouteRef.Ref = trip["route_id"]
+
+	seq := func(i int) int {
+		n, _ := strconv.Atoi(stops[i]["stop_sequence"])
+		return n
+	}
+	sort.SliceStable(stops, func(i, j int) bool { return seq(i) < seq(j) })
+
+	north, err := isCodeNorth(stops[0]["stop_id"])
+	if err != nil {
+		return journey, fmt.Errorf("failed to parse trip %s: %w", trip["trip_id"], err)
+	}
+	if north {
+		journey.JourneyPatternView.DirectionRef.Ref = "N"
+	} else {
+		journey.JourneyPatternView.DirectionRef.Ref = "S"
+	}
+
+	for i, st := range stops {
+		call := timetableRouteCall{Order: strconv.Itoa(i + 1)}
+		call.ScheduledStopPointRef.Ref = st["stop_id"]
+		arr, dep := st["arrival_time"], st["departure_time"]
+		if arr == "" {
+			arr = dep
+		}
+		if dep == "" {
+			dep = arr
+		}
+		if call.Arrival.Time, call.Arrival.DaysOffset, err = gtfsTime(arr); err != nil {
+			return journey, fmt.Errorf("failed to parse trip %s: %w", trip["trip_id"], err)
+		}
+		if call.Departure.Time, call.Departure.DaysOffset, err = gtfsTime(dep); err != nil {
+			return journey, fmt.Errorf("failed to parse trip %s: %w", trip["trip_id"], err)
+		}
+		journey.Calls.Call = append(journey.Calls.Call, call)
+	}
+	return journey, nil
+}
+
+// gtfsTime converts a GTFS time, which can be greater than 24:00:00 for trips
+// that run past midnight, into a time and days offset
+func gtfsTime(t string) (string, string, error) {
+	parts := strings.Split(t, ":")
+	if len(parts) != 3 {
+		return "", "", fmt.Errorf("bad time: %s", t)
+	}
+	vals := make([]int, 3)
+	for i, p := range parts {
+		v, err := strconv.Atoi(p)
+		if err != nil {
+			return "", "", fmt.Errorf("bad time: %s", t)
+		}
+		vals[i] = v
+	}
+	offset := vals[0] / 24
+	return fmt.Sprintf("%02d:%02d:%02d", vals[0]%24, vals[1], vals[2]), strconv.Itoa(offset), nil
+}
@@ -0,0 +1,369 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+// gtfs_realtime.go contains the parser for GTFS-Realtime feeds. TripUpdates
+// and VehiclePositions are converted into the same TrainStatus values that
+// the SIRI StopMonitoring json produces
+
+// A LiveFeed specifies which 511.org feed is used for live train status
+type LiveFeed int
+
+const (
+	// SIRIFeed uses the SIRI StopMonitoring json feed. This is the default
+	SIRIFeed LiveFeed = iota
+	// GTFSRealtimeFeed uses the GTFS-Realtime TripUpdates protobuf feed,
+	// along with the VehiclePositions feed for the locations of the trains
+	GTFSRealtimeFeed
+)
+
+// SetLiveFeed sets the feed that GetDelays and GetStationStatus query
+func (c *CaltrainClient) SetLiveFeed(feed LiveFeed) {
+	c.liveFeed = feed
+}
+
+// ParseGTFSRealtime returns the TrainStatus for every train in the given
+// GTFS-Realtime FeedMessages. TripUpdates and VehiclePositions can be passed
+// in the same message or as separate messages, such as the contents of the
+// 511.org tripupdates and vehiclepositions endpoints saved to local files.
+// The stations and lines must be loaded before calling
+func (c *CaltrainClient) ParseGTFSRealtime(feeds ...[]byte) ([]TrainStatus, error) {
+	return c.parseGTFSRealtime("", feeds...)
+}
+
+// getVehiclePositions makes an API call and returns the VehiclePositions
+// feed, using the cache if it is enabled. The positions only add the
+// location and occupancy to the trip updates, so a failed call is logged and
+// nil is returned
+func (c *CaltrainClient) getVehiclePositions(ctx context.Context) []byte {
+	query := map[string]string{
+		"agency":  "CT",
+		"api_key": c.key,
+	}
+	parse := func(data []byte) (interface{}, error) {
+		if err := proto.Unmarshal(data, &gtfs.FeedMessage{}); err != nil {
+			return nil, fmt.Errorf("failed to parse vehicle positions: %w", err)
+		}
+		return data, nil
+	}
+	v, _, err := c.fetchLive(ctx, "get vehicle positions", vehiclesURL, vehiclesURL, query, parse)
+	if err != nil {
+		logrus.Warnf("Continuing without vehicle positions: %v", err)
+	}
+	data, _ := v.([]byte)
+	return data
+}
+
+// parseGTFSRealtime unmarshals the feeds and returns a slice of trains. If
+// code is not empty, only the trains that will stop at that code are returned
+// and their status is reported for that stop
+func (c *CaltrainClient) parseGTFSRealtime(code string, feeds ...[]byte) ([]TrainStatus, error) {
+	var now time.Time
+	updates := []*gtfs.TripUpdate{}
+	vehicles := make(map[string]*gtfs.VehiclePosition)
+	for _, raw := range feeds {
+		if raw == nil {
+			// a feed that could not be fetched
+			continue
+		}
+		msg := &gtfs.FeedMessage{}
+		if err := proto.Unmarshal(raw, msg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal: %w", err)
+		}
+		if ts := msg.GetHeader().GetTimestamp(); ts != 0 {
+			now = time.Unix(int64(ts), 0).UTC()
+		}
+		for _, entity := range msg.GetEntity() {
+			if entity.GetIsDeleted() {
+				continue
+			}
+			if tu := entity.GetTripUpdate(); tu != nil {
+				updates = append(updates, tu)
+			}
+			if vp := entity.GetVehicle(); vp != nil && vp.GetTrip().GetTripId() != "" {
+				vehicles[vp.GetTrip().GetTripId()] = vp
+			}
+		}
+	}
+	// the feed timestamp is used to find the next stop, fall back to the
+	// current time if the producer left it out
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+
+	d := c.dataset()
+	ret := []TrainStatus{}
+	seen := make(map[string]struct{})
+	for _, tu := range updates {
+		trip := tu.GetTrip()
+		if trip.GetScheduleRelationship() == gtfs.TripDescriptor_CANCELED {
+			continue
+		}
+		if _, ok := seen[trip.GetTripId()]; ok {
+			// the same update was passed in more than one feed
+			continue
+		}
+		train, ok, err := c.tripUpdateToStatus(d, tu, code, now)
+		if err != nil {
+			return ret, fmt.Errorf("could not get trains: %w", err)
+		}
+		seen[trip.GetTripId()] = struct{}{}
+		if vp, found := vehicles[trip.GetTripId()]; found {
+			setVehiclePosition(&train, vp)
+		}
+		if ok {
+			ret = append(ret, train)
+		}
+	}
+
+	// trains that only report a position are added without a prediction
+	for id, vp := range vehicles {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		if code != "" && vp.GetStopId() != code {
+			continue
+		}
+		train, err := c.vehicleToStatus(d, vp)
+		if err != nil {
+			return ret, fmt.Errorf("could not get trains: %w", err)
+		}
+		ret = append(ret, train)
+	}
+	return ret, nil
+}
+
+// tripUpdateToStatus converts a TripUpdate into a TrainStatus. If code is
+// empty the status is for the next stop after now, otherwise it is for code.
+// It returns false if the train has no matching stop
+func (c *CaltrainClient) tripUpdateToStatus(d *Dataset, tu *gtfs.TripUpdate, code string, now time.Time) (TrainStatus, bool, error) {
+	trip := tu.GetTrip()
+	train := TrainStatus{TrainNum: d.trainNumber(trip.GetTripId())}
+
+	var stu *gtfs.TripUpdate_StopTimeUpdate
+	var event *gtfs.TripUpdate_StopTimeEvent
+	for _, s := range tu.GetStopTimeUpdate() {
+		rel := s.GetScheduleRelationship()
+		if rel == gtfs.TripUpdate_StopTimeUpdate_SKIPPED || rel == gtfs.TripUpdate_StopTimeUpdate_NO_DATA {
+			continue
+		}
+		ev := s.GetArrival()
+		if ev == nil {
+			ev = s.GetDeparture()
+		}
+		if code != "" {
+			if s.GetStopId() == code {
+				stu, event = s, ev
+				break
+			}
+			continue
+		}
+		if ev.GetTime() == 0 || !time.Unix(ev.GetTime(), 0).Before(now) {
+			stu, event = s, ev
+			break
+		}
+	}
+	if stu == nil {
+		return train, false, nil
+	}
+
+	if stu.GetStopId() != "" {
+		train.NextStop = d.getStationFromCode(stu.GetStopId())
+		north, err := isCodeNorth(stu.GetStopId())
+		if err != nil {
+			return train, false, err
+		}
+		if north {
+			train.Direction = North
+		} else {
+			train.Direction = South
+		}
+	}
+
+	line, err := d.tripLine(trip)
+	if err != nil {
+		return train, false, err
+	}
+	train.Line = line
+
+	scheduled, hasSchedule := c.scheduledTime(d, train.TrainNum, stu.GetStopId(), trip.GetStartDate(), now)
+	if event.GetTime() != 0 {
+		train.Arrival = time.Unix(event.GetTime(), 0).UTC()
+	}
+	switch {
+	case event != nil && event.Delay != nil:
+		train.Delay = time.Duration(event.GetDelay()) * time.Second
+	case tu.Delay != nil:
+		train.Delay = time.Duration(tu.GetDelay()) * time.Second
+	case hasSchedule && !train.Arrival.IsZero():
+		train.Delay = train.Arrival.Sub(scheduled)
+	}
+	if train.Arrival.IsZero() && hasSchedule {
+		train.Arrival = scheduled.Add(train.Delay).UTC()
+	}
+	if train.Delay < 0 {
+		train.Delay = 0
+	}
+	return train, true, nil
+}
+
+// vehicleToStatus converts a VehiclePosition into a TrainStatus. There is no
+// prediction in a position, so the delay and arrival are not set
+func (c *CaltrainClient) vehicleToStatus(d *Dataset, vp *gtfs.VehiclePosition) (TrainStatus, error) {
+	train := TrainStatus{TrainNum: d.trainNumber(vp.GetTrip().GetTripId())}
+	if vp.GetStopId() != "" {
+		train.NextStop = d.getStationFromCode(vp.GetStopId())
+		north, err := isCodeNorth(vp.GetStopId())
+		if err != nil {
+			return train, err
+		}
+		if north {
+			train.Direction = North
+		} else {
+			train.Direction = South
+		}
+	}
+	line, err := d.tripLine(vp.GetTrip())
+	if err != nil {
+		return train, err
+	}
+	train.Line = line
+	setVehiclePosition(&train, vp)
+	return train, nil
+}
+
+// setVehiclePosition copies the vehicle, its position and its occupancy from
+// a VehiclePosition into a TrainStatus
+func setVehiclePosition(train *TrainStatus, vp *gtfs.VehiclePosition) {
+	train.Vehicle = vp.GetVehicle().GetId()
+	if train.Vehicle == "" {
+		train.Vehicle = vp.GetVehicle().GetLabel()
+	}
+	if pos := vp.GetPosition(); pos != nil {
+		train.Location = Location{Latitude: float64(pos.GetLatitude()), Longitude: float64(pos.GetLongitude())}
+		train.Bearing = float64(pos.GetBearing())
+	}
+	train.Occupancy = gtfsOccupancy(vp)
+}
+
+// tripLine returns the line for a trip, using the route ID if it is set and
+// the timetable otherwise
+func (d *Dataset) tripLine(trip *gtfs.TripDescriptor) (Line, error) {
+	if trip.GetRouteId() != "" {
+		return parseLine(trip.GetRouteId(), d.lines)
+	}
+	journey, err := d.getRouteForTrain(d.trainNumber(trip.GetTripId()))
+	if err != nil {
+		// the train is not in the timetable, there's no way to know the line
+		return Line{}, nil
+	}
+	return parseLine(journey.Line, d.lines)
+}
+
+// scheduledTime returns the scheduled arrival time of a train at a stop code
+// according to the timetable. The service date is taken from startDate in the
+// GTFS YYYYMMDD format, or from now if it is empty
+func (c *CaltrainClient) scheduledTime(d *Dataset, trainNum, code, startDate string, now time.Time) (time.Time, bool) {
+	journey, err := d.getRouteForTrain(trainNum)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var date time.Time
+	if startDate != "" {
+		date, err = time.ParseInLocation("20060102", startDate, c.tz)
+		if err != nil {
+			return time.Time{}, false
+		}
+	} else {
+		n := now.In(c.tz)
+		date = time.Date(n.Year(), n.Month(), n.Day(), 0, 0, 0, 0, c.tz)
+	}
+
+	for _, call := range journey.Calls.Call {
+		if call.ScheduledStopPointRef.Ref != code {
+			continue
+		}
+		t, err := time.Parse("15:04:05", call.Arrival.Time)
+		if err != nil {
+			return time.Time{}, false
+		}
+		offset, _ := strconv.Atoi(call.Arrival.DaysOffset)
+		return time.Date(date.Year(), date.Month(), date.Day()+offset, t.Hour(), t.Minute(), t.Second(), 0, c.tz), true
+	}
+	return time.Time{}, false
+}
+
+// gtfsRealtimeVisits unmarshals the feed and returns a map of train number to
+// the train's predictions for each stop, matching getStopVisits
+func (c *CaltrainClient) gtfsRealtimeVisits(raw []byte) (map[string][]stopVisit, error) {
+	msg := &gtfs.FeedMessage{}
+	if err := proto.Unmarshal(raw, msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+
+	d := c.dataset()
+	ret := make(map[string][]stopVisit)
+	for _, entity := range msg.GetEntity() {
+		tu := entity.GetTripUpdate()
+		if entity.GetIsDeleted() || tu == nil {
+			continue
+		}
+		num := d.trainNumber(tu.GetTrip().GetTripId())
+		if tu.GetTrip().GetScheduleRelationship() == gtfs.TripDescriptor_CANCELED {
+			ret[num] = []stopVisit{{cancelled: true}}
+			continue
+		}
+		for _, stu := range tu.GetStopTimeUpdate() {
+			rel := stu.GetScheduleRelationship()
+			if rel == gtfs.TripUpdate_StopTimeUpdate_SKIPPED || rel == gtfs.TripUpdate_StopTimeUpdate_NO_DATA {
+				continue
+			}
+			v := stopVisit{code: stu.GetStopId()}
+			if t := stu.GetArrival().GetTime(); t != 0 {
+				v.expectedArrival = time.Unix(t, 0).UTC()
+			}
+			if t := stu.GetDeparture().GetTime(); t != 0 {
+				v.expectedDeparture = time.Unix(t, 0).UTC()
+			}
+			expected := v.expectedArrival
+			if expected.IsZero() {
+				expected = v.expectedDeparture
+			}
+
+			// the events are nil when the update leaves them out
+			var delay *int32
+			switch {
+			case stu.GetArrival() != nil && stu.GetArrival().Delay != nil:
+				delay = stu.GetArrival().Delay
+			case stu.GetDeparture() != nil && stu.GetDeparture().Delay != nil:
+				delay = stu.GetDeparture().Delay
+			case tu.Delay != nil:
+				delay = tu.Delay
+			}
+			if delay != nil && !expected.IsZero() {
+				v.aimedArrival = expected.Add(-time.Duration(*delay) * time.Second)
+			} else if scheduled, ok := c.scheduledTime(d, num, v.code, tu.GetTrip().GetStartDate(), expected); ok {
+				v.aimedArrival = scheduled.UTC()
+			}
+			v.aimedDeparture = v.aimedArrival
+			if v.expectedArrival.IsZero() {
+				v.expectedArrival = v.expectedDeparture
+			}
+			if v.expectedDeparture.IsZero() {
+				v.expectedDeparture = v.expectedArrival
+			}
+			ret[num] = append(ret[num], v)
+		}
+	}
+	return ret, nil
+}
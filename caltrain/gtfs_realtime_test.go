@@ -0,0 +1,198 @@
+package caltrain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+	"google.golang.org/protobuf/proto"
+)
+
+// 2019-11-22 06:30 pacific, while train 101 is between Palo Alto and Hillsdale
+var gtfsRealtimeNow = time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC)
+
+// stopTimeUpdate returns a StopTimeUpdate that arrives at the given time. A
+// negative delay is left unset
+func stopTimeUpdate(code string, arrival time.Time, delay int32) *gtfs.TripUpdate_StopTimeUpdate {
+	ev := &gtfs.TripUpdate_StopTimeEvent{Time: proto.Int64(arrival.Unix())}
+	if delay >= 0 {
+		ev.Delay = proto.Int32(delay)
+	}
+	return &gtfs.TripUpdate_StopTimeUpdate{StopId: proto.String(code), Arrival: ev}
+}
+
+// gtfsRealtimeFixture returns a marshaled FeedMessage with a delayed train,
+// an on time train with no delay field, a cancelled train, and a train that
+// only reports its position. The trips have the IDs of the GTFS fixtures
+func gtfsRealtimeFixture(t *testing.T) []byte {
+	t.Helper()
+	msg := &gtfs.FeedMessage{
+		Header: &gtfs.FeedHeader{
+			GtfsRealtimeVersion: proto.String("2.0"),
+			Timestamp:           proto.Uint64(uint64(gtfsRealtimeNow.Unix())),
+		},
+		Entity: []*gtfs.FeedEntity{
+			{
+				// 101 is 12 minutes late
+				Id: proto.String("1"),
+				TripUpdate: &gtfs.TripUpdate{
+					Trip: &gtfs.TripDescriptor{TripId: proto.String("101a"), RouteId: proto.String("Local")},
+					StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+						stopTimeUpdate("70171", gtfsRealtimeNow.Add(-2*time.Minute), 720),
+						stopTimeUpdate("70111", gtfsRealtimeNow.Add(17*time.Minute), 720),
+						stopTimeUpdate("70101", gtfsRealtimeNow.Add(20*time.Minute), 720),
+					},
+				},
+			},
+			{
+				// 102 has no delay field or route, so both come from the
+				// timetable. It is 3 minutes late into Hayward Park
+				Id: proto.String("2"),
+				TripUpdate: &gtfs.TripUpdate{
+					Trip: &gtfs.TripDescriptor{TripId: proto.String("102a"), StartDate: proto.String("20191122")},
+					StopTimeUpdate: []*gtfs.TripUpdate_StopTimeUpdate{
+						stopTimeUpdate("70102", gtfsRealtimeNow.Add(5*time.Minute), -1),
+						stopTimeUpdate("70112", gtfsRealtimeNow.Add(8*time.Minute), -1),
+					},
+				},
+			},
+			{
+				Id: proto.String("3"),
+				TripUpdate: &gtfs.TripUpdate{
+					Trip: &gtfs.TripDescriptor{
+						TripId:               proto.String("103a"),
+						RouteId:              proto.String("Local"),
+						ScheduleRelationship: gtfs.TripDescriptor_CANCELED.Enum(),
+					},
+				},
+			},
+			{
+				Id: proto.String("4"),
+				Vehicle: &gtfs.VehiclePosition{
+					Trip:   &gtfs.TripDescriptor{TripId: proto.String("502a"), RouteId: proto.String("Bullet")},
+					StopId: proto.String("70062"),
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed to marshal feed: %v", err)
+	}
+	return data
+}
+
+func TestParseGTFSRealtime(t *testing.T) {
+	c := newGTFSClient(t)
+	data := gtfsRealtimeFixture(t)
+
+	trains, err := c.ParseGTFSRealtime(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := []TrainStatus{
+		{TrainNum: "101", NextStop: StationHillsdale, Direction: North, Delay: 12 * time.Minute, Arrival: gtfsRealtimeNow.Add(17 * time.Minute), Line: Line{"Local", "Local"}},
+		{TrainNum: "102", NextStop: StationHaywardPark, Direction: South, Delay: 3 * time.Minute, Arrival: gtfsRealtimeNow.Add(5 * time.Minute), Line: Line{"Local", "Local"}},
+		{TrainNum: "502", NextStop: StationMillbrae, Direction: South, Line: Line{"Bullet", "Bullet"}},
+	}
+	if !assertTrainStatusEqual(exp, trains) {
+		t.Fatalf("Unexpected trains\nexpected: %v\nreceived: %v", exp, trains)
+	}
+
+	// filtering by a stop code reports the status at that stop
+	trains, err = c.parseGTFSRealtime("70101", data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp = []TrainStatus{
+		{TrainNum: "101", NextStop: StationHaywardPark, Direction: North, Delay: 12 * time.Minute, Arrival: gtfsRealtimeNow.Add(20 * time.Minute), Line: Line{"Local", "Local"}},
+	}
+	if !assertTrainStatusEqual(exp, trains) {
+		t.Fatalf("Unexpected trains\nexpected: %v\nreceived: %v", exp, trains)
+	}
+
+	if _, err := c.ParseGTFSRealtime([]byte("not a protobuf")); err == nil {
+		t.Fatalf("ParseGTFSRealtime improperly succeeded with bad data")
+	}
+}
+
+// apiClientFeeds returns the feed for each url, and an error for the others
+type apiClientFeeds map[string][]byte
+
+func (a apiClientFeeds) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	data, ok := a[url]
+	if !ok {
+		return nil, &APIError{Status: "404 Not Found", Code: 404, Url: url}
+	}
+	return data, nil
+}
+
+// vehiclePositionsFixture returns a marshaled FeedMessage with the position
+// of train 101
+func vehiclePositionsFixture(t *testing.T) []byte {
+	t.Helper()
+	msg := &gtfs.FeedMessage{
+		Header: &gtfs.FeedHeader{GtfsRealtimeVersion: proto.String("2.0")},
+		Entity: []*gtfs.FeedEntity{
+			{
+				Id: proto.String("v1"),
+				Vehicle: &gtfs.VehiclePosition{
+					Trip:     &gtfs.TripDescriptor{TripId: proto.String("101a")},
+					Vehicle:  &gtfs.VehicleDescriptor{Id: proto.String("917")},
+					Position: &gtfs.Position{Latitude: proto.Float32(37.5), Longitude: proto.Float32(-122.3)},
+					StopId:   proto.String("70111"),
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("failed to marshal feed: %v", err)
+	}
+	return data
+}
+
+func TestGetDelaysGTFSRealtime(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	c.SetLiveFeed(GTFSRealtimeFeed)
+	c.APIClient = apiClientFeeds{
+		tripUpdatesURL: gtfsRealtimeFixture(t),
+		vehiclesURL:    vehiclePositionsFixture(t),
+	}
+
+	delays, _, err := c.GetDelays(ctx, 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(delays) != 1 || delays[0].TrainNum != "101" {
+		t.Fatalf("Unexpected delays: %v", delays)
+	}
+	if delays[0].Vehicle != "917" || !delays[0].HasLocation() {
+		t.Fatalf("The position of 101 was not merged from the VehiclePositions feed: %+v", delays[0])
+	}
+
+	trains, _, err := c.GetStationStatus(ctx, StationHillsdale, South)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trains) != 1 || trains[0].TrainNum != "102" || trains[0].NextStop != StationHillsdale {
+		t.Fatalf("Unexpected trains: %v", trains)
+	}
+}
+
+func TestGetDelaysGTFSRealtimeNoPositions(t *testing.T) {
+	c := newGTFSClient(t)
+	c.SetLiveFeed(GTFSRealtimeFeed)
+	c.APIClient = apiClientFeeds{tripUpdatesURL: gtfsRealtimeFixture(t)}
+
+	// the trip updates are returned without the positions
+	delays, _, err := c.GetDelays(context.Background(), 10*time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(delays) != 1 || delays[0].TrainNum != "101" || delays[0].HasLocation() {
+		t.Fatalf("Unexpected delays: %v", delays)
+	}
+}
@@ -0,0 +1,187 @@
+package caltrain
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// gtfsFixture zips the files in testdata/gtfs, skipping any file in exclude
+func gtfsFixture(t *testing.T, exclude ...string) *bytes.Reader {
+	t.Helper()
+	files, err := filepath.Glob("testdata/gtfs/*.txt")
+	if err != nil {
+		t.Fatalf("failed to find GTFS fixtures: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+outer:
+	for _, file := range files {
+		for _, e := range exclude {
+			if filepath.Base(file) == e {
+				continue outer
+			}
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file, err)
+		}
+		w, err := zw.Create(filepath.Base(file))
+		if err != nil {
+			t.Fatalf("failed to zip %s: %v", file, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("failed to zip %s: %v", file, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to zip GTFS fixtures: %v", err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// newGTFSClient returns a CaltrainClient loaded with the GTFS fixtures
+func newGTFSClient(t *testing.T) *CaltrainClient {
+	t.Helper()
+	c := New(fakeKey)
+	r := gtfsFixture(t)
+	if err := c.LoadGTFSReader(r, r.Size()); err != nil {
+		t.Fatalf("Unexpected error loading GTFS feed: %v", err)
+	}
+	return c
+}
+
+func TestLoadGTFS(t *testing.T) {
+	c := newGTFSClient(t)
+
+	if len(c.AllLines()) != 2 {
+		t.Fatalf("Incorrect number of lines. Expected %d, received %d", 2, len(c.AllLines()))
+	}
+	if len(c.dataset().stations) != 6 {
+		t.Fatalf("Incorrect number of stations. Expected %d, received %d", 6, len(c.dataset().stations))
+	}
+	hd := c.dataset().stations[StationHillsdale]
+	if hd == nil || hd.northCode != "70111" || hd.southCode != "70112" {
+		t.Fatalf("Unexpected station info for Hillsdale: %v", hd)
+	}
+	if len(c.Holidays()) != 2 {
+		t.Fatalf("Incorrect number of holidays. Expected %d, received %d", 2, len(c.Holidays()))
+	}
+	if num := c.dataset().trainNumber("101a"); num != "101" {
+		t.Fatalf("Unexpected train number for trip 101a. Expected %s, received %s", "101", num)
+	}
+	if !c.IsHoliday(time.Date(2019, time.November, 28, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Thanksgiving should be a holiday")
+	}
+}
+
+func TestLoadGTFSMissingFile(t *testing.T) {
+	c := New(fakeKey)
+	r := gtfsFixture(t, "stop_times.txt")
+	if err := c.LoadGTFSReader(r, r.Size()); err == nil {
+		t.Fatalf("LoadGTFSReader improperly succeeded without stop_times.txt")
+	}
+
+	// calendar_dates.txt is optional
+	r = gtfsFixture(t, "calendar_dates.txt")
+	if err := c.LoadGTFSReader(r, r.Size()); err != nil {
+		t.Fatalf("Unexpected error loading GTFS feed: %v", err)
+	}
+	if len(c.Holidays()) != 0 {
+		t.Fatalf("Incorrect number of holidays. Expected %d, received %d", 0, len(c.Holidays()))
+	}
+}
+
+func TestGTFSQueries(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+
+	tests := []struct {
+		name string
+		src  Station
+		dst  Station
+		date time.Time
+		num  int
+	}{
+		{name: "Weekday", src: StationSanJose, dst: StationSanFrancisco, date: time.Date(2019, time.November, 22, 0, 0, 0, 0, time.UTC), num: 4},
+		{name: "Holiday", src: StationSanJose, dst: StationSanFrancisco, date: time.Date(2019, time.November, 28, 0, 0, 0, 0, time.UTC), num: 1},
+		{name: "Weekend", src: StationSanFrancisco, dst: StationPaloAlto, date: time.Date(2019, time.November, 24, 0, 0, 0, 0, time.UTC), num: 1},
+		{name: "Local Only", src: StationHaywardPark, dst: StationSanFrancisco, date: time.Date(2019, time.November, 22, 0, 0, 0, 0, time.UTC), num: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := c.GetTrainsBetweenStationsForDate(ctx, tt.src, tt.dst, tt.date)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(routes) != tt.num {
+				t.Fatalf("Incorrect routes. Expected %d, received %d", tt.num, len(routes))
+			}
+		})
+	}
+
+	routes, err := c.GetStationTimetable(StationHillsdale, South, time.Date(2019, time.November, 22, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("Incorrect routes. Expected %d, received %d", 3, len(routes))
+	}
+
+	route, err := c.GetTrainRoute("199")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if route.Direction != North || route.Line.Name != "Local" || route.NumStops != 6 {
+		t.Fatalf("Unexpected route: %v", route)
+	}
+	last := route.Stops[len(route.Stops)-1]
+	exp := time.Date(0, time.January, 2, 0, 40, 0, 0, time.UTC)
+	if last.Station != StationSanFrancisco || !last.Arrival.Equal(exp) {
+		t.Fatalf("Unexpected last stop\nExpected: %s at %s\nReceived: %s at %s", StationSanFrancisco, exp, last.Station, last.Arrival)
+	}
+}
+
+func TestGTFSTime(t *testing.T) {
+	tests := []struct {
+		in     string
+		time   string
+		offset string
+		err    bool
+	}{
+		{in: "06:30:00", time: "06:30:00", offset: "0"},
+		{in: "6:30:00", time: "06:30:00", offset: "0"},
+		{in: "24:05:00", time: "00:05:00", offset: "1"},
+		{in: "25:10:30", time: "01:10:30", offset: "1"},
+		{in: "25:10", err: true},
+		{in: "aa:10:00", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			tm, offset, err := gtfsTime(tt.in)
+			if err != nil && !tt.err {
+				t.Fatalf("Unexpected error: %v", err)
+			} else if err == nil && tt.err {
+				t.Fatalf("gtfsTime improperly succeeded for %s", tt.in)
+			}
+			if tm != tt.time || offset != tt.offset {
+				t.Fatalf("Unexpected time. Expected %s+%s, received %s+%s", tt.time, tt.offset, tm, offset)
+			}
+		})
+	}
+}
+
+func TestGTFSJourneyEmptyStop(t *testing.T) {
+	trip := map[string]string{"trip_id": "101a", "trip_short_name": "101", "route_id": "Local"}
+	stops := []map[string]string{
+		{"stop_id": "", "stop_sequence": "1", "arrival_time": "06:00:00", "departure_time": "06:00:00"},
+	}
+	if _, err := gtfsJourney(trip, stops); err == nil {
+		t.Fatalf("gtfsJourney improperly succeeded for a stop without an ID")
+	}
+}
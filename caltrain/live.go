@@ -0,0 +1,197 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// live.go contains the helpers that merge the live status of the trains into
+// the scheduled routes
+
+// GetLiveRoute returns the Route for a given train merged with the latest
+// live status. It makes an API call for the live status of all trains
+func (c *CaltrainClient) GetLiveRoute(ctx context.Context, trainNum string) (*LiveRoute, error) {
+	route, err := c.GetTrainRoute(trainNum)
+	if err != nil {
+		return nil, err
+	}
+	live, err := c.AnnotateRoutes(ctx, []*Route{route})
+	if len(live) == 0 || live[0] == nil {
+		return nil, err
+	}
+	return live[0], err
+}
+
+// AnnotateRoutes merges the latest live status into each of the routes. Stops
+// without a prediction are expected to keep the delay of the last predicted
+// stop before them. It makes one API call for the live status of all trains.
+// If the call fails and a stale status is cached, the routes are annotated
+// with it and returned along with the error
+func (c *CaltrainClient) AnnotateRoutes(ctx context.Context, routes []*Route) ([]*LiveRoute, error) {
+	logrus.Debugf("Annotating %d routes with live status...", len(routes))
+	visits, updated, err := c.getStopVisits(ctx)
+	if err != nil {
+		if visits == nil {
+			return nil, fmt.Errorf("failed to get live status: %w", err)
+		}
+		// annotate the routes with the stale status and return the error
+		// along with them
+		err = fmt.Errorf("failed to get live status: %w", err)
+	}
+
+	now := c.clock.Now()
+	d := c.dataset()
+	ret := make([]*LiveRoute, len(routes))
+	for i, r := range routes {
+		live, aerr := c.annotateRoute(d, r, visits[r.TrainNum], now)
+		if aerr != nil {
+			return ret, fmt.Errorf("failed to annotate train %s: %w", r.TrainNum, aerr)
+		}
+		live.Updated = updated
+		ret[i] = live
+	}
+	return ret, err
+}
+
+// getStopVisits makes an API call for the live status of all trains and
+// returns the predictions for each train
+func (c *CaltrainClient) getStopVisits(ctx context.Context) (map[string][]stopVisit, time.Time, error) {
+	query := map[string]string{
+		"agency":  "CT",
+		"api_key": c.key,
+	}
+	url := delayURL
+	parse := getStopVisits
+	if c.liveFeed == GTFSRealtimeFeed {
+		url = tripUpdatesURL
+		parse = c.gtfsRealtimeVisits
+	}
+
+	v, t, err := c.fetchLive(ctx, "get live status", url, url, query, func(data []byte) (interface{}, error) {
+		visits, err := parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse live status: %w", err)
+		}
+		return visits, nil
+	})
+	visits, _ := v.(map[string][]stopVisit)
+	return visits, t, err
+}
+
+// annotateRoute merges the predictions for a train into its route
+func (c *CaltrainClient) annotateRoute(d *Dataset, r *Route, visits []stopVisit, now time.Time) (*LiveRoute, error) {
+	live := &LiveRoute{
+		Route: r,
+		Stops: make([]LiveStop, len(r.Stops)),
+	}
+	for i, stop := range r.Stops {
+		live.Stops[i] = LiveStop{TrainStop: stop}
+	}
+	if len(r.Stops) == 0 {
+		return live, nil
+	}
+
+	byCode := make(map[string]stopVisit, len(visits))
+	for _, v := range visits {
+		if v.cancelled {
+			live.State = Cancelled
+			return live, nil
+		}
+		byCode[v.code] = v
+	}
+
+	// the next stop is the first stop on the route with a prediction
+	next := -1
+	codes := make([]string, len(r.Stops))
+	for i, stop := range r.Stops {
+		code, err := d.getStationCode(stop.Station, r.Direction)
+		if err != nil {
+			return live, err
+		}
+		codes[i] = code
+		if _, ok := byCode[code]; ok && next < 0 {
+			next = i
+		}
+	}
+
+	if next < 0 {
+		// the train is not in the live feed, use the schedule to guess why
+		date := serviceDate(now.In(c.tz))
+		start := atServiceDate(date, r.Stops[0].Departure)
+		end := atServiceDate(date, r.Stops[len(r.Stops)-1].Arrival)
+		switch {
+		case now.Before(start):
+			live.State = Scheduled
+		case now.After(end):
+			live.State = Completed
+		default:
+			live.State = NotTracked
+		}
+		for i, stop := range r.Stops {
+			live.Stops[i].Passed = live.State == Completed || now.After(atServiceDate(date, stop.Departure))
+		}
+		return live, nil
+	}
+
+	live.State = Tracked
+	date := c.visitServiceDate(byCode[codes[next]], r.Stops[next])
+	var delay time.Duration
+	for i := range r.Stops {
+		s := &live.Stops[i]
+		if i < next {
+			s.Passed = true
+			continue
+		}
+		if v, ok := byCode[codes[i]]; ok {
+			delay = v.delay()
+			s.ExpectedArrival = v.expectedArrival
+			s.ExpectedDeparture = v.expectedDeparture
+			s.Predicted = true
+		} else {
+			s.ExpectedArrival = atServiceDate(date, s.Arrival).Add(delay).UTC()
+			s.ExpectedDeparture = atServiceDate(date, s.Departure).Add(delay).UTC()
+		}
+		if s.ExpectedArrival.IsZero() {
+			s.ExpectedArrival = s.ExpectedDeparture
+		}
+		if s.ExpectedDeparture.IsZero() {
+			s.ExpectedDeparture = s.ExpectedArrival
+		}
+		s.Delay = delay
+		if i == next {
+			live.Delay = delay
+		}
+	}
+	return live, nil
+}
+
+// visitServiceDate returns the service date of a train from the scheduled
+// time of one of its predictions. Trains that run past midnight started on
+// the day before the prediction
+func (c *CaltrainClient) visitServiceDate(v stopVisit, stop TrainStop) time.Time {
+	aimed := v.aimedArrival
+	if aimed.IsZero() {
+		aimed = v.aimedDeparture
+	}
+	if aimed.IsZero() {
+		aimed = c.clock.Now()
+	}
+	date := serviceDate(aimed.In(c.tz))
+	days := stop.Arrival.YearDay() - timetableDay.YearDay()
+	return date.AddDate(0, 0, -days)
+}
+
+// serviceDate returns midnight on the date of t, in the location of t
+func serviceDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// atServiceDate returns the wall clock time of a timetable time on the given
+// service date
+func atServiceDate(date, t time.Time) time.Time {
+	days := t.YearDay() - timetableDay.YearDay()
+	return time.Date(date.Year(), date.Month(), date.Day()+days, t.Hour(), t.Minute(), t.Second(), 0, date.Location())
+}
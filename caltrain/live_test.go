@@ -0,0 +1,143 @@
+package caltrain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestGetLiveRoute(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/liveRoute.json"}
+	mock := clock.NewMock()
+	mock.Set(time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC))
+	c.clock = mock
+
+	live, err := c.GetLiveRoute(ctx, "101")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if live.State != Tracked {
+		t.Fatalf("Unexpected state. Expected %s, received %s", Tracked, live.State)
+	}
+	if live.Delay != 5*time.Minute {
+		t.Fatalf("Unexpected delay. Expected %s, received %s", 5*time.Minute, live.Delay)
+	}
+
+	utc := func(h, m int) time.Time {
+		return time.Date(2019, time.November, 22, h, m, 0, 0, time.UTC)
+	}
+	exp := []struct {
+		station   Station
+		passed    bool
+		predicted bool
+		delay     time.Duration
+		arrival   time.Time
+	}{
+		{station: StationSanJose, passed: true},
+		{station: StationPaloAlto, passed: true},
+		{station: StationHillsdale, predicted: true, delay: 5 * time.Minute, arrival: utc(14, 40)},
+		{station: StationHaywardPark, predicted: true, delay: 7 * time.Minute, arrival: utc(14, 45)},
+		// the last prediction is propagated to the remaining stops
+		{station: StationMillbrae, delay: 7 * time.Minute, arrival: utc(14, 57)},
+		{station: StationSanFrancisco, delay: 7 * time.Minute, arrival: utc(15, 17)},
+	}
+	if len(live.Stops) != len(exp) {
+		t.Fatalf("Incorrect number of stops. Expected %d, received %d", len(exp), len(live.Stops))
+	}
+	for i, e := range exp {
+		s := live.Stops[i]
+		if s.Station != e.station || s.Passed != e.passed || s.Predicted != e.predicted || s.Delay != e.delay || !s.ExpectedArrival.Equal(e.arrival) {
+			t.Fatalf("Unexpected stop %d\nExpected: %+v\nReceived: %+v", i, e, s)
+		}
+	}
+}
+
+func TestAnnotateRoutesState(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/liveRoute.json"}
+	mock := clock.NewMock()
+	c.clock = mock
+
+	tests := []struct {
+		name  string
+		train string
+		now   time.Time
+		state LiveState
+	}{
+		{name: "Tracked", train: "101", now: time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC), state: Tracked},
+		{name: "Scheduled", train: "103", now: time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC), state: Scheduled},
+		{name: "NotTracked", train: "102", now: time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC), state: NotTracked},
+		{name: "Completed", train: "102", now: time.Date(2019, time.November, 22, 16, 30, 0, 0, time.UTC), state: Completed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.Set(tt.now)
+			route, err := c.GetTrainRoute(tt.train)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			live, err := c.AnnotateRoutes(ctx, []*Route{route})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if live[0].State != tt.state {
+				t.Fatalf("Unexpected state. Expected %s, received %s", tt.state, live[0].State)
+			}
+			if live[0].Route != route {
+				t.Fatalf("Live route does not reference the scheduled route")
+			}
+		})
+	}
+}
+
+func TestGetLiveRouteErrors(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/liveRoute.json"}
+
+	if _, err := c.GetLiveRoute(ctx, "999"); err == nil {
+		t.Fatalf("GetLiveRoute improperly succeeded for an unknown train")
+	}
+
+	c.APIClient = &apiClientMock{GetResult: []byte("not json")}
+	if _, err := c.GetLiveRoute(ctx, "101"); err == nil {
+		t.Fatalf("GetLiveRoute improperly succeeded with bad data")
+	}
+}
+
+func TestAnnotateRoutesCancelled(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	c.SetLiveFeed(GTFSRealtimeFeed)
+	c.APIClient = &apiClientMock{GetResult: gtfsRealtimeFixture(t)}
+	mock := clock.NewMock()
+	mock.Set(gtfsRealtimeNow)
+	c.clock = mock
+
+	// trip 103a is cancelled in the feed
+	live, err := c.GetLiveRoute(ctx, "103")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if live.State != Cancelled {
+		t.Fatalf("Unexpected state. Expected %s, received %s", Cancelled, live.State)
+	}
+	for i, s := range live.Stops {
+		if s.Passed || s.Predicted {
+			t.Fatalf("Unexpected stop %d of a cancelled train: %+v", i, s)
+		}
+	}
+
+	live, err = c.GetLiveRoute(ctx, "101")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if live.State != Tracked {
+		t.Fatalf("Unexpected state. Expected %s, received %s", Tracked, live.State)
+	}
+}
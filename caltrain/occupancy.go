@@ -0,0 +1,151 @@
+package caltrain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+// occupancy.go contains the crowding level reported by the live feeds, and the
+// options that sort and filter the live status by it
+
+// An Occupancy specifies how crowded a train is. The levels other than
+// OccupancyUnknown are in order from the least to the most crowded
+type Occupancy int
+
+const (
+	// OccupancyUnknown is used when the feed doesn't report the crowding
+	OccupancyUnknown Occupancy = iota
+	// OccupancyEmpty is a train with few or no passengers
+	OccupancyEmpty
+	// OccupancyManySeats is a train with many seats available
+	OccupancyManySeats
+	// OccupancyFewSeats is a train with few seats available
+	OccupancyFewSeats
+	// OccupancyStanding is a train with standing room only
+	OccupancyStanding
+	// OccupancyFull is a train that is full or not taking passengers
+	OccupancyFull
+)
+
+var occupancies = [...]string{
+	"Unknown",
+	"Empty",
+	"Many Seats",
+	"Few Seats",
+	"Standing",
+	"Full",
+}
+
+// String returns the string name of the occupancy. String values are show in
+// the Occupancy constant definition
+func (o Occupancy) String() string {
+	if OccupancyUnknown <= o && o <= OccupancyFull {
+		return occupancies[o]
+	}
+	return fmt.Sprintf("unknown occupancy %d", o)
+}
+
+// parseSIRIOccupancy returns the occupancy from the StopMonitoring json,
+// which is null or a SIRI OccupancyEnumeration such as "seatsAvailable"
+func parseSIRIOccupancy(v interface{}) Occupancy {
+	s, ok := v.(string)
+	if !ok {
+		return OccupancyUnknown
+	}
+	switch strings.ToLower(s) {
+	case "empty":
+		return OccupancyEmpty
+	case "manyseatsavailable", "seatsavailable":
+		return OccupancyManySeats
+	case "fewseatsavailable":
+		return OccupancyFewSeats
+	case "standingavailable", "standingroomonly", "crushedstandingroomonly":
+		return OccupancyStanding
+	case "full", "notacceptingpassengers":
+		return OccupancyFull
+	}
+	return OccupancyUnknown
+}
+
+// gtfsOccupancy returns the occupancy of a GTFS-Realtime VehiclePosition
+func gtfsOccupancy(vp *gtfs.VehiclePosition) Occupancy {
+	// the getter returns EMPTY when the status is not set
+	if vp.OccupancyStatus == nil {
+		return OccupancyUnknown
+	}
+	switch vp.GetOccupancyStatus() {
+	case gtfs.VehiclePosition_EMPTY:
+		return OccupancyEmpty
+	case gtfs.VehiclePosition_MANY_SEATS_AVAILABLE:
+		return OccupancyManySeats
+	case gtfs.VehiclePosition_FEW_SEATS_AVAILABLE:
+		return OccupancyFewSeats
+	case gtfs.VehiclePosition_STANDING_ROOM_ONLY, gtfs.VehiclePosition_CRUSHED_STANDING_ROOM_ONLY:
+		return OccupancyStanding
+	case gtfs.VehiclePosition_FULL, gtfs.VehiclePosition_NOT_ACCEPTING_PASSENGERS:
+		return OccupancyFull
+	}
+	return OccupancyUnknown
+}
+
+// A StatusOption sorts or filters the trains returned by GetStationStatus
+type StatusOption func(*statusOptions)
+
+type statusOptions struct {
+	sortByOccupancy bool
+	maxOccupancy    Occupancy
+}
+
+// SortByOccupancy sorts the trains from the least to the most crowded. Trains
+// with an unknown occupancy are last, and trains that are as crowded keep
+// their order
+func SortByOccupancy() StatusOption {
+	return func(o *statusOptions) {
+		o.sortByOccupancy = true
+	}
+}
+
+// MaxOccupancy only returns the trains that are at most as crowded as max.
+// Trains with an unknown occupancy are kept
+func MaxOccupancy(max Occupancy) StatusOption {
+	return func(o *statusOptions) {
+		o.maxOccupancy = max
+	}
+}
+
+// filterStatus applies the status options to the trains
+func filterStatus(trains []TrainStatus, opts []StatusOption) []TrainStatus {
+	o := &statusOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.maxOccupancy == OccupancyUnknown && !o.sortByOccupancy {
+		return trains
+	}
+
+	ret := make([]TrainStatus, 0, len(trains))
+	for _, t := range trains {
+		if o.maxOccupancy != OccupancyUnknown && t.Occupancy > o.maxOccupancy {
+			continue
+		}
+		ret = append(ret, t)
+	}
+	if o.sortByOccupancy {
+		sort.SliceStable(ret, func(i, j int) bool {
+			return occupancyRank(ret[i].Occupancy) < occupancyRank(ret[j].Occupancy)
+		})
+	}
+	return ret
+}
+
+// occupancyRank returns the sort position of an occupancy, where unknown is
+// after full
+func occupancyRank(o Occupancy) int {
+	if o == OccupancyUnknown {
+		return int(OccupancyFull) + 1
+	}
+	return int(o)
+}
@@ -0,0 +1,119 @@
+package caltrain
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs"
+)
+
+func TestParseSIRIOccupancy(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		exp   Occupancy
+	}{
+		{value: nil, exp: OccupancyUnknown},
+		{value: 3.0, exp: OccupancyUnknown},
+		{value: "unknown", exp: OccupancyUnknown},
+		{value: "empty", exp: OccupancyEmpty},
+		{value: "seatsAvailable", exp: OccupancyManySeats},
+		{value: "manySeatsAvailable", exp: OccupancyManySeats},
+		{value: "fewSeatsAvailable", exp: OccupancyFewSeats},
+		{value: "standingAvailable", exp: OccupancyStanding},
+		{value: "Full", exp: OccupancyFull},
+	}
+	for _, tt := range tests {
+		if o := parseSIRIOccupancy(tt.value); o != tt.exp {
+			t.Fatalf("Unexpected occupancy for %v. Expected %s, received %s", tt.value, tt.exp, o)
+		}
+	}
+}
+
+func TestGTFSOccupancy(t *testing.T) {
+	tests := []struct {
+		status *gtfs.VehiclePosition_OccupancyStatus
+		exp    Occupancy
+	}{
+		{status: nil, exp: OccupancyUnknown},
+		{status: gtfs.VehiclePosition_EMPTY.Enum(), exp: OccupancyEmpty},
+		{status: gtfs.VehiclePosition_MANY_SEATS_AVAILABLE.Enum(), exp: OccupancyManySeats},
+		{status: gtfs.VehiclePosition_FEW_SEATS_AVAILABLE.Enum(), exp: OccupancyFewSeats},
+		{status: gtfs.VehiclePosition_CRUSHED_STANDING_ROOM_ONLY.Enum(), exp: OccupancyStanding},
+		{status: gtfs.VehiclePosition_NOT_ACCEPTING_PASSENGERS.Enum(), exp: OccupancyFull},
+		{status: gtfs.VehiclePosition_NO_DATA_AVAILABLE.Enum(), exp: OccupancyUnknown},
+	}
+	for _, tt := range tests {
+		vp := &gtfs.VehiclePosition{OccupancyStatus: tt.status}
+		if o := gtfsOccupancy(vp); o != tt.exp {
+			t.Fatalf("Unexpected occupancy for %v. Expected %s, received %s", tt.status, tt.exp, o)
+		}
+	}
+}
+
+func TestFilterStatus(t *testing.T) {
+	trains := []TrainStatus{
+		{TrainNum: "101", Occupancy: OccupancyStanding},
+		{TrainNum: "102", Occupancy: OccupancyUnknown},
+		{TrainNum: "103", Occupancy: OccupancyManySeats},
+		{TrainNum: "104", Occupancy: OccupancyFull},
+		{TrainNum: "105", Occupancy: OccupancyManySeats},
+	}
+	tests := []struct {
+		name string
+		opts []StatusOption
+		exp  []string
+	}{
+		{name: "None", opts: nil, exp: []string{"101", "102", "103", "104", "105"}},
+		{name: "Sort", opts: []StatusOption{SortByOccupancy()}, exp: []string{"103", "105", "101", "104", "102"}},
+		{name: "Max", opts: []StatusOption{MaxOccupancy(OccupancyStanding)}, exp: []string{"101", "102", "103", "105"}},
+		{name: "SortMax", opts: []StatusOption{SortByOccupancy(), MaxOccupancy(OccupancyFewSeats)}, exp: []string{"103", "105", "102"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received := filterStatus(trains, tt.opts)
+			nums := make([]string, len(received))
+			for i, r := range received {
+				nums[i] = r.TrainNum
+			}
+			if len(nums) != len(tt.exp) {
+				t.Fatalf("Unexpected trains. Expected %v, received %v", tt.exp, nums)
+			}
+			for i := range nums {
+				if nums[i] != tt.exp[i] {
+					t.Fatalf("Unexpected trains. Expected %v, received %v", tt.exp, nums)
+				}
+			}
+		})
+	}
+}
+
+func TestGetStationStatusOccupancy(t *testing.T) {
+	ctx := context.Background()
+	c := newBulletClient(t)
+	data, err := ioutil.ReadFile("testdata/parseHillsdaleSouth.json")
+	if err != nil {
+		t.Fatalf("Could not read test data: %v", err)
+	}
+	// 436 is full and 804 has seats
+	data = bytes.Replace(data, []byte(`"Occupancy":null`), []byte(`"Occupancy":"full"`), 1)
+	data = bytes.Replace(data, []byte(`"Occupancy":null`), []byte(`"Occupancy":"seatsAvailable"`), 1)
+	c.APIClient = &apiClientMock{GetResult: data}
+
+	trains, _, err := c.GetStationStatus(ctx, StationHillsdale, South, SortByOccupancy())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trains) != 2 || trains[0].TrainNum != "804" || trains[0].Occupancy != OccupancyManySeats || trains[1].Occupancy != OccupancyFull {
+		t.Fatalf("Unexpected trains sorted by occupancy: %v", trains)
+	}
+
+	trains, _, err = c.GetStationStatus(ctx, StationHillsdale, South, MaxOccupancy(OccupancyStanding))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trains) != 1 || trains[0].TrainNum != "804" {
+		t.Fatalf("Unexpected trains with room: %v", trains)
+	}
+}
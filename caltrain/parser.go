@@ -4,33 +4,31 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 const (
 	northVal = 1
 )
 
-// parseDelays returns a slice of TrainsStatus for all trains that are delayed
-// more than the threshold argument
-func parseDelays(raw []byte, threshold time.Duration, lines []Line) ([]TrainStatus, error) {
-	trains, err := getTrains(raw, lines)
-	if err != nil {
-		return nil, err
-	}
+// filterDelays returns the trains that are delayed more than the threshold
+func filterDelays(trains []TrainStatus, threshold time.Duration) []TrainStatus {
 	delayedTrains := []TrainStatus{}
 	for _, t := range trains {
 		if t.Delay > threshold {
 			delayedTrains = append(delayedTrains, t)
 		}
 	}
-	return delayedTrains, nil
+	return delayedTrains
 }
 
 // getTrains unmarshals the json blob and returns a slice of trains
-func getTrains(raw []byte, lines []Line) ([]TrainStatus, error) {
+func (d *Dataset) getTrains(raw []byte) ([]TrainStatus, error) {
 	data := trainStatusJson{}
 	// trim some problematic characters: https://stackoverflow.com/questions/31398044/got-error-invalid-character-%C3%AF-looking-for-beginning-of-value-from-json-unmar
 	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
@@ -52,9 +50,11 @@ func getTrains(raw []byte, lines []Line) ([]TrainStatus, error) {
 		var line Line
 		var err error
 		if status.StopPointName != "" {
-			next, err = ParseStation(strings.Split(status.StopPointName, " Caltrain")[0])
+			// a stop name that can't be resolved only drops its own train
+			next, err = d.ParseStation(strings.Split(status.StopPointName, " Caltrain")[0])
 			if err != nil {
-				return ret, fmt.Errorf("could not get trains for %s: %w", status.StopPointName, err)
+				logrus.Warnf("Skipping train %s: %v", train.FramedVehicleJourneyRef.DatedVehicleJourneyRef, err)
+				continue
 			}
 		}
 		if train.DirectionRef != "" {
@@ -64,7 +64,7 @@ func getTrains(raw []byte, lines []Line) ([]TrainStatus, error) {
 			}
 		}
 		if train.LineRef != "" {
-			line, err = parseLine(train.LineRef, lines)
+			line, err = parseLine(train.LineRef, d.lines)
 			if err != nil {
 				return ret, fmt.Errorf("could not get trains: %w", err)
 			}
@@ -76,6 +76,10 @@ func getTrains(raw []byte, lines []Line) ([]TrainStatus, error) {
 			Delay:     delay,
 			Arrival:   arrival,
 			Line:      line,
+			Vehicle:   train.VehicleRef,
+			Location:  parseVehicleLocation(train.VehicleLocation.Latitude, train.VehicleLocation.Longitude),
+			Bearing:   parseBearing(train.Bearing),
+			Occupancy: parseSIRIOccupancy(train.Occupancy),
 		}
 		ret = append(ret, newTrain)
 	}
@@ -83,6 +87,89 @@ func getTrains(raw []byte, lines []Line) ([]TrainStatus, error) {
 	return ret, nil
 }
 
+// parseVehicleLocation returns the location of a vehicle from the strings in
+// the StopMonitoring json. It returns a zero Location if either is missing or
+// not a number
+func parseVehicleLocation(lat, lon string) Location {
+	la, err := strconv.ParseFloat(lat, 64)
+	if err != nil {
+		return Location{}
+	}
+	lo, err := strconv.ParseFloat(lon, 64)
+	if err != nil {
+		return Location{}
+	}
+	return Location{Latitude: la, Longitude: lo}
+}
+
+// parseBearing returns the bearing from the StopMonitoring json, which can be
+// null, a number or a string. It returns zero if it is not reported
+func parseBearing(v interface{}) float64 {
+	switch b := v.(type) {
+	case float64:
+		return b
+	case string:
+		f, err := strconv.ParseFloat(b, 64)
+		if err == nil {
+			return f
+		}
+	}
+	return 0
+}
+
+// stopVisit is the live prediction for a train at a single stop
+type stopVisit struct {
+	code              string    // stop code
+	aimedArrival      time.Time // scheduled arrival time
+	expectedArrival   time.Time // predicted arrival time
+	aimedDeparture    time.Time // scheduled departure time
+	expectedDeparture time.Time // predicted departure time
+	cancelled         bool      // true if the trip is cancelled, the other fields are not set
+}
+
+// delay returns the difference between the expected and aimed times
+func (v stopVisit) delay() time.Duration {
+	var d time.Duration
+	if !v.expectedArrival.IsZero() && !v.aimedArrival.IsZero() {
+		d = v.expectedArrival.Sub(v.aimedArrival)
+	} else if !v.expectedDeparture.IsZero() && !v.aimedDeparture.IsZero() {
+		d = v.expectedDeparture.Sub(v.aimedDeparture)
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// getStopVisits unmarshals the json blob and returns a map of train number to
+// the train's predictions for each stop in the blob
+func getStopVisits(raw []byte) (map[string][]stopVisit, error) {
+	data := trainStatusJson{}
+	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+
+	ret := make(map[string][]stopVisit)
+	for _, t := range data.ServiceDelivery.StopMonitoringDelivery.MonitoredStopVisit {
+		train := t.MonitoredVehicleJourney
+		call := train.MonitoredCall
+		code := call.StopPointRef
+		if code == "" {
+			code = t.MonitoringRef
+		}
+		num := train.FramedVehicleJourneyRef.DatedVehicleJourneyRef
+		ret[num] = append(ret[num], stopVisit{
+			code:              code,
+			aimedArrival:      call.AimedArrivalTime,
+			expectedArrival:   call.ExpectedArrivalTime,
+			aimedDeparture:    call.AimedDepartureTime,
+			expectedDeparture: call.ExpectedDepartureTime,
+		})
+	}
+	return ret, nil
+}
+
 // getDelay returns the time difference between the expected arrival time and
 // the aimed arrival time.
 func getDelay(status monitoredCall) (time.Duration, time.Time) {
@@ -109,12 +196,12 @@ func getDelay(status monitoredCall) (time.Duration, time.Time) {
 }
 
 // parseTimetable returns a slice of TimetableFrames from the given raw data
-func parseTimetable(raw []byte) ([]timetableFrame, map[string][]string, error) {
+func parseTimetable(raw []byte) ([]timetableFrame, map[string][]string, [][]string, error) {
 	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
 	data := timetableJson{}
 	services := make(map[string][]string)
 	if err := json.Unmarshal(raw, &data); err != nil {
-		return nil, nil, fmt.Errorf("failed to unmarshal: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to unmarshal: %w", err)
 	}
 	frames := data.Content.TimetableFrame
 	sframe := data.Content.ServiceCalendarFrame.DayTypes.DayType
@@ -122,32 +209,55 @@ func parseTimetable(raw []byte) ([]timetableFrame, map[string][]string, error) {
 		days := strings.Split(strings.TrimSpace(strings.ToLower(f.Properties.PropertyOfDay.DaysOfWeek)), " ")
 		services[f.ID] = days
 	}
-	return frames, services, nil
+
+	// the stop codes of each route in the PointsInSequence, turned so that
+	// they all run from north to south
+	routes := data.Content.ServiceFrame.Routes.Route
+	patterns := make([][]string, 0, len(routes))
+	for _, r := range routes {
+		points := r.PointsInSequence.PointOnRoute
+		codes := make([]string, len(points))
+		for i, p := range points {
+			codes[i] = p.PointRef.Ref
+		}
+		if getDirFromChar(r.DirectionRef.Ref) == North {
+			reverseCodes(codes)
+		}
+		patterns = append(patterns, codes)
+	}
+	return frames, services, patterns, nil
 }
 
-// parseStations returns a map of station name to station struct, parsing the
-// north and south codes
-func parseStations(raw []byte) (map[Station]*stationInfo, error) {
+// parseStopPoints returns the stop points of the stations, which are turned
+// into stations by buildStations
+func parseStopPoints(raw []byte) ([]scheduledStopPoint, error) {
 	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
 	data := stationJson{}
 	if err := json.Unmarshal(raw, &data); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal: %w", err)
 	}
+	return data.Contents.DataObjects.ScheduledStopPoint, nil
+}
 
+// buildStations returns a map of station name to station struct from a slice
+// of stop points. Each station is expected to have a north and a south stop,
+// and some have more than one stop for a direction. The platforms of those
+// are picked when the timetable is known, see pickPlatforms. Stops of unknown
+// stations are handled by policy, and registered in r
+func buildStations(stops []scheduledStopPoint, policy UnknownStationPolicy, r *stationRegistry) (map[Station]*stationInfo, error) {
 	ret := make(map[Station]*stationInfo)
 
 	// stops are indexed by id, not by station, so we have to generate a map
 	// that gets us halfway there first, then convert to our struct
-	stops := data.Contents.DataObjects.ScheduledStopPoint
 	for _, stop := range stops {
-		if stop.ID == "777403" || stop.ID == "777402" {
-			// Skip the Tamien and San Jose stations that are an outlier for some reason
-			continue
-		}
-
-		name, err := ParseStation(strings.TrimSuffix(stop.Name, " Caltrain Station"))
+		name, ok, err := resolveStation(strings.TrimSuffix(stop.Name, " Caltrain Station"), policy, r)
 		if err != nil {
 			return ret, fmt.Errorf("failed to parse station: %w", err)
+		} else if !ok {
+			continue
+		}
+		if _, err := isCodeNorth(stop.ID); err != nil {
+			return nil, fmt.Errorf("failed to parse stations: %w", err)
 		}
 		if st, ok := ret[name]; !ok {
 			// create a new station with location
@@ -159,27 +269,44 @@ func parseStations(raw []byte) (map[Station]*stationInfo, error) {
 			if err != nil {
 				return nil, fmt.Errorf("failed to parse location for %s: %w", name, err)
 			}
-			newStation := &stationInfo{
+			ret[name] = &stationInfo{
 				name:      name,
+				codes:     []string{stop.ID},
 				latitude:  lat,
 				longitude: lon,
 			}
-			if err := addDirectionToStation(newStation, stop.ID); err != nil {
-				return nil, fmt.Errorf("failed to parse stations: %w", err)
-			}
-			ret[name] = newStation
 		} else {
 			// the location difference between the north and south side is
 			// negligible and we can ignore it
-			if err := addDirectionToStation(st, stop.ID); err != nil {
-				return nil, fmt.Errorf("failed to parse stations: %w", err)
-			}
+			st.codes = append(st.codes, stop.ID)
 		}
 	}
 
+	for _, st := range ret {
+		sort.Strings(st.codes)
+		pickPlatforms(st, nil)
+	}
 	return ret, nil
 }
 
+// resolveStation returns the station of r for a stop name. A name that is not
+// registered is registered in r, skipped or an error depending on policy. It
+// returns false if the stop should be skipped
+func resolveStation(name string, policy UnknownStationPolicy, r *stationRegistry) (Station, bool, error) {
+	// stops are matched exactly, so a new stop is never mistaken for a
+	// station with a similar name
+	if st, ok := r.find(name); ok {
+		return st, true, nil
+	} else if policy == UnknownStationFail {
+		return 0, false, fmt.Errorf("%s is not a recognized station", name)
+	} else if policy == UnknownStationSkip {
+		logrus.Debugf("Skipping unknown station %s", name)
+		return 0, false, nil
+	}
+	logrus.Warnf("Registering unknown station %s", name)
+	return r.register(name), true, nil
+}
+
 // parseLines returns a slice of lines that are available
 func parseLines(raw []byte) ([]Line, error) {
 	raw = bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))
@@ -235,21 +362,11 @@ func parseHolidays(raw []byte) ([]time.Time, error) {
 	return ret, nil
 }
 
-// addDirectionToStation is a helper function to add the code to the proper
-// direction in the station struct
-func addDirectionToStation(s *stationInfo, id string) error {
-	if dir, err := isCodeNorth(id); err != nil {
-		return fmt.Errorf("failed to parse stations: %w", err)
-	} else if dir {
-		s.northCode = id
-	} else {
-		s.southCode = id
-	}
-	return nil
-}
-
 // isCodeNorth returns true if the code is for a north station
 func isCodeNorth(code string) (bool, error) {
+	if code == "" {
+		return false, fmt.Errorf("empty station code")
+	}
 	lastChar := code[len(code)-1:]
 	i, err := strconv.Atoi(lastChar)
 	if err != nil {
@@ -22,8 +22,8 @@ func TestParseDelays(t *testing.T) {
 			name: "DelayData1",
 			data: "testdata/parseDelayData1.json",
 			expected: []TrainStatus{
-				{TrainNum: "258", NextStop: StationSunnyvale, Direction: South, Delay: delay1, Arrival: time.Date(2019, time.December, 25, 0, 58, 10, 0, time.UTC), Line: Line{"Limited", "Limited"}},
-				{TrainNum: "263", NextStop: StationPaloAlto, Direction: North, Delay: delay2, Arrival: time.Date(2019, time.December, 25, 0, 50, 01, 0, time.UTC), Line: Line{"Limited", "Limited"}},
+				{TrainNum: "258", NextStop: StationSunnyvale, Direction: South, Delay: delay1, Arrival: time.Date(2019, time.December, 25, 0, 58, 10, 0, time.UTC), Line: Line{"Limited", "Limited"}, Vehicle: "258", Location: Location{37.3785286, -122.030609}},
+				{TrainNum: "263", NextStop: StationPaloAlto, Direction: North, Delay: delay2, Arrival: time.Date(2019, time.December, 25, 0, 50, 01, 0, time.UTC), Line: Line{"Limited", "Limited"}, Vehicle: "263", Location: Location{37.3787003, -122.030861}},
 			},
 			err: nil,
 		},
@@ -45,7 +45,8 @@ func TestParseDelays(t *testing.T) {
 				t.Fatalf("Could not read test data for %s: %v", tt.name, err)
 			}
 
-			delays, err := parseDelays(data, defaultDelayThreshold, allLines)
+			trains, err := newLinesDataset(allLines).getTrains(data)
+			delays := filterDelays(trains, defaultDelayThreshold)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get trains for %s: %v", tt.name, err)
 			} else if err == nil && tt.err != nil {
@@ -70,8 +71,8 @@ func TestGetTrains(t *testing.T) {
 			name: "HillsdaleSouth",
 			data: "testdata/parseHillsdaleSouth.json",
 			expected: []TrainStatus{
-				{TrainNum: "436", NextStop: StationHillsdale, Direction: South, Delay: 0, Arrival: time.Date(2019, time.December, 30, 3, 6, 57, 0, time.UTC), Line: Line{"Local", "Local"}},
-				{TrainNum: "804", NextStop: StationHillsdale, Direction: South, Delay: 0, Arrival: time.Date(2019, time.December, 30, 3, 59, 45, 0, time.UTC), Line: Line{"Bullet", "Bullet"}},
+				{TrainNum: "436", NextStop: StationHillsdale, Direction: South, Delay: 0, Arrival: time.Date(2019, time.December, 30, 3, 6, 57, 0, time.UTC), Line: Line{"Local", "Local"}, Vehicle: "436", Location: Location{37.7067795, -122.401932}},
+				{TrainNum: "804", NextStop: StationHillsdale, Direction: South, Delay: 0, Arrival: time.Date(2019, time.December, 30, 3, 59, 45, 0, time.UTC), Line: Line{"Bullet", "Bullet"}, Vehicle: "804", Location: Location{37.7752991, -122.396446}},
 			},
 			err: nil,
 		},
@@ -79,7 +80,7 @@ func TestGetTrains(t *testing.T) {
 			name: "HillsdaleNorth",
 			data: "testdata/parseHillsdaleNorth.json",
 			expected: []TrainStatus{
-				{TrainNum: "437", NextStop: StationHillsdale, Direction: North, Delay: 0, Arrival: time.Date(2019, time.December, 30, 4, 4, 45, 0, time.UTC), Line: Line{"Local", "Local"}},
+				{TrainNum: "437", NextStop: StationHillsdale, Direction: North, Delay: 0, Arrival: time.Date(2019, time.December, 30, 4, 4, 45, 0, time.UTC), Line: Line{"Local", "Local"}, Vehicle: "437", Location: Location{37.3290596, -121.903313}},
 			},
 			err: nil,
 		},
@@ -101,7 +102,7 @@ func TestGetTrains(t *testing.T) {
 				t.Fatalf("Could not read test data for %s: %v", tt.name, err)
 			}
 
-			trains, err := getTrains(data, allLines)
+			trains, err := newLinesDataset(allLines).getTrains(data)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get trains for %s: %v", tt.name, err)
 			} else if err == nil && tt.err != nil {
@@ -148,7 +149,7 @@ func TestParseTimetable(t *testing.T) {
 				t.Fatalf("Could not read test data for %s: %v", tt.name, err)
 			}
 
-			_, _, err = parseTimetable(data)
+			_, _, _, err = parseTimetable(data)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get timetable for %s: %v", tt.name, err)
 			} else if err == nil && tt.err != nil {
@@ -203,7 +204,11 @@ func TestParseStations(t *testing.T) {
 		StationStanford:     {name: StationStanford, northCode: "2537740", southCode: "2537744"},
 	}
 
-	s, err := parseStations(data)
+	stops, err := parseStopPoints(data)
+	if err != nil {
+		t.Fatalf("failed to get stops: %v", err)
+	}
+	s, err := buildStations(stops, UnknownStationFail, newStationRegistry())
 	if err != nil {
 		t.Fatalf("failed to get stations: %v", err)
 	}
@@ -307,6 +312,13 @@ func TestParseHolidays(t *testing.T) {
 	}
 }
 
+// newLinesDataset returns an empty Dataset with the given lines
+func newLinesDataset(lines []Line) *Dataset {
+	d := newDataset()
+	d.lines = lines
+	return d
+}
+
 // assertTrainStatusEqual compares two TrainStatus slices for the same elements
 func assertTrainStatusEqual(exp, test []TrainStatus) bool {
 	if len(exp) != len(test) {
@@ -323,3 +335,27 @@ func assertTrainStatusEqual(exp, test []TrainStatus) bool {
 	}
 	return reflect.DeepEqual(m1, m2)
 }
+
+func TestIsCodeNorth(t *testing.T) {
+	tests := []struct {
+		code  string
+		north bool
+		err   bool
+	}{
+		{code: "70011", north: true},
+		{code: "70012", north: false},
+		{code: "7001a", err: true},
+		{code: "", err: true},
+	}
+	for _, tt := range tests {
+		north, err := isCodeNorth(tt.code)
+		if err != nil && !tt.err {
+			t.Fatalf("Unexpected error: %v", err)
+		} else if err == nil && tt.err {
+			t.Fatalf("isCodeNorth improperly succeeded for %q", tt.code)
+		}
+		if north != tt.north {
+			t.Fatalf("Unexpected direction for %q. Expected north %t, received %t", tt.code, tt.north, north)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// planner.go contains the trip planner. It runs a RAPTOR style search where
+// every journey in the timetable is its own route, which is small enough for
+// the Caltrain network
+
+const (
+	defaultMinTransferTime = 2 * time.Minute
+	defaultMaxTransfers    = 2
+	defaultMaxResults      = 3
+)
+
+// timetableDay is the date that the timetable times are parsed on. Times on
+// the following day have a DaysOffset
+var timetableDay = time.Date(0, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// PlanOptions configures PlanTrips. Passing nil uses a two minute minimum
+// transfer time, at most two transfers, and three results
+type PlanOptions struct {
+	MinTransferTime time.Duration // minimum time needed to change trains
+	MaxTransfers    int           // maximum number of changes. 0 only allows direct trains
+	MaxResults      int           // maximum number of itineraries returned
+}
+
+// planTrip is a journey in the timetable converted to a Route
+type planTrip struct {
+	route *Route
+}
+
+// planLabel is the earliest arrival at a station in a round of the search
+type planLabel struct {
+	arrival time.Duration // time since the start of the service day
+	trip    *planTrip     // nil for the source station
+	board   int           // index of the boarding stop in trip
+	alight  int           // index of the alighting stop in trip
+	parent  *planLabel    // label that the trip was boarded from
+}
+
+// PlanTrips returns itineraries from src to dst that depart after the given
+// time, ranked by arrival time, then number of transfers, then departure
+// time. Itineraries can include transfers between trains, so a Local can be
+// taken to a station served by a faster Bullet. It uses the cached timetable
+// and does not make an API call. The service day is the date of departAfter in
+// pacific time, and holidays use the Sunday schedule
+func (c *CaltrainClient) PlanTrips(ctx context.Context, src, dst Station, departAfter time.Time, opts *PlanOptions) ([]Itinerary, error) {
+	logrus.Debugf("Planning trips from '%s' to '%s' after %s", src.String(), dst.String(), departAfter)
+	if src == dst {
+		return nil, fmt.Errorf("The stations are the same: %s to %s", src, dst)
+	}
+	o := PlanOptions{
+		MinTransferTime: defaultMinTransferTime,
+		MaxTransfers:    defaultMaxTransfers,
+		MaxResults:      defaultMaxResults,
+	}
+	if opts != nil {
+		o = *opts
+		if o.MaxTransfers < 0 {
+			o.MaxTransfers = 0
+		}
+		if o.MaxResults <= 0 {
+			o.MaxResults = defaultMaxResults
+		}
+	}
+
+	d := c.dataset()
+	for _, st := range []Station{src, dst} {
+		if _, err := d.getStationCode(st, North); err != nil {
+			return nil, fmt.Errorf("failed to plan trips: %w", err)
+		}
+	}
+
+	local := departAfter.In(c.tz)
+	date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, c.tz)
+	weekday := date.Weekday()
+	if d.isHoliday(date) {
+		weekday = time.Sunday
+	}
+
+	trips, err := d.getPlanTrips(weekday)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan trips: %w", err)
+	}
+
+	// each search returns the fastest itineraries for a departure time, so
+	// search again after each departure until there are enough results
+	results := []Itinerary{}
+	seen := make(map[string]struct{})
+	start := serviceOffset(local, date)
+	for len(results) < o.MaxResults {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		found := planRaptor(trips, src, dst, start, o)
+		if len(found) == 0 {
+			break
+		}
+		next := found[0].legs[0].depart
+		for _, f := range found {
+			if f.legs[0].depart < next {
+				next = f.legs[0].depart
+			}
+			key := f.key()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			results = append(results, f.toItinerary(date))
+		}
+		start = next + time.Second
+	}
+
+	results = removeDominated(results)
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if !a.Arrival.Equal(b.Arrival) {
+			return a.Arrival.Before(b.Arrival)
+		}
+		if len(a.Transfers) != len(b.Transfers) {
+			return len(a.Transfers) < len(b.Transfers)
+		}
+		return a.Departure.After(b.Departure)
+	})
+	if len(results) > o.MaxResults {
+		results = results[:o.MaxResults]
+	}
+	return results, nil
+}
+
+// getPlanTrips returns every journey for the weekday as a planTrip
+func (d *Dataset) getPlanTrips(day time.Weekday) ([]*planTrip, error) {
+	journeys := d.getJourneysForDay(day)
+	trips := make([]*planTrip, 0, len(journeys))
+	for _, journey := range journeys {
+		r, err := d.journeyToRoute(journey)
+		if err != nil {
+			return nil, err
+		}
+		trips = append(trips, &planTrip{route: r})
+	}
+	return trips, nil
+}
+
+// planLeg is a leg of a planned path in time since the start of the day
+type planLeg struct {
+	trip   *planTrip
+	board  int
+	alight int
+	depart time.Duration
+	arrive time.Duration
+}
+
+// planPath is a path found by planRaptor
+type planPath struct {
+	legs []planLeg
+}
+
+// key uniquely identifies the path by the trains and boarding stations
+func (p planPath) key() string {
+	parts := make([]string, len(p.legs))
+	for i, l := range p.legs {
+		parts[i] = fmt.Sprintf("%s@%d", l.trip.route.TrainNum, l.trip.route.Stops[l.board].Station)
+	}
+	return strings.Join(parts, ",")
+}
+
+// toItinerary converts the path into an Itinerary on the given service date
+func (p planPath) toItinerary(date time.Time) Itinerary {
+	it := Itinerary{
+		Legs:      make([]Leg, len(p.legs)),
+		Transfers: []Transfer{},
+	}
+	for i, l := range p.legs {
+		r := l.trip.route
+		it.Legs[i] = Leg{
+			TrainNum:  r.TrainNum,
+			Direction: r.Direction,
+			Line:      r.Line,
+			From:      r.Stops[l.board].Station,
+			To:        r.Stops[l.alight].Station,
+			Departure: atServiceDate(date, timetableDay.Add(l.depart)),
+			Arrival:   atServiceDate(date, timetableDay.Add(l.arrive)),
+		}
+		if i > 0 {
+			wait := l.depart - p.legs[i-1].arrive
+			it.Transfers = append(it.Transfers, Transfer{Station: it.Legs[i].From, Wait: wait})
+			if i == 1 || wait < it.MinTransferTime {
+				it.MinTransferTime = wait
+			}
+		}
+	}
+	it.Departure = it.Legs[0].Departure
+	it.Arrival = it.Legs[len(it.Legs)-1].Arrival
+	it.Duration = it.Arrival.Sub(it.Departure)
+	return it
+}
+
+// planRaptor runs a round based search from src at the start time. Round k
+// finds the earliest arrivals using k trains, so each round that improves the
+// arrival at dst adds a path with one more transfer
+func planRaptor(trips []*planTrip, src, dst Station, start time.Duration, o PlanOptions) []planPath {
+	best := map[Station]*planLabel{src: {arrival: start}}
+	prev := map[Station]*planLabel{src: best[src]}
+	paths := []planPath{}
+
+	for round := 1; round <= o.MaxTransfers+1; round++ {
+		improved := make(map[Station]*planLabel)
+		for _, trip := range trips {
+			var from *planLabel
+			board := -1
+			for i, stop := range trip.route.Stops {
+				if board >= 0 {
+					arr := stop.Arrival.Sub(timetableDay)
+					if b, ok := best[stop.Station]; !ok || arr < b.arrival {
+						l := &planLabel{arrival: arr, trip: trip, board: board, alight: i, parent: from}
+						best[stop.Station] = l
+						improved[stop.Station] = l
+					}
+				}
+				if board >= 0 {
+					continue
+				}
+				// board at the first stop that was reached in the last round
+				// with enough time to make the connection
+				l, ok := prev[stop.Station]
+				if !ok {
+					continue
+				}
+				ready := l.arrival
+				if l.trip != nil {
+					ready += o.MinTransferTime
+				}
+				if ready <= stop.Departure.Sub(timetableDay) {
+					board = i
+					from = l
+				}
+			}
+		}
+		if len(improved) == 0 {
+			break
+		}
+		if l, ok := improved[dst]; ok {
+			paths = append(paths, l.path())
+		}
+		// stations reached in earlier rounds can still be boarded from
+		for st, l := range improved {
+			prev[st] = l
+		}
+	}
+	return paths
+}
+
+// path follows the parents of the label back to the source
+func (l *planLabel) path() planPath {
+	legs := []planLeg{}
+	for cur := l; cur != nil && cur.trip != nil; cur = cur.parent {
+		stops := cur.trip.route.Stops
+		legs = append([]planLeg{{
+			trip:   cur.trip,
+			board:  cur.board,
+			alight: cur.alight,
+			depart: stops[cur.board].Departure.Sub(timetableDay),
+			arrive: stops[cur.alight].Arrival.Sub(timetableDay),
+		}}, legs...)
+	}
+	return planPath{legs: legs}
+}
+
+// removeDominated removes any itinerary that departs earlier, arrives later,
+// and has more transfers than another itinerary
+func removeDominated(its []Itinerary) []Itinerary {
+	ret := []Itinerary{}
+	for i, a := range its {
+		dominated := false
+		for j, b := range its {
+			if i == j {
+				continue
+			}
+			if !b.Departure.Before(a.Departure) && !b.Arrival.After(a.Arrival) && len(b.Transfers) <= len(a.Transfers) &&
+				(b.Departure.After(a.Departure) || b.Arrival.Before(a.Arrival) || len(b.Transfers) < len(a.Transfers)) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,150 @@
+package caltrain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPlanTrips(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	departAfter := time.Date(2019, time.November, 22, 5, 50, 0, 0, c.tz)
+	at := func(h, m int) time.Time {
+		return time.Date(2019, time.November, 22, h, m, 0, 0, c.tz)
+	}
+
+	tests := []struct {
+		name      string
+		opts      *PlanOptions
+		trains    [][]string // train numbers of each itinerary
+		transfers []Station  // transfer station of the first itinerary
+		arrival   time.Time  // arrival of the first itinerary
+	}{
+		{
+			name:      "Default",
+			opts:      nil,
+			trains:    [][]string{{"102", "502"}, {"102"}, {"104"}},
+			transfers: []Station{StationHillsdale},
+			arrival:   at(7, 0),
+		},
+		{
+			name:    "Long Transfer",
+			opts:    &PlanOptions{MinTransferTime: 5 * time.Minute, MaxTransfers: 2},
+			trains:  [][]string{{"102"}, {"104"}},
+			arrival: at(7, 10),
+		},
+		{
+			name:    "Direct Only",
+			opts:    &PlanOptions{MinTransferTime: time.Minute, MaxTransfers: 0, MaxResults: 1},
+			trains:  [][]string{{"102"}},
+			arrival: at(7, 10),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			its, err := c.PlanTrips(ctx, StationHaywardPark, StationSanJose, departAfter, tt.opts)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(its) != len(tt.trains) {
+				t.Fatalf("Incorrect number of itineraries. Expected %d, received %d: %v", len(tt.trains), len(its), its)
+			}
+			for i, it := range its {
+				if len(it.Legs) != len(tt.trains[i]) {
+					t.Fatalf("Incorrect legs for itinerary %d. Expected %v, received %v", i, tt.trains[i], it.Legs)
+				}
+				for j, leg := range it.Legs {
+					if leg.TrainNum != tt.trains[i][j] {
+						t.Fatalf("Incorrect legs for itinerary %d. Expected %v, received %v", i, tt.trains[i], it.Legs)
+					}
+				}
+				if it.Duration != it.Arrival.Sub(it.Departure) {
+					t.Fatalf("Incorrect duration %s for itinerary %d", it.Duration, i)
+				}
+			}
+
+			first := its[0]
+			if !first.Arrival.Equal(tt.arrival) {
+				t.Fatalf("Incorrect arrival. Expected %s, received %s", tt.arrival, first.Arrival)
+			}
+			if len(first.Transfers) != len(tt.transfers) {
+				t.Fatalf("Incorrect transfers. Expected %v, received %v", tt.transfers, first.Transfers)
+			}
+			for i, tr := range first.Transfers {
+				if tr.Station != tt.transfers[i] {
+					t.Fatalf("Incorrect transfers. Expected %v, received %v", tt.transfers, first.Transfers)
+				}
+			}
+		})
+	}
+
+	// verify the details of the transfer
+	its, err := c.PlanTrips(ctx, StationHaywardPark, StationSanJose, departAfter, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	it := its[0]
+	if it.MinTransferTime != 3*time.Minute || it.Transfers[0].Wait != 3*time.Minute {
+		t.Fatalf("Incorrect transfer time. Expected %s, received %s", 3*time.Minute, it.MinTransferTime)
+	}
+	if !it.Departure.Equal(at(6, 32)) || it.Duration != 28*time.Minute {
+		t.Fatalf("Unexpected itinerary: %v", it)
+	}
+	if it.Legs[1].Line.Name != "Bullet" || it.Legs[1].From != StationHillsdale || it.Legs[1].To != StationSanJose {
+		t.Fatalf("Unexpected second leg: %v", it.Legs[1])
+	}
+}
+
+func TestPlanTripsDST(t *testing.T) {
+	c := newGTFSClient(t)
+	tests := []struct {
+		name string
+		date time.Time
+	}{
+		{name: "SpringForward", date: time.Date(2026, time.March, 8, 0, 0, 0, 0, c.tz)},
+		{name: "FallBack", date: time.Date(2026, time.November, 1, 0, 0, 0, 0, c.tz)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := func(h, m int) time.Time {
+				return time.Date(tt.date.Year(), tt.date.Month(), tt.date.Day(), h, m, 0, 0, c.tz)
+			}
+			// the weekend train leaves Hayward Park at 8:32 on the wall clock
+			its, err := c.PlanTrips(context.Background(), StationHaywardPark, StationSanJose, at(8, 10), &PlanOptions{MaxResults: 1})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(its) != 1 || its[0].Legs[0].TrainNum != "422" {
+				t.Fatalf("Unexpected itineraries: %v", its)
+			}
+			if it := its[0]; !it.Departure.Equal(at(8, 32)) || !it.Arrival.Equal(at(9, 10)) {
+				t.Fatalf("Unexpected times. Expected %s to %s, received %s to %s", at(8, 32), at(9, 10), it.Departure, it.Arrival)
+			}
+		})
+	}
+}
+
+func TestPlanTripsErrors(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	departAfter := time.Date(2019, time.November, 22, 5, 50, 0, 0, c.tz)
+
+	if _, err := c.PlanTrips(ctx, StationHillsdale, StationHillsdale, departAfter, nil); err == nil {
+		t.Fatalf("PlanTrips improperly succeeded for the same station")
+	}
+	if _, err := c.PlanTrips(ctx, StationHillsdale, 999, departAfter, nil); err == nil {
+		t.Fatalf("PlanTrips improperly succeeded for an unknown station")
+	}
+
+	// no trains leave after the last train of the day
+	late := time.Date(2019, time.November, 22, 23, 59, 0, 0, c.tz)
+	its, err := c.PlanTrips(ctx, StationHillsdale, StationSanJose, late, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(its) != 0 {
+		t.Fatalf("Unexpected itineraries: %v", its)
+	}
+}
@@ -0,0 +1,153 @@
+package caltrain
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// positions.go contains the helpers that locate the trains for a map. Trains
+// that report a GPS location are placed there, and the others are placed
+// between their previous and next stations from the schedule and their delay
+
+// TrainPosition is the location of a train in the live feed
+type TrainPosition struct {
+	TrainNum  string        // Train reference number
+	Direction Direction     // Direction the train is travelling: North or South
+	Line      Line          // bullet, limited, etc.
+	NextStop  Station       // Name of the station that the train will stop at next
+	Delay     time.Duration // Amount of time behind schedule
+	Location  Location      // GPS or estimated location of the train
+	Bearing   float64       // Heading in degrees clockwise from North, zero if not reported
+	Estimated bool          // true if the location is estimated from the schedule
+}
+
+// GetTrainPositions makes an API call and returns the location of every
+// train in the live feed, sorted by train number. Trains without a GPS
+// location are placed between their previous and next stations according to
+// the schedule and their delay, and trains that can't be placed that way are
+// left out. If the call fails and a stale status is cached, the positions
+// are estimated from it and returned along with the error
+func (c *CaltrainClient) GetTrainPositions(ctx context.Context) ([]TrainPosition, time.Time, error) {
+	logrus.Debug("Getting train positions...")
+	trains, t, err := c.getLiveTrains(ctx)
+	if trains == nil {
+		return nil, t, err
+	}
+
+	now := c.clock.Now()
+	d := c.dataset()
+	ret := []TrainPosition{}
+	for _, train := range nextStatuses(trains) {
+		p := TrainPosition{
+			TrainNum:  train.TrainNum,
+			Direction: train.Direction,
+			Line:      train.Line,
+			NextStop:  train.NextStop,
+			Delay:     train.Delay,
+			Location:  train.Location,
+			Bearing:   train.Bearing,
+		}
+		if !train.HasLocation() {
+			loc, eerr := d.estimateLocation(train, now, c.tz)
+			if eerr != nil {
+				logrus.Debugf("Could not place train %s: %v", train.TrainNum, eerr)
+				continue
+			}
+			p.Location = loc
+			p.Estimated = true
+		}
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].TrainNum < ret[j].TrainNum
+	})
+	return ret, t, err
+}
+
+// nextStatuses returns the status of each train for its next stop. The feed
+// can have a status for each upcoming stop of a train, the earliest one is
+// the train's next stop
+func nextStatuses(trains []TrainStatus) []TrainStatus {
+	index := make(map[string]int)
+	ret := []TrainStatus{}
+	for _, t := range trains {
+		i, ok := index[t.TrainNum]
+		if !ok {
+			index[t.TrainNum] = len(ret)
+			ret = append(ret, t)
+			continue
+		}
+		cur := ret[i]
+		if cur.Arrival.IsZero() || (!t.Arrival.IsZero() && t.Arrival.Before(cur.Arrival)) {
+			// a location reported with any of the statuses is kept
+			if !t.HasLocation() {
+				t.Location, t.Bearing = cur.Location, cur.Bearing
+			}
+			ret[i] = t
+		}
+	}
+	return ret
+}
+
+// estimateLocation returns the location of a train from its route, the
+// expected arrival at its next stop and its delay. The train is assumed to
+// travel in a straight line at a constant speed from the previous stop, which
+// is close enough for a map
+func (d *Dataset) estimateLocation(train TrainStatus, now time.Time, tz *time.Location) (Location, error) {
+	journey, err := d.getRouteForTrain(train.TrainNum)
+	if err != nil {
+		return Location{}, err
+	}
+	route, err := d.journeyToRoute(journey)
+	if err != nil {
+		return Location{}, err
+	}
+	next := -1
+	for i, stop := range route.Stops {
+		if stop.Station == train.NextStop {
+			next = i
+			break
+		}
+	}
+	if next < 0 {
+		return Location{}, fmt.Errorf("%s is not on the route", train.NextStop)
+	}
+	to, err := d.StationLocation(train.NextStop)
+	if err != nil {
+		return Location{}, err
+	}
+	if next == 0 {
+		// the train is waiting at its first stop
+		return to, nil
+	}
+	from, err := d.StationLocation(route.Stops[next-1].Station)
+	if err != nil {
+		return Location{}, err
+	}
+
+	stop := route.Stops[next]
+	arrival := train.Arrival
+	var date time.Time
+	if arrival.IsZero() {
+		date = serviceDate(now.In(tz))
+		arrival = atServiceDate(date, stop.Arrival).Add(train.Delay)
+	} else {
+		// trains that run past midnight started on the day before
+		date = serviceDate(arrival.In(tz)).AddDate(0, 0, timetableDay.YearDay()-stop.Arrival.YearDay())
+	}
+	departure := atServiceDate(date, route.Stops[next-1].Departure).Add(train.Delay)
+
+	frac := 1.0
+	if total := arrival.Sub(departure); total > 0 {
+		frac = float64(now.Sub(departure)) / float64(total)
+	}
+	frac = max(0, min(1, frac))
+	return Location{
+		Latitude:  from.Latitude + (to.Latitude-from.Latitude)*frac,
+		Longitude: from.Longitude + (to.Longitude-from.Longitude)*frac,
+	}, nil
+}
@@ -0,0 +1,125 @@
+package caltrain
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestEstimateLocation(t *testing.T) {
+	c := newBulletClient(t)
+	d := c.Dataset()
+	route, err := c.GetTrainRoute("801")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(route.Stops) < 3 {
+		t.Fatalf("Route 801 has too few stops: %v", route.Stops)
+	}
+	prev, next := route.Stops[1], route.Stops[2]
+	from, _ := d.StationLocation(prev.Station)
+	to, _ := d.StationLocation(next.Station)
+	date := time.Date(2019, time.December, 30, 0, 0, 0, 0, c.tz)
+	departure := atServiceDate(date, prev.Departure)
+	arrival := atServiceDate(date, next.Arrival)
+	half := departure.Add(arrival.Sub(departure) / 2)
+	delay := 4 * time.Minute
+	mid := Location{Latitude: (from.Latitude + to.Latitude) / 2, Longitude: (from.Longitude + to.Longitude) / 2}
+
+	tests := []struct {
+		name  string
+		train TrainStatus
+		now   time.Time
+		exp   Location
+	}{
+		{
+			name:  "Halfway",
+			train: TrainStatus{TrainNum: "801", NextStop: next.Station},
+			now:   half,
+			exp:   mid,
+		},
+		{
+			name:  "HalfwayDelayed",
+			train: TrainStatus{TrainNum: "801", NextStop: next.Station, Delay: delay, Arrival: arrival.Add(delay).UTC()},
+			now:   half.Add(delay),
+			exp:   mid,
+		},
+		{
+			name:  "NotDeparted",
+			train: TrainStatus{TrainNum: "801", NextStop: next.Station, Delay: delay},
+			now:   departure,
+			exp:   from,
+		},
+		{
+			name:  "Late",
+			train: TrainStatus{TrainNum: "801", NextStop: next.Station},
+			now:   arrival.Add(time.Minute),
+			exp:   to,
+		},
+		{
+			name:  "FirstStop",
+			train: TrainStatus{TrainNum: "801", NextStop: route.Stops[0].Station},
+			now:   departure,
+			exp:   func() Location { l, _ := d.StationLocation(route.Stops[0].Station); return l }(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := d.estimateLocation(tt.train, tt.now, c.tz)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if math.Abs(loc.Latitude-tt.exp.Latitude) > 1e-9 || math.Abs(loc.Longitude-tt.exp.Longitude) > 1e-9 {
+				t.Fatalf("Unexpected location. Expected %v, received %v", tt.exp, loc)
+			}
+		})
+	}
+
+	if _, err := d.estimateLocation(TrainStatus{TrainNum: "999", NextStop: next.Station}, half, c.tz); err == nil {
+		t.Fatalf("estimateLocation improperly succeeded for a train that is not in the timetable")
+	}
+}
+
+func TestGetTrainPositions(t *testing.T) {
+	c := newBulletClient(t)
+	mock := clock.NewMock()
+	mock.Set(time.Date(2019, time.December, 30, 3, 0, 0, 0, time.UTC))
+	c.clock = mock
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/parseHillsdaleSouth.json"}
+
+	positions, _, err := c.GetTrainPositions(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := []TrainPosition{
+		{TrainNum: "436", Direction: South, Line: Line{"Local", "Local"}, NextStop: StationHillsdale, Location: Location{37.7067795, -122.401932}},
+		{TrainNum: "804", Direction: South, Line: Line{"Bullet", "Bullet"}, NextStop: StationHillsdale, Location: Location{37.7752991, -122.396446}},
+	}
+	if len(positions) != len(exp) {
+		t.Fatalf("Unexpected positions\nexpected: %v\nreceived: %v", exp, positions)
+	}
+	for i := range exp {
+		if positions[i] != exp[i] {
+			t.Fatalf("Unexpected positions\nexpected: %v\nreceived: %v", exp, positions)
+		}
+	}
+}
+
+func TestNextStatuses(t *testing.T) {
+	early := time.Date(2019, time.December, 30, 3, 0, 0, 0, time.UTC)
+	trains := []TrainStatus{
+		{TrainNum: "101", NextStop: StationBelmont, Arrival: early.Add(10 * time.Minute), Location: Location{37.5, -122.3}},
+		{TrainNum: "102", NextStop: StationMillbrae, Arrival: early},
+		{TrainNum: "101", NextStop: StationHillsdale, Arrival: early.Add(5 * time.Minute)},
+	}
+	exp := []TrainStatus{
+		{TrainNum: "101", NextStop: StationHillsdale, Arrival: early.Add(5 * time.Minute), Location: Location{37.5, -122.3}},
+		{TrainNum: "102", NextStop: StationMillbrae, Arrival: early},
+	}
+	if received := nextStatuses(trains); !assertTrainStatusEqual(exp, received) {
+		t.Fatalf("Unexpected statuses\nexpected: %v\nreceived: %v", exp, received)
+	}
+}
@@ -0,0 +1,144 @@
+package caltrain
+
+import (
+	"sort"
+	"time"
+)
+
+// A QueryOption filters or limits the routes returned by a route query. Times
+// are compared against the timetable in the time zone of the query date, and
+// can be on the day after the query date to match trains that run past
+// midnight
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	departAfter time.Time
+	arriveBy    time.Time
+	windowStart time.Time
+	windowEnd   time.Time
+	limit       int
+	alerts      bool
+}
+
+// DepartAfter only returns routes that depart the source station at or after t
+func DepartAfter(t time.Time) QueryOption {
+	return func(o *queryOptions) {
+		o.departAfter = t
+	}
+}
+
+// ArriveBy only returns routes that arrive at the destination station at or
+// before t
+func ArriveBy(t time.Time) QueryOption {
+	return func(o *queryOptions) {
+		o.arriveBy = t
+	}
+}
+
+// Within only returns routes that depart the source station between start
+// and end, inclusive
+func Within(start, end time.Time) QueryOption {
+	return func(o *queryOptions) {
+		o.windowStart = start
+		o.windowEnd = end
+	}
+}
+
+// NextN limits the results to the first n routes by departure time
+func NextN(n int) QueryOption {
+	return func(o *queryOptions) {
+		o.limit = n
+	}
+}
+
+// WithAlerts sets the Alerts of the routes to the active service alerts that
+// affect them. It makes an API call with the context of the query, or a
+// background context for GetStationTimetable
+func WithAlerts() QueryOption {
+	return func(o *queryOptions) {
+		o.alerts = true
+	}
+}
+
+// routeTimes is a route with the departure time from its source station and
+// the arrival time at its destination station, as time since the start of
+// the service day
+type routeTimes struct {
+	route     *Route
+	departure time.Duration
+	arrival   time.Duration
+}
+
+// filterRoutes applies the query options to the routes and sorts them by
+// departure time. The source of each route is the first of its stops at one
+// of the stations and the destination is the last
+func filterRoutes(routes []*Route, date time.Time, opts []QueryOption, stations ...Station) []*Route {
+	o := &queryOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	set := make(map[Station]struct{}, len(stations))
+	for _, st := range stations {
+		set[st] = struct{}{}
+	}
+
+	times := make([]routeTimes, 0, len(routes))
+	for _, r := range routes {
+		rt := routeTimes{route: r}
+		found := false
+		for _, stop := range r.Stops {
+			if _, ok := set[stop.Station]; !ok {
+				continue
+			}
+			if !found {
+				rt.departure = stop.Departure.Sub(timetableDay)
+				found = true
+			}
+			rt.arrival = stop.Arrival.Sub(timetableDay)
+		}
+		if !found {
+			continue
+		}
+		if !o.departAfter.IsZero() && rt.departure < serviceOffset(o.departAfter, date) {
+			continue
+		}
+		if !o.arriveBy.IsZero() && rt.arrival > serviceOffset(o.arriveBy, date) {
+			continue
+		}
+		if !o.windowStart.IsZero() && rt.departure < serviceOffset(o.windowStart, date) {
+			continue
+		}
+		if !o.windowEnd.IsZero() && rt.departure > serviceOffset(o.windowEnd, date) {
+			continue
+		}
+		times = append(times, rt)
+	}
+
+	sort.SliceStable(times, func(i, j int) bool {
+		if times[i].departure != times[j].departure {
+			return times[i].departure < times[j].departure
+		}
+		return times[i].route.TrainNum < times[j].route.TrainNum
+	})
+	if o.limit > 0 && len(times) > o.limit {
+		times = times[:o.limit]
+	}
+
+	ret := make([]*Route, len(times))
+	for i, rt := range times {
+		ret[i] = rt.route
+	}
+	return ret
+}
+
+// serviceOffset returns the wall clock time of t in the time zone of date as
+// time since the start of date, so it can be compared with the timetable.
+// Times on the day after date are greater than 24 hours
+func serviceOffset(t, date time.Time) time.Duration {
+	lt := t.In(date.Location())
+	day := time.Date(lt.Year(), lt.Month(), lt.Day(), 0, 0, 0, 0, time.UTC)
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	clock := time.Duration(lt.Hour())*time.Hour + time.Duration(lt.Minute())*time.Minute + time.Duration(lt.Second())*time.Second
+	return day.Sub(start) + clock
+}
@@ -0,0 +1,97 @@
+package caltrain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// trainNums returns the train numbers of the routes, in order
+func trainNums(routes []*Route) []string {
+	ret := make([]string, len(routes))
+	for i, r := range routes {
+		ret[i] = r.TrainNum
+	}
+	return ret
+}
+
+func assertTrainNums(t *testing.T, exp []string, routes []*Route) {
+	t.Helper()
+	nums := trainNums(routes)
+	if len(nums) != len(exp) {
+		t.Fatalf("Unexpected trains\nExpected: %v\nReceived: %v", exp, nums)
+	}
+	for i := range exp {
+		if nums[i] != exp[i] {
+			t.Fatalf("Unexpected trains\nExpected: %v\nReceived: %v", exp, nums)
+		}
+	}
+}
+
+func TestQueryOptions(t *testing.T) {
+	ctx := context.Background()
+	c := newGTFSClient(t)
+	date := time.Date(2019, time.November, 22, 0, 0, 0, 0, c.tz)
+	at := func(h, m int) time.Time {
+		return date.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute)
+	}
+
+	tests := []struct {
+		name string
+		src  Station
+		dst  Station
+		opts []QueryOption
+		exp  []string
+	}{
+		{name: "Sorted", src: StationSanJose, dst: StationSanFrancisco, exp: []string{"101", "501", "103", "199"}},
+		{name: "DepartAfter", src: StationSanJose, dst: StationSanFrancisco, opts: []QueryOption{DepartAfter(at(6, 15))}, exp: []string{"501", "103", "199"}},
+		{name: "ArriveBy", src: StationSanJose, dst: StationSanFrancisco, opts: []QueryOption{ArriveBy(at(7, 15))}, exp: []string{"101", "501"}},
+		{name: "Within", src: StationSanJose, dst: StationSanFrancisco, opts: []QueryOption{Within(at(6, 0), at(7, 0))}, exp: []string{"101", "501", "103"}},
+		{name: "NextN", src: StationSanJose, dst: StationSanFrancisco, opts: []QueryOption{DepartAfter(at(6, 15)), NextN(2)}, exp: []string{"501", "103"}},
+		{name: "AfterMidnight", src: StationHillsdale, dst: StationSanFrancisco, opts: []QueryOption{DepartAfter(at(24, 0))}, exp: []string{"199"}},
+		{name: "South", src: StationSanFrancisco, dst: StationHillsdale, opts: []QueryOption{DepartAfter(at(6, 10)), ArriveBy(at(7, 0))}, exp: []string{"502"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, err := c.GetTrainsBetweenStationsForDate(ctx, tt.src, tt.dst, date, tt.opts...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			assertTrainNums(t, tt.exp, routes)
+		})
+	}
+
+	routes, err := c.GetStationTimetable(StationHillsdale, North, date, NextN(1))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertTrainNums(t, []string{"101"}, routes)
+
+	routes, err = c.GetRoutesForAllStops(ctx, []Station{StationSanFrancisco, StationHaywardPark}, North, date, DepartAfter(at(7, 0)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertTrainNums(t, []string{"103", "199"}, routes)
+}
+
+func TestServiceOffset(t *testing.T) {
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+	date := time.Date(2019, time.November, 22, 0, 0, 0, 0, tz)
+	tests := []struct {
+		name string
+		t    time.Time
+		exp  time.Duration
+	}{
+		{name: "Morning", t: time.Date(2019, time.November, 22, 6, 30, 0, 0, tz), exp: 6*time.Hour + 30*time.Minute},
+		{name: "UTC", t: time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC), exp: 6*time.Hour + 30*time.Minute},
+		{name: "NextDay", t: time.Date(2019, time.November, 23, 0, 40, 0, 0, tz), exp: 24*time.Hour + 40*time.Minute},
+		{name: "PreviousDay", t: time.Date(2019, time.November, 21, 23, 0, 0, 0, tz), exp: -time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if offset := serviceOffset(tt.t, date); offset != tt.exp {
+				t.Fatalf("Unexpected offset. Expected %s, received %s", tt.exp, offset)
+			}
+		})
+	}
+}
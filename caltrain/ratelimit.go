@@ -0,0 +1,189 @@
+package caltrain
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// ratelimit.go contains the token bucket that keeps APIClient511 under the
+// 511.org request limit, reserving part of the budget for live status
+
+const (
+	// defaultRateLimit and defaultRatePeriod match the 511.org free tier
+	defaultRateLimit  = 60
+	defaultRatePeriod = time.Hour
+	// defaultRateReserve is the number of requests that only high priority
+	// requests can use
+	defaultRateReserve = 10
+	// maxHighPriorityWait and maxLowPriorityWait are the longest a request
+	// is paced before it is refused
+	maxHighPriorityWait = time.Minute
+	maxLowPriorityWait  = 2 * time.Minute
+)
+
+// A Priority specifies how important an API request is when the rate limit
+// is close to being reached
+type Priority int
+
+const (
+	// PriorityLow requests, such as timetable refreshes, are paced or refused
+	// before they can use the reserved part of the budget. This is the
+	// default for requests without a priority
+	PriorityLow Priority = iota
+	// PriorityHigh requests, such as live train status, can use the whole
+	// budget
+	PriorityHigh
+)
+
+type priorityKey struct{}
+
+// WithPriority returns a context that makes API requests at the given
+// priority. The CaltrainClient sets the priority of its own requests
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the priority set on ctx, or PriorityLow
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityLow
+}
+
+// RateLimitStatus is the request budget of an API client
+type RateLimitStatus struct {
+	Limit     int           // requests allowed per period, from the Ratelimit-Limit header or the configured limit
+	Remaining int           // requests left in the period, from the Ratelimit-Remaining header or the local estimate
+	Tokens    float64       // requests the local token bucket allows right now
+	Updated   time.Time     // time of the last response with rate limit headers. Zero if none have been seen
+	Period    time.Duration // time for the budget to refill
+	Reserve   int           // requests reserved for high priority requests
+}
+
+// rateLimiter is a token bucket that refills limit tokens every period. The
+// bucket is corrected by the rate limit headers of each response
+type rateLimiter struct {
+	limit   int           // bucket capacity
+	period  time.Duration // time to refill the whole bucket
+	reserve int           // tokens only high priority requests can use
+	tokens  float64       // tokens in the bucket at last
+	last    time.Time     // time tokens was calculated
+	status  RateLimitStatus
+	lock    sync.Mutex
+
+	clock clock.Clock // time package for unit testing
+}
+
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	c := clock.New()
+	reserve := defaultRateReserve
+	if reserve >= limit {
+		reserve = limit / 6
+	}
+	return &rateLimiter{
+		limit:   limit,
+		period:  period,
+		reserve: reserve,
+		tokens:  float64(limit),
+		last:    c.Now(),
+		status:  RateLimitStatus{Limit: limit, Remaining: limit},
+		clock:   c,
+	}
+}
+
+// refill adds the tokens earned since the last call. The lock must be held
+func (r *rateLimiter) refill() {
+	now := r.clock.Now()
+	rate := float64(r.limit) / float64(r.period)
+	r.tokens = math.Min(float64(r.limit), r.tokens+float64(now.Sub(r.last))*rate)
+	r.last = now
+}
+
+// wait takes a token for a request at the priority on ctx. If there isn't
+// one available it waits for one to be added, up to the priority's maximum
+// wait, and otherwise returns an APILimitError with the time until a token
+// would be available
+func (r *rateLimiter) wait(ctx context.Context) error {
+	p := priorityFrom(ctx)
+	need, maxWait := 1.0, maxHighPriorityWait
+	if p == PriorityLow {
+		need, maxWait = float64(r.reserve+1), maxLowPriorityWait
+	}
+
+	for {
+		r.lock.Lock()
+		r.refill()
+		if r.tokens >= need {
+			r.tokens--
+			r.lock.Unlock()
+			return nil
+		}
+		rate := float64(r.limit) / float64(r.period)
+		delay := time.Duration(math.Ceil((need - r.tokens) / rate))
+		r.lock.Unlock()
+
+		if delay > maxWait {
+			return &APILimitError{RetryAfter: delay}
+		}
+		timer := r.clock.Timer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// update corrects the bucket with the rate limit headers of a response. The
+// API is the authority on the budget, so the bucket never has more tokens
+// than the API reports remaining
+func (r *rateLimiter) update(resp *http.Response) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.refill()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		r.tokens = 0
+		r.status.Remaining = 0
+		r.status.Updated = r.clock.Now()
+		return
+	}
+
+	limit, err := strconv.Atoi(resp.Header.Get("Ratelimit-Limit"))
+	if err != nil {
+		return
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get("Ratelimit-Remaining"))
+	if err != nil {
+		return
+	}
+	r.status.Limit = limit
+	r.status.Remaining = remaining
+	r.status.Updated = r.clock.Now()
+	if float64(remaining) < r.tokens {
+		r.tokens = float64(remaining)
+	}
+}
+
+// rateLimitStatus returns the current budget. Without headers the remaining
+// requests are estimated from the bucket
+func (r *rateLimiter) rateLimitStatus() RateLimitStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.refill()
+	s := r.status
+	s.Tokens = r.tokens
+	s.Period = r.period
+	s.Reserve = r.reserve
+	if s.Updated.IsZero() {
+		s.Remaining = int(r.tokens)
+	}
+	return s
+}
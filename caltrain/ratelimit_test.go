@@ -0,0 +1,150 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func newMockRateLimiter(limit int, period time.Duration) (*rateLimiter, *clock.Mock) {
+	r := newRateLimiter(limit, period)
+	mock := clock.NewMock()
+	r.clock = mock
+	r.last = mock.Now()
+	return r, mock
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	low := context.Background()
+	high := WithPriority(low, PriorityHigh)
+	r, _ := newMockRateLimiter(defaultRateLimit, defaultRatePeriod)
+
+	// low priority requests can't use the reserve
+	for i := 0; i < defaultRateLimit-defaultRateReserve; i++ {
+		if err := r.wait(low); err != nil {
+			t.Fatalf("Unexpected error on request %d: %v", i, err)
+		}
+	}
+	// the next token is a minute away, so the low priority request would be
+	// paced. Use a short deadline to check it is not taken right away
+	ctx, cancel := context.WithTimeout(low, 10*time.Millisecond)
+	defer cancel()
+	if err := r.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the low priority request to wait, received %v", err)
+	}
+
+	// high priority requests can use the reserve
+	for i := 0; i < defaultRateReserve; i++ {
+		if err := r.wait(high); err != nil {
+			t.Fatalf("Unexpected error on high priority request %d: %v", i, err)
+		}
+	}
+
+	// the bucket is empty and a low priority request would wait longer than
+	// allowed
+	var limErr *APILimitError
+	if err := r.wait(low); !errors.As(err, &limErr) {
+		t.Fatalf("Expected an APILimitError, received %v", err)
+	}
+	if limErr.RetryAfter != 11*time.Minute {
+		t.Fatalf("Unexpected retry after. Expected %s, received %s", 11*time.Minute, limErr.RetryAfter)
+	}
+}
+
+func TestRateLimiterPacing(t *testing.T) {
+	high := WithPriority(context.Background(), PriorityHigh)
+	r, mock := newMockRateLimiter(defaultRateLimit, defaultRatePeriod)
+	r.tokens = 0
+
+	done := make(chan error)
+	go func() { done <- r.wait(high) }()
+
+	// a token is added every minute, the request waits for it
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if elapsed := mock.Now().Sub(time.Unix(0, 0)); elapsed < time.Minute {
+				t.Fatalf("Request was not paced, it waited %s", elapsed)
+			}
+			return
+		default:
+			mock.Add(10 * time.Second)
+		}
+	}
+}
+
+func TestAPIClient511RateLimit(t *testing.T) {
+	var calls int32
+	remaining := 5
+	code := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Ratelimit-Limit", "60")
+		w.Header().Set("Ratelimit-Remaining", strconv.Itoa(remaining))
+		w.WriteHeader(code)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	high := WithPriority(ctx, PriorityHigh)
+	a := NewClient()
+	mock := clock.NewMock()
+	a.limiter.clock = mock
+	a.limiter.last = mock.Now()
+
+	if _, err := a.Get(high, server.URL, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	status := a.RateLimitStatus()
+	if status.Limit != 60 || status.Remaining != 5 || status.Tokens != 5 || status.Updated.IsZero() {
+		t.Fatalf("Unexpected rate limit status: %+v", status)
+	}
+
+	// the API reports fewer requests left than the reserve, so low priority
+	// requests are refused without a request
+	var limErr *APILimitError
+	if _, err := a.Get(ctx, server.URL, nil); !errors.As(err, &limErr) {
+		t.Fatalf("Expected an APILimitError, received %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Unexpected number of requests. Expected %d, received %d", 1, n)
+	}
+
+	code = http.StatusTooManyRequests
+	if _, err := a.Get(high, server.URL, nil); !errors.As(err, &limErr) {
+		t.Fatalf("Expected an APILimitError, received %v", err)
+	}
+	if status := a.RateLimitStatus(); status.Remaining != 0 || status.Tokens != 0 {
+		t.Fatalf("Unexpected rate limit status after a 429: %+v", status)
+	}
+
+	a.SetRateLimit(0, 0)
+	code = http.StatusOK
+	if _, err := a.Get(ctx, server.URL, nil); err != nil {
+		t.Fatalf("Unexpected error with rate limiting disabled: %v", err)
+	}
+}
+
+func TestClientRateLimitStatus(t *testing.T) {
+	c := New(fakeKey)
+	status, ok := c.RateLimitStatus()
+	if !ok || status.Limit != defaultRateLimit || status.Remaining != defaultRateLimit {
+		t.Fatalf("Unexpected rate limit status: %+v, %t", status, ok)
+	}
+
+	c.APIClient = &apiClientMock{}
+	if _, ok := c.RateLimitStatus(); ok {
+		t.Fatalf("The mock client should not report a rate limit status")
+	}
+}
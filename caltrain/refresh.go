@@ -0,0 +1,223 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// refresh.go contains the scheduler that keeps the static data up to date in
+// the background
+
+const (
+	defaultHolidayInterval = 24 * time.Hour
+	// defaultTimetableHour and defaultRefreshWindow put the timetable
+	// refresh between 2am and 4am pacific time, when no trains are running
+	defaultTimetableHour   = 2
+	defaultRefreshWindow   = 2 * time.Hour
+	defaultRefreshRetries  = 5
+	defaultRefreshRetry    = time.Minute
+	defaultRefreshMinQuota = 20
+)
+
+// ErrLowBudget is recorded when a refresh is skipped because the API has
+// too few requests left in the rate limit
+var ErrLowBudget = errors.New("refresh skipped: the rate limit budget is low")
+
+// RefreshPolicy configures StartAutoRefresh. Zero fields use the defaults,
+// except TimetableHour where 0 is midnight
+type RefreshPolicy struct {
+	HolidayInterval time.Duration // time between holiday refreshes. Defaults to 24 hours
+	TimetableHour   int           // pacific hour the timetable window starts. Negative uses the default of 2am
+	Window          time.Duration // length of the timetable window, the refresh is at a random time in it. Defaults to 2 hours
+	MaxRetries      int           // retries of a failed refresh before waiting for the next one. Defaults to 5
+	RetryDelay      time.Duration // delay before the first retry, doubled for each retry after. Defaults to 1 minute
+	MinBudget       int           // remaining requests needed to start a refresh. Defaults to 20
+}
+
+// RefreshStatus is the result of the refreshes of one kind of static data
+type RefreshStatus struct {
+	LastSuccess time.Time // time of the last successful refresh
+	LastError   error     // error of the last failed refresh, nil if the last refresh succeeded
+	LastFailure time.Time // time of the last failed refresh
+	Next        time.Time // time of the next refresh
+}
+
+// AutoRefreshStatus is the status of the refreshes started by
+// StartAutoRefresh
+type AutoRefreshStatus struct {
+	Holidays  RefreshStatus
+	Timetable RefreshStatus // lines, stations and timetable
+}
+
+// withDefaults returns the policy with the zero fields set to the defaults
+func (p RefreshPolicy) withDefaults() RefreshPolicy {
+	if p.HolidayInterval <= 0 {
+		p.HolidayInterval = defaultHolidayInterval
+	}
+	if p.TimetableHour < 0 || p.TimetableHour > 23 {
+		p.TimetableHour = defaultTimetableHour
+	}
+	if p.Window <= 0 {
+		p.Window = defaultRefreshWindow
+	}
+	if p.MaxRetries <= 0 {
+		p.MaxRetries = defaultRefreshRetries
+	}
+	if p.RetryDelay <= 0 {
+		p.RetryDelay = defaultRefreshRetry
+	}
+	if p.MinBudget <= 0 {
+		p.MinBudget = defaultRefreshMinQuota
+	}
+	return p
+}
+
+// StartAutoRefresh refreshes the static data in the background until ctx is
+// done. The holidays are refreshed every HolidayInterval, and the lines,
+// stations and timetable every night in the pacific off-peak window. A failed
+// refresh is retried with backoff, and a refresh is skipped with ErrLowBudget
+// when the rate limit is close to being reached. The results are reported by
+// AutoRefreshStatus. The data should already be loaded, for example with
+// Initialize
+func (c *CaltrainClient) StartAutoRefresh(ctx context.Context, p RefreshPolicy) {
+	p = p.withDefaults()
+	c.arWait.Add(1)
+	go func() {
+		defer c.arWait.Done()
+		c.autoRefresh(ctx, p)
+	}()
+}
+
+// AutoRefreshStatus returns the status of the refreshes started by
+// StartAutoRefresh
+func (c *CaltrainClient) AutoRefreshStatus() AutoRefreshStatus {
+	c.arLock.Lock()
+	defer c.arLock.Unlock()
+	return c.arStatus
+}
+
+// autoRefreshJob is a kind of static data refreshed on a schedule
+type autoRefreshJob struct {
+	name     string
+	next     time.Time
+	retries  int
+	refresh  func(context.Context) error
+	schedule func(now time.Time) time.Time // time of the next refresh after a success
+	status   func(*AutoRefreshStatus) *RefreshStatus
+}
+
+// autoRefresh runs the refresh jobs when they are due until ctx is done
+func (c *CaltrainClient) autoRefresh(ctx context.Context, p RefreshPolicy) {
+	now := c.clock.Now()
+	jobs := []*autoRefreshJob{
+		{
+			name:     "holidays",
+			refresh:  c.UpdateHolidays,
+			schedule: func(now time.Time) time.Time { return now.Add(p.HolidayInterval) },
+			status:   func(s *AutoRefreshStatus) *RefreshStatus { return &s.Holidays },
+		},
+		{
+			name:     "timetable",
+			refresh:  c.refreshTimetable,
+			schedule: func(now time.Time) time.Time { return c.nextRefreshWindow(now, p) },
+			status:   func(s *AutoRefreshStatus) *RefreshStatus { return &s.Timetable },
+		},
+	}
+	for _, j := range jobs {
+		j.next = j.schedule(now)
+		c.updateRefreshStatus(j, nil, time.Time{})
+	}
+
+	for {
+		next := jobs[0].next
+		for _, j := range jobs[1:] {
+			if j.next.Before(next) {
+				next = j.next
+			}
+		}
+		timer := c.clock.Timer(next.Sub(c.clock.Now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		for _, j := range jobs {
+			now := c.clock.Now()
+			if now.Before(j.next) {
+				continue
+			}
+			err := c.runRefresh(ctx, j, p)
+			if err != nil && j.retries < p.MaxRetries {
+				j.retries++
+				j.next = now.Add(p.RetryDelay << uint(j.retries-1))
+				logrus.Warnf("failed to refresh the %s, retrying at %s: %v", j.name, j.next, err)
+			} else {
+				if err != nil {
+					logrus.Errorf("failed to refresh the %s after %d retries: %v", j.name, j.retries, err)
+				}
+				j.retries = 0
+				j.next = j.schedule(now)
+			}
+			c.updateRefreshStatus(j, err, now)
+		}
+	}
+}
+
+// runRefresh runs a job unless the rate limit budget is too low for it
+func (c *CaltrainClient) runRefresh(ctx context.Context, j *autoRefreshJob, p RefreshPolicy) error {
+	if status, ok := c.RateLimitStatus(); ok && status.Remaining < p.MinBudget {
+		return ErrLowBudget
+	}
+	logrus.Debugf("Refreshing the %s...", j.name)
+	return j.refresh(WithRefresh(ctx))
+}
+
+// updateRefreshStatus records the result of a job run at t. A zero t only
+// records the next run
+func (c *CaltrainClient) updateRefreshStatus(j *autoRefreshJob, err error, t time.Time) {
+	c.arLock.Lock()
+	defer c.arLock.Unlock()
+	s := j.status(&c.arStatus)
+	s.Next = j.next
+	if t.IsZero() {
+		return
+	}
+	s.LastError = err
+	if err != nil {
+		s.LastFailure = t
+	} else {
+		s.LastSuccess = t
+	}
+}
+
+// refreshTimetable updates the lines, stations and timetable
+func (c *CaltrainClient) refreshTimetable(ctx context.Context) error {
+	if err := c.UpdateLines(ctx); err != nil {
+		return fmt.Errorf("failure updating Lines: %w", err)
+	}
+	if err := c.UpdateStations(ctx); err != nil {
+		return fmt.Errorf("failure updating Stations: %w", err)
+	}
+	if err := c.UpdateTimeTable(ctx); err != nil {
+		return fmt.Errorf("failure updating Time Tables: %w", err)
+	}
+	return nil
+}
+
+// nextRefreshWindow returns a random time in the next timetable window after
+// now
+func (c *CaltrainClient) nextRefreshWindow(now time.Time, p RefreshPolicy) time.Time {
+	local := now.In(c.tz)
+	start := time.Date(local.Year(), local.Month(), local.Day(), p.TimetableHour, 0, 0, 0, c.tz)
+	if !start.After(local) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start.Add(time.Duration(rand.Int63n(int64(p.Window))))
+}
@@ -0,0 +1,192 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// newRefreshClient returns a client with a mock clock at noon pacific time
+func newRefreshClient(t *testing.T, api APIClient) (*CaltrainClient, *clock.Mock) {
+	t.Helper()
+	c := New(fakeKey)
+	c.APIClient = api
+	mock := clock.NewMock()
+	mock.Set(time.Date(2019, time.November, 22, 12, 0, 0, 0, c.tz))
+	c.clock = mock
+	return c, mock
+}
+
+// advanceUntil moves the mock clock forward by step until cond is true
+func advanceUntil(t *testing.T, mock *clock.Mock, step time.Duration, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		if cond() {
+			return
+		}
+		mock.Add(step)
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Condition was not met by %s", mock.Now())
+}
+
+func TestAutoRefresh(t *testing.T) {
+	c, mock := newRefreshClient(t, apiClientRouter{})
+	start := mock.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.arWait.Wait()
+	defer cancel()
+	c.StartAutoRefresh(ctx, RefreshPolicy{HolidayInterval: time.Hour, TimetableHour: -1})
+
+	advanceUntil(t, mock, 10*time.Minute, func() bool {
+		return !c.AutoRefreshStatus().Holidays.LastSuccess.IsZero()
+	})
+	s := c.AutoRefreshStatus()
+	if s.Holidays.LastError != nil || s.Holidays.Next != s.Holidays.LastSuccess.Add(time.Hour) {
+		t.Fatalf("Unexpected holiday status: %+v", s.Holidays)
+	}
+	if len(c.Holidays()) == 0 {
+		t.Fatalf("The holidays were not refreshed")
+	}
+
+	// the timetable is refreshed in the window on the next night
+	next := s.Timetable.Next.In(c.tz)
+	windowStart := time.Date(2019, time.November, 23, defaultTimetableHour, 0, 0, 0, c.tz)
+	if next.Before(windowStart) || !next.Before(windowStart.Add(defaultRefreshWindow)) {
+		t.Fatalf("The timetable refresh at %s is not in the off-peak window", next)
+	}
+	advanceUntil(t, mock, 10*time.Minute, func() bool {
+		return !c.AutoRefreshStatus().Timetable.LastSuccess.IsZero()
+	})
+	if len(c.AllLines()) != 3 || c.LastFetched().Before(start) {
+		t.Fatalf("The timetable was not refreshed: %v, %s", c.AllLines(), c.LastFetched())
+	}
+}
+
+// apiClientURLCounter counts the calls to each URL made to the router
+type apiClientURLCounter struct {
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func (a *apiClientURLCounter) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	a.lock.Lock()
+	a.calls[url]++
+	a.lock.Unlock()
+	return apiClientRouter{}.Get(ctx, url, query)
+}
+
+func (a *apiClientURLCounter) count(url string) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.calls[url]
+}
+
+func TestAutoRefreshCache(t *testing.T) {
+	api := &apiClientURLCounter{calls: map[string]int{}}
+	c, mock := newRefreshClient(t, api)
+	c.SetupCache(defaultCacheTimeout)
+	c.cache.(*caltrainCache).clock = mock
+	if err := c.Initialize(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.arWait.Wait()
+	defer cancel()
+	c.StartAutoRefresh(ctx, RefreshPolicy{HolidayInterval: time.Hour})
+
+	// the cached responses are less than 24 hours old, but each refresh
+	// still calls the API
+	advanceUntil(t, mock, 10*time.Minute, func() bool {
+		return !c.AutoRefreshStatus().Timetable.LastSuccess.IsZero()
+	})
+	for _, url := range []string{holidaysURL, linesURL, stationsURL, timetableURL} {
+		if n := api.count(url); n < 2 {
+			t.Fatalf("The refresh did not call %s. Expected at least %d calls, received %d", url, 2, n)
+		}
+	}
+
+	// other requests still use the cache
+	calls := api.count(holidaysURL)
+	if err := c.UpdateHolidays(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := api.count(holidaysURL); n != calls {
+		t.Fatalf("Unexpected number of calls. Expected %d, received %d", calls, n)
+	}
+}
+
+func TestAutoRefreshRetry(t *testing.T) {
+	apiErr := errors.New("connection refused")
+	c, mock := newRefreshClient(t, &apiClientSequence{err: apiErr})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.arWait.Wait()
+	defer cancel()
+	c.StartAutoRefresh(ctx, RefreshPolicy{HolidayInterval: time.Hour, RetryDelay: time.Minute, MaxRetries: 2})
+
+	// the failures are retried after 1 and 2 minutes, then the next refresh
+	// is scheduled
+	var failures []time.Time
+	advanceUntil(t, mock, 30*time.Second, func() bool {
+		s := c.AutoRefreshStatus().Holidays
+		if !s.LastFailure.IsZero() && (len(failures) == 0 || s.LastFailure.After(failures[len(failures)-1])) {
+			failures = append(failures, s.LastFailure)
+		}
+		return len(failures) == 3
+	})
+	s := c.AutoRefreshStatus().Holidays
+	if !errors.Is(s.LastError, apiErr) || !s.LastSuccess.IsZero() {
+		t.Fatalf("Unexpected holiday status: %+v", s)
+	}
+	if d := failures[1].Sub(failures[0]); d < time.Minute || d > 2*time.Minute {
+		t.Fatalf("Unexpected first retry delay %s", d)
+	}
+	if d := failures[2].Sub(failures[1]); d < 2*time.Minute || d > 3*time.Minute {
+		t.Fatalf("Unexpected second retry delay %s", d)
+	}
+	if s.Next != failures[2].Add(time.Hour) {
+		t.Fatalf("Unexpected next refresh %s after the last retry at %s", s.Next, failures[2])
+	}
+}
+
+func TestAutoRefreshLowBudget(t *testing.T) {
+	a := NewClient()
+	a.SetRateLimit(10, time.Hour)
+	c, mock := newRefreshClient(t, a)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer c.arWait.Wait()
+	defer cancel()
+	c.StartAutoRefresh(ctx, RefreshPolicy{HolidayInterval: time.Hour})
+
+	advanceUntil(t, mock, 10*time.Minute, func() bool {
+		return c.AutoRefreshStatus().Holidays.LastError != nil
+	})
+	if err := c.AutoRefreshStatus().Holidays.LastError; !errors.Is(err, ErrLowBudget) {
+		t.Fatalf("Expected ErrLowBudget, received %v", err)
+	}
+}
+
+func TestNextRefreshWindow(t *testing.T) {
+	c, mock := newRefreshClient(t, apiClientRouter{})
+	tests := []struct {
+		name  string
+		hour  int
+		start time.Time
+	}{
+		{name: "midnight", hour: 0, start: time.Date(2019, time.November, 23, 0, 0, 0, 0, c.tz)},
+		{name: "later today", hour: 22, start: time.Date(2019, time.November, 22, 22, 0, 0, 0, c.tz)},
+		{name: "negative is the default", hour: -1, start: time.Date(2019, time.November, 23, defaultTimetableHour, 0, 0, 0, c.tz)},
+		{name: "past 23 is the default", hour: 24, start: time.Date(2019, time.November, 23, defaultTimetableHour, 0, 0, 0, c.tz)},
+	}
+	for _, tt := range tests {
+		p := RefreshPolicy{TimetableHour: tt.hour}.withDefaults()
+		next := c.nextRefreshWindow(mock.Now(), p)
+		if next.Before(tt.start) || !next.Before(tt.start.Add(p.Window)) {
+			t.Fatalf("Unexpected refresh for %s. Expected in the window at %s, received %s", tt.name, tt.start, next)
+		}
+	}
+}
@@ -0,0 +1,423 @@
+package caltrain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// registry.go contains the station registry. The well known stations are the
+// Station constants, and stops in the stops feed that are not known are
+// registered by the Dataset they are loaded into, so a new or renamed stop
+// doesn't break the updates until a new release ships
+
+// UnknownStationPolicy specifies how an update handles a stop whose name is
+// not a registered station or alias
+type UnknownStationPolicy int
+
+const (
+	// UnknownStationWarn registers the stop as a new Station and logs a
+	// warning. This is the default
+	UnknownStationWarn UnknownStationPolicy = iota
+	// UnknownStationSkip ignores the stop
+	UnknownStationSkip
+	// UnknownStationFail fails the update
+	UnknownStationFail
+)
+
+var unknownStationPolicies = [...]string{
+	"Warn",
+	"Skip",
+	"Fail",
+}
+
+// String returns the string name of the policy
+func (p UnknownStationPolicy) String() string {
+	if UnknownStationWarn <= p && p <= UnknownStationFail {
+		return unknownStationPolicies[p]
+	}
+	return fmt.Sprintf("unknown station policy %d", p)
+}
+
+// knownStations are the names of the Station constants
+var knownStations = [...]string{
+	StationSanFrancisco: "San Francisco",
+	Station22ndStreet:   "22nd Street",
+	StationBayshore:     "Bayshore",
+	StationSouthSF:      "South San Francisco",
+	StationSanBruno:     "San Bruno",
+	StationMillbrae:     "Millbrae",
+	StationBroadway:     "Broadway",
+	StationBurlingame:   "Burlingame",
+	StationSanMateo:     "San Mateo",
+	StationHaywardPark:  "Hayward Park",
+	StationHillsdale:    "Hillsdale",
+	StationBelmont:      "Belmont",
+	StationSanCarlos:    "San Carlos",
+	StationRedwoodCity:  "Redwood City",
+	StationAtherton:     "Atherton",
+	StationMenloPark:    "Menlo Park",
+	StationPaloAlto:     "Palo Alto",
+	StationStanford:     "Stanford",
+	StationCalAve:       "California Ave",
+	StationSanAntonio:   "San Antonio",
+	StationMountainView: "Mountain View",
+	StationSunnyvale:    "Sunnyvale",
+	StationLawrence:     "Lawrence",
+	StationSantaClara:   "Santa Clara",
+	StationCollegePark:  "College Park",
+	StationSanJose:      "San Jose Diridon",
+	StationTamien:       "Tamien",
+	StationCapitol:      "Capitol",
+	StationBlossomHill:  "Blossom Hill",
+	StationMorganHill:   "Morgan Hill",
+	StationSanMartin:    "San Martin",
+	StationGilroy:       "Gilroy",
+}
+
+// knownAliases are other names used for the well known stations, such as
+// the names 511.org uses for the extra San Jose stop
+var knownAliases = map[string]Station{
+	"San Jose":          StationSanJose,
+	"Diridon":           StationSanJose,
+	"California Avenue": StationCalAve,
+	"Cal Ave":           StationCalAve,
+	"South SF":          StationSouthSF,
+	"SSF":               StationSouthSF,
+	"22nd St":           Station22ndStreet,
+	"SF":                StationSanFrancisco,
+	"4th and King":      StationSanFrancisco,
+	"SJ":                StationSanJose,
+}
+
+// knownLookup maps the normalized names and aliases of the well known
+// stations to Stations. It is never modified
+var knownLookup = newKnownLookup()
+
+// newKnownLookup returns the names and aliases of the well known stations
+func newKnownLookup() map[string]Station {
+	ret := make(map[string]Station, len(knownStations)+len(knownAliases))
+	for i, name := range knownStations {
+		ret[normalizeStationName(name)] = Station(i)
+	}
+	for alias, st := range knownAliases {
+		ret[normalizeStationName(alias)] = st
+	}
+	return ret
+}
+
+// isKnownStation returns true if st is one of the Station constants
+func isKnownStation(st Station) bool {
+	return st >= 0 && int(st) < len(knownStations)
+}
+
+// stationRegistry maps the names of the stations registered from the stops
+// feed to Stations, and orders all of the stations from north to south. Each
+// Dataset has its own registry, which is not modified once the Dataset is in
+// use. Updates register and place stations in a copy, and a registered
+// Station keeps its number in the copies, so it stays valid for the life of
+// the client
+type stationRegistry struct {
+	names  []string           // names of the registered stations, from the first Station after the constants
+	lookup map[string]Station // normalized names of the registered stations and the added aliases
+	order  []Station          // stations from north to south
+	placed map[Station]bool   // stations whose place in order is known
+}
+
+// newStationRegistry returns a registry of the well known stations
+func newStationRegistry() *stationRegistry {
+	r := &stationRegistry{
+		lookup: make(map[string]Station),
+		order:  make([]Station, len(knownStations)),
+		placed: make(map[Station]bool),
+	}
+	for i := range knownStations {
+		st := Station(i)
+		r.order[i] = st
+		r.placed[st] = true
+	}
+	return r
+}
+
+// clone returns a copy of the registry that can be modified
+func (r *stationRegistry) clone() *stationRegistry {
+	ret := &stationRegistry{
+		names:  make([]string, len(r.names)),
+		lookup: make(map[string]Station, len(r.lookup)),
+		order:  make([]Station, len(r.order)),
+		placed: make(map[Station]bool, len(r.placed)),
+	}
+	copy(ret.names, r.names)
+	copy(ret.order, r.order)
+	for k, st := range r.lookup {
+		ret.lookup[k] = st
+	}
+	for st := range r.placed {
+		ret.placed[st] = true
+	}
+	return ret
+}
+
+// normalizeStationName returns the key of a station name in the registry
+func normalizeStationName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// name returns the name of a station, or an empty string if it is not
+// registered
+func (r *stationRegistry) name(st Station) string {
+	if isKnownStation(st) {
+		return knownStations[st]
+	}
+	if i := int(st) - len(knownStations); i >= 0 && i < len(r.names) {
+		return r.names[i]
+	}
+	return ""
+}
+
+// find returns the station with the given name or alias
+func (r *stationRegistry) find(name string) (Station, bool) {
+	key := normalizeStationName(name)
+	if st, ok := knownLookup[key]; ok {
+		return st, true
+	}
+	st, ok := r.lookup[key]
+	return st, ok
+}
+
+// register returns the station with the given name. A station that is not
+// registered is added to the south end of the line until a timetable places
+// it. It modifies the registry, so it is only used on a copy
+func (r *stationRegistry) register(name string) Station {
+	if st, ok := r.find(name); ok {
+		return st
+	}
+	st := Station(len(knownStations) + len(r.names))
+	r.names = append(r.names, strings.Join(strings.Fields(name), " "))
+	r.lookup[normalizeStationName(name)] = st
+	r.order = append(r.order, st)
+	return st
+}
+
+// stations returns the registered stations from north to south
+func (r *stationRegistry) stations() []Station {
+	ret := make([]Station, len(r.order))
+	copy(ret, r.order)
+	return ret
+}
+
+// position returns the index of a station from the north end of the line
+func (r *stationRegistry) position(st Station) (int, bool) {
+	i := indexOfStation(r.order, st)
+	return i, i >= 0
+}
+
+// split returns the placed stations of a set in order from north to south,
+// and the ones that have not been placed by a timetable yet
+func (r *stationRegistry) split(stations map[Station]*stationInfo) ([]Station, []Station) {
+	placed := []Station{}
+	unplaced := []Station{}
+	for _, st := range r.order {
+		if _, ok := stations[st]; !ok {
+			continue
+		}
+		if r.placed[st] {
+			placed = append(placed, st)
+		} else {
+			unplaced = append(unplaced, st)
+		}
+	}
+	return placed, unplaced
+}
+
+// place returns the registry with the unplaced stations that routes stop at
+// moved to their place in order, the order of a timetable from north to
+// south. Stations that are placed keep their place. The registry is copied if
+// any station is placed
+func (r *stationRegistry) place(order []Station, routed map[Station]bool) *stationRegistry {
+	add := []Station{}
+	for _, st := range order {
+		if !r.placed[st] && routed[st] {
+			add = append(add, st)
+		}
+	}
+	if len(add) == 0 {
+		return r
+	}
+	placed := []Station{}
+	unplaced := []Station{}
+	for _, st := range r.order {
+		if r.placed[st] {
+			placed = append(placed, st)
+		} else if !routed[st] || indexOfStation(order, st) < 0 {
+			unplaced = append(unplaced, st)
+		}
+	}
+	ret := r.clone()
+	for _, st := range add {
+		ret.placed[st] = true
+	}
+	ret.order = append(orderStations(placed, add, [][]Station{order}), unplaced...)
+	return ret
+}
+
+// SetUnknownStationPolicy sets how UpdateStations, LoadGTFS and LoadSnapshot
+// handle a stop whose name is not a registered station or alias. The default
+// is UnknownStationWarn
+func (c *CaltrainClient) SetUnknownStationPolicy(p UnknownStationPolicy) {
+	c.stationPolicy = p
+}
+
+// alias adds another name for a station. It modifies the registry, so it is
+// only used on a copy
+func (r *stationRegistry) alias(alias string, st Station) error {
+	if r.name(st) == "" {
+		return fmt.Errorf("unknown station %d", st)
+	}
+	if other, ok := r.find(alias); ok && other != st {
+		return fmt.Errorf("%s is already used by %s", alias, r.name(other))
+	}
+	r.lookup[normalizeStationName(alias)] = st
+	return nil
+}
+
+// AddStationAlias adds another name that the client accepts for a station,
+// such as the new name of a renamed stop. The alias is kept when the stations
+// are updated, and other clients are not affected
+func (c *CaltrainClient) AddStationAlias(alias string, st Station) error {
+	return c.update(func(d *Dataset) error {
+		r := d.registry.clone()
+		if err := r.alias(alias, st); err != nil {
+			return err
+		}
+		d.registry = r
+		return nil
+	})
+}
+
+// String returns the string name of a well known station. String values are
+// show in the Station constant definition. The names of the stations
+// registered from the stops feed are returned by StationName
+func (s Station) String() string {
+	if isKnownStation(s) {
+		return knownStations[s]
+	}
+	return fmt.Sprintf("unknown station %d", s)
+}
+
+// ParseStation returns a well known Station from the string passed in. Names
+// and aliases are accepted, along with abbreviations, prefixes and
+// misspellings that match one station clearly. Otherwise it returns a
+// StationNotFoundError with the closest stations, see ResolveStation. The
+// ParseStation method of a client also accepts the stations registered from
+// the stops feed and the aliases added to the client
+func ParseStation(s string) (Station, error) {
+	return newStationRegistry().parse(s)
+}
+
+// parse returns the station with the given name, or the station it clearly
+// matches
+func (r *stationRegistry) parse(s string) (Station, error) {
+	if st, ok := r.find(s); ok {
+		return st, nil
+	}
+	return r.match(s)
+}
+
+// StationName returns the name of a station, including the stations
+// registered from the stops feed
+func (d *Dataset) StationName(st Station) string {
+	if name := d.registry.name(st); name != "" {
+		return name
+	}
+	return st.String()
+}
+
+// ParseStation returns a Station from the string passed in, the same as the
+// ParseStation function except the stations registered from the stops feed
+// and the aliases added to the client are also accepted
+func (d *Dataset) ParseStation(s string) (Station, error) {
+	return d.registry.parse(s)
+}
+
+// GetDirectionFromSrcToDst returns the direction the train would go to get
+// from src to dst, by the order of the stations in the timetable
+func (d *Dataset) GetDirectionFromSrcToDst(src, dst Station) (Direction, error) {
+	return d.registry.direction(src, dst)
+}
+
+// StationName returns the name of a station, including the stations
+// registered from the stops feed
+func (c *CaltrainClient) StationName(st Station) string {
+	return c.dataset().StationName(st)
+}
+
+// ParseStation returns a Station from the string passed in, including the
+// stations registered from the stops feed
+func (c *CaltrainClient) ParseStation(s string) (Station, error) {
+	return c.dataset().ParseStation(s)
+}
+
+// GetDirectionFromSrcToDst returns the direction the train would go to get
+// from src to dst, including the stations registered from the stops feed
+func (c *CaltrainClient) GetDirectionFromSrcToDst(src, dst Station) (Direction, error) {
+	return c.dataset().GetDirectionFromSrcToDst(src, dst)
+}
+
+// orderStations returns the stations of order and add from north to south.
+// The stations of order keep their place, and each station of add is inserted
+// where it disagrees with the fewest sequences, each of which runs from north
+// to south. A station that no sequence has is added to the south end
+func orderStations(order, add []Station, sequences [][]Station) []Station {
+	ret := make([]Station, len(order), len(order)+len(add))
+	copy(ret, order)
+	for _, st := range add {
+		best, bestCost := len(ret), -1
+		for at := 0; at <= len(ret); at++ {
+			cost := 0
+			for _, seq := range sequences {
+				i := indexOfStation(seq, st)
+				if i < 0 {
+					continue
+				}
+				for j, other := range seq {
+					k := indexOfStation(ret, other)
+					if j == i || k < 0 {
+						continue
+					}
+					// other should be before st if it is before it in seq
+					if (j < i) != (k < at) {
+						cost++
+					}
+				}
+			}
+			if bestCost < 0 || cost < bestCost {
+				best, bestCost = at, cost
+			}
+		}
+		if !inSequences(st, sequences) {
+			best = len(ret)
+		}
+		ret = append(ret[:best], append([]Station{st}, ret[best:]...)...)
+	}
+	return ret
+}
+
+// inSequences returns true if any of the sequences has st
+func inSequences(st Station, sequences [][]Station) bool {
+	for _, seq := range sequences {
+		if indexOfStation(seq, st) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOfStation returns the index of st in stations, or -1
+func indexOfStation(stations []Station, st Station) int {
+	for i, s := range stations {
+		if s == st {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,247 @@
+package caltrain
+
+import (
+	"context"
+	"testing"
+)
+
+// newStopPoint returns a stop point for buildStations
+func newStopPoint(id, name string) scheduledStopPoint {
+	p := scheduledStopPoint{ID: id, Name: name + " Caltrain Station"}
+	p.Location.Latitude = "37.0"
+	p.Location.Longitude = "-122.0"
+	return p
+}
+
+func TestParseStationAlias(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  Station
+	}{
+		{name: "San Jose Diridon", exp: StationSanJose},
+		{name: "san jose", exp: StationSanJose},
+		{name: "California  Avenue", exp: StationCalAve},
+		{name: "HILLSDALE", exp: StationHillsdale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := ParseStation(tt.name)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if st != tt.exp {
+				t.Fatalf("Unexpected station. Expected %s, received %s", tt.exp, st)
+			}
+		})
+	}
+
+	c := New(fakeKey)
+	other := New(fakeKey)
+	if _, err := c.ParseStation("Hillsdale Shops"); err == nil {
+		t.Fatalf("ParseStation improperly succeeded for an unknown name")
+	}
+	if err := c.AddStationAlias("Hillsdale Shops", StationHillsdale); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if st, err := c.ParseStation("Hillsdale Shops"); err != nil || st != StationHillsdale {
+		t.Fatalf("Unexpected station for the new alias: %s, %v", st, err)
+	}
+	if err := c.AddStationAlias("Belmont", StationHillsdale); err == nil {
+		t.Fatalf("AddStationAlias improperly succeeded for the name of another station")
+	}
+	if err := c.AddStationAlias("Pajaro", Station(len(knownStations))); err == nil {
+		t.Fatalf("AddStationAlias improperly succeeded for a station that is not registered")
+	}
+
+	// the alias only belongs to the client it was added to
+	if _, err := ParseStation("Hillsdale Shops"); err == nil {
+		t.Fatalf("The alias was parsed without a client")
+	}
+	if st, err := other.ParseStation("Hillsdale Shops"); err == nil && st == StationHillsdale {
+		t.Fatalf("The alias was parsed by another client")
+	}
+
+	// and is kept when the stations are updated
+	c.APIClient = &apiClientMock{GetResultFilePath: "testdata/stations.json"}
+	if err := c.UpdateStations(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if st, err := c.ParseStation("Hillsdale Shops"); err != nil || st != StationHillsdale {
+		t.Fatalf("Unexpected station for the alias after an update: %s, %v", st, err)
+	}
+}
+
+func TestUnknownStationPolicy(t *testing.T) {
+	stops := []scheduledStopPoint{
+		newStopPoint("70321", "Gilroy"),
+		newStopPoint("70322", "Gilroy"),
+		newStopPoint("70331", "Pajaro"),
+		newStopPoint("70332", "Pajaro"),
+	}
+
+	t.Run("Fail", func(t *testing.T) {
+		if _, err := buildStations(stops, UnknownStationFail, newStationRegistry()); err == nil {
+			t.Fatalf("buildStations improperly succeeded with an unknown station")
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		r := newStationRegistry()
+		stations, err := buildStations(stops, UnknownStationSkip, r)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(stations) != 1 {
+			t.Fatalf("Incorrect number of stations. Expected %d, received %d", 1, len(stations))
+		}
+		if _, ok := r.find("Pajaro"); ok {
+			t.Fatalf("A skipped station was registered")
+		}
+	})
+
+	t.Run("Warn", func(t *testing.T) {
+		r := newStationRegistry()
+		stations, err := buildStations(stops, UnknownStationWarn, r)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		st, err := r.parse("Pajaro")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if r.name(st) != "Pajaro" || stations[st] == nil || stations[st].northCode != "70331" || stations[st].southCode != "70332" {
+			t.Fatalf("Unexpected station info for %s: %v", st, stations[st])
+		}
+
+		// the same name gets the same station
+		again, err := buildStations(stops, UnknownStationWarn, r)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, ok := again[st]; !ok {
+			t.Fatalf("The station was registered again")
+		}
+	})
+}
+
+func TestRegistryOrder(t *testing.T) {
+	c := New(fakeKey)
+	r := newStationRegistry()
+	stations, err := buildStations([]scheduledStopPoint{
+		newStopPoint("70011", "San Francisco"),
+		newStopPoint("70012", "San Francisco"),
+		newStopPoint("70010", "Mission Bay"),
+		newStopPoint("70014", "Mission Bay"),
+		newStopPoint("70021", "22nd Street"),
+		newStopPoint("70022", "22nd Street"),
+		newStopPoint("70321", "Gilroy"),
+		newStopPoint("70322", "Gilroy"),
+	}, UnknownStationWarn, r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	missionBay, _ := r.find("Mission Bay")
+
+	// without a timetable the new station is at the south end
+	if err := c.update(func(d *Dataset) error {
+		d.stations = stations
+		d.registry = r
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp := []Station{StationSanFrancisco, Station22ndStreet, StationGilroy, missionBay}
+	assertStations(t, exp, c.Dataset().Stations())
+
+	// the routes place it on the line, even if one disagrees
+	if err := c.update(func(d *Dataset) error {
+		d.patterns = [][]string{
+			{"70012", "70014", "70022", "70322"},
+			{"70011", "70010", "70021"},
+			{"70010", "70011"},
+			{"70014", "70022"},
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	exp = []Station{StationSanFrancisco, missionBay, Station22ndStreet, StationGilroy}
+	assertStations(t, exp, c.Dataset().Stations())
+
+	all := c.Dataset().registry.stations()
+	if i := indexOfStation(all, missionBay); i != 1 || len(all) != len(knownStations)+1 {
+		t.Fatalf("Unexpected place of the new station in the registry: %d of %d", i, len(all))
+	}
+	if dir, err := c.GetDirectionFromSrcToDst(Station22ndStreet, missionBay); err != nil || dir != North {
+		t.Fatalf("Unexpected direction from 22nd Street to Mission Bay: %s, %v", dir, err)
+	}
+
+	// the dataset the registry was copied from is left as it was
+	if _, ok := r.placed[missionBay]; ok {
+		t.Fatalf("Placing the station modified the registry of an older dataset")
+	}
+}
+
+func TestRegistryPerClient(t *testing.T) {
+	// each client registers the stop it doesn't know as the same Station
+	load := func(name string) *CaltrainClient {
+		c := New(fakeKey)
+		err := c.update(func(d *Dataset) error {
+			r := d.registry.clone()
+			stations, err := buildStations([]scheduledStopPoint{
+				newStopPoint("70011", "San Francisco"),
+				newStopPoint("70012", "San Francisco"),
+				newStopPoint("70331", name),
+				newStopPoint("70332", name),
+			}, UnknownStationWarn, r)
+			d.stations, d.registry = stations, r
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return c
+	}
+	a := load("Pajaro")
+	b := load("Salinas")
+
+	pajaro, err := a.ParseStation("Pajaro")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	salinas, err := b.ParseStation("Salinas")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pajaro != salinas {
+		t.Fatalf("The clients numbered their stations differently: %d, %d", pajaro, salinas)
+	}
+	if a.StationName(pajaro) != "Pajaro" || b.StationName(salinas) != "Salinas" {
+		t.Fatalf("Unexpected names: %s, %s", a.StationName(pajaro), b.StationName(salinas))
+	}
+	if _, err := a.ParseStation("Salinas"); err == nil {
+		t.Fatalf("A station registered by one client was parsed by another")
+	}
+	if _, err := ParseStation("Pajaro"); err == nil {
+		t.Fatalf("A registered station was parsed without a client")
+	}
+	assertStations(t, []Station{StationSanFrancisco, pajaro}, a.Dataset().Stations())
+	if all := GetStations(); len(all) != len(knownStations) {
+		t.Fatalf("Unexpected number of well known stations: %d", len(all))
+	}
+	if _, err := GetDirectionFromSrcToDst(StationSanFrancisco, pajaro); err == nil {
+		t.Fatalf("A direction was found for a registered station without a client")
+	}
+}
+
+func assertStations(t *testing.T, exp, received []Station) {
+	t.Helper()
+	if len(exp) != len(received) {
+		t.Fatalf("Unexpected stations\nExpected: %v\nReceived: %v", exp, received)
+	}
+	for i := range exp {
+		if exp[i] != received[i] {
+			t.Fatalf("Unexpected stations\nExpected: %v\nReceived: %v", exp, received)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package caltrain
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resolver.go contains the station name resolver. Riders and feeds spell the
+// stations many ways ("SF", "4th and King", "S. San Francisco", typos), so
+// names are matched against the registered names and aliases by exact match,
+// then by prefix, then by edit distance
+
+const (
+	// parseConfidence is the lowest confidence that ParseStation accepts
+	parseConfidence = 0.7
+	// parseMargin is how much more confident the best match must be than the
+	// next one for ParseStation to accept it
+	parseMargin = 0.1
+	// maxSuggestions is the number of matches suggested in a
+	// StationNotFoundError
+	maxSuggestions = 3
+)
+
+// StationMatch is a candidate station for a name
+type StationMatch struct {
+	Station    Station // matched station
+	Name       string  // name of the matched station
+	Confidence float64 // from 0 to 1, where 1 is an exact match
+}
+
+// StationNotFoundError is returned when a name is not a recognized station,
+// or matches more than one station equally well
+type StationNotFoundError struct {
+	Name        string
+	Suggestions []StationMatch
+}
+
+func (e *StationNotFoundError) Error() string {
+	if len(e.Suggestions) == 0 {
+		return fmt.Sprintf("%s is not a recognized station", e.Name)
+	}
+	names := make([]string, len(e.Suggestions))
+	for i, m := range e.Suggestions {
+		names[i] = m.Name
+	}
+	return fmt.Sprintf("%s is not a recognized station, did you mean %s?", e.Name, strings.Join(names, ", "))
+}
+
+// stationAbbreviations are expanded in the names being matched
+var stationAbbreviations = map[string]string{
+	"s":   "south",
+	"so":  "south",
+	"st":  "street",
+	"ave": "avenue",
+	"av":  "avenue",
+	"mt":  "mountain",
+	"mtn": "mountain",
+	"&":   "and",
+}
+
+// stationNoise are words of stop names that don't identify the station
+var stationNoise = map[string]bool{
+	"caltrain":   true,
+	"station":    true,
+	"northbound": true,
+	"southbound": true,
+}
+
+// matchKey returns the form of a name that the resolver compares. It is
+// lowercase, without punctuation or noise words, and with the abbreviations
+// expanded
+func matchKey(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ',', '\'', '-', '_', '/', '(', ')':
+			return ' '
+		}
+		return r
+	}, strings.ToLower(strings.ReplaceAll(name, "&", " & ")))
+	words := []string{}
+	for _, w := range strings.Fields(name) {
+		if stationNoise[w] {
+			continue
+		}
+		if full, ok := stationAbbreviations[w]; ok {
+			w = full
+		}
+		words = append(words, w)
+	}
+	return strings.Join(words, " ")
+}
+
+// ResolveStation returns the well known stations that name could refer to,
+// best first. A name or alias matches with a confidence of 1, the start of
+// one matches with less confidence the less of it is given, and a misspelling
+// matches with less confidence the more edits it takes. It returns an empty
+// slice if nothing matches
+func ResolveStation(name string) []StationMatch {
+	return newStationRegistry().resolve(name)
+}
+
+// ResolveStation returns the stations that name could refer to, the same as
+// the ResolveStation function except the stations registered from the stops
+// feed are also matched
+func (d *Dataset) ResolveStation(name string) []StationMatch {
+	return d.registry.resolve(name)
+}
+
+// ResolveStation returns the stations that name could refer to, including
+// the stations registered from the stops feed
+func (c *CaltrainClient) ResolveStation(name string) []StationMatch {
+	return c.dataset().ResolveStation(name)
+}
+
+// resolve returns the matches for name against every registered name and
+// alias, with the best match of each station
+func (r *stationRegistry) resolve(name string) []StationMatch {
+	q := matchKey(name)
+	if q == "" {
+		return []StationMatch{}
+	}
+
+	best := make(map[Station]StationMatch)
+	score := func(key string, st Station) {
+		if m, ok := scoreStation(q, matchKey(key)); ok && m > best[st].Confidence {
+			best[st] = StationMatch{Station: st, Name: r.name(st), Confidence: m}
+		}
+	}
+	for key, st := range knownLookup {
+		score(key, st)
+	}
+	for key, st := range r.lookup {
+		score(key, st)
+	}
+	position := make(map[Station]int, len(r.order))
+	for i, st := range r.order {
+		position[st] = i
+	}
+
+	ret := make([]StationMatch, 0, len(best))
+	for _, m := range best {
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Confidence != ret[j].Confidence {
+			return ret[i].Confidence > ret[j].Confidence
+		}
+		return position[ret[i].Station] < position[ret[j].Station]
+	})
+	return ret
+}
+
+// scoreStation returns the confidence that the query q refers to the key of a
+// station name. Both are match keys
+func scoreStation(q, key string) (float64, bool) {
+	if key == "" {
+		return 0, false
+	}
+	if q == key {
+		return 1, true
+	}
+	if len(q) >= 2 && strings.HasPrefix(key, q) {
+		return 0.6 + 0.3*float64(len(q))/float64(len(key)), true
+	}
+	d := editDistance(q, key)
+	if d*3 <= len(key) {
+		return 0.9 - float64(d)/float64(len(key)), true
+	}
+	return 0, false
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// match returns the station that name refers to, if one match is confident
+// enough and clearly better than the others
+func (r *stationRegistry) match(name string) (Station, error) {
+	matches := r.resolve(name)
+	if len(matches) > 0 && matches[0].Confidence >= parseConfidence &&
+		(len(matches) == 1 || matches[0].Confidence-matches[1].Confidence >= parseMargin) {
+		return matches[0].Station, nil
+	}
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+	return 0, &StationNotFoundError{Name: name, Suggestions: matches}
+}
@@ -0,0 +1,105 @@
+package caltrain
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParseStationFuzzy(t *testing.T) {
+	tests := []struct {
+		name string
+		exp  Station
+	}{
+		{name: "SF", exp: StationSanFrancisco},
+		{name: "4th and King", exp: StationSanFrancisco},
+		{name: "4th & King", exp: StationSanFrancisco},
+		{name: "Diridon", exp: StationSanJose},
+		{name: "Cal Ave", exp: StationCalAve},
+		{name: "S. San Francisco", exp: StationSouthSF},
+		{name: "22nd St.", exp: Station22ndStreet},
+		{name: "Mtn View", exp: StationMountainView},
+		{name: "Mountain View Caltrain", exp: StationMountainView},
+		{name: "Millb", exp: StationMillbrae},
+		{name: "Hilsdale", exp: StationHillsdale},
+		{name: "Sunyvale", exp: StationSunnyvale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := ParseStation(tt.name)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if st != tt.exp {
+				t.Fatalf("Unexpected station. Expected %s, received %s", tt.exp, st)
+			}
+		})
+	}
+}
+
+func TestParseStationSuggestions(t *testing.T) {
+	tests := []struct {
+		name    string
+		suggest []Station
+	}{
+		{name: "San", suggest: []Station{StationSanJose, StationSanBruno, StationSanMateo}},
+		{name: "Oakland", suggest: []Station{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseStation(tt.name)
+			var notFound *StationNotFoundError
+			if !errors.As(err, &notFound) {
+				t.Fatalf("Expected a StationNotFoundError, received %v", err)
+			}
+			suggested := []Station{}
+			for _, m := range notFound.Suggestions {
+				suggested = append(suggested, m.Station)
+			}
+			assertStations(t, tt.suggest, suggested)
+		})
+	}
+}
+
+func TestResolveStation(t *testing.T) {
+	matches := ResolveStation("san jose")
+	if len(matches) == 0 || matches[0].Station != StationSanJose || matches[0].Name != "San Jose Diridon" || matches[0].Confidence != 1 {
+		t.Fatalf("Unexpected best match for an alias: %v", matches)
+	}
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Confidence > matches[i-1].Confidence {
+			t.Fatalf("Matches are not ranked: %v", matches)
+		}
+	}
+
+	prefix := ResolveStation("Mill")
+	typo := ResolveStation("Milbrae")
+	if len(prefix) != 1 || len(typo) != 1 || prefix[0].Station != StationMillbrae || typo[0].Station != StationMillbrae {
+		t.Fatalf("Unexpected matches for Millbrae: %v, %v", prefix, typo)
+	}
+	if prefix[0].Confidence >= 1 || typo[0].Confidence >= 1 {
+		t.Fatalf("Inexact matches have full confidence: %v, %v", prefix, typo)
+	}
+
+	if matches := ResolveStation(" Caltrain "); len(matches) != 0 {
+		t.Fatalf("Unexpected matches for a name without a station: %v", matches)
+	}
+}
+
+func TestGetTrainsUnknownStop(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/parseHillsdaleSouth.json")
+	if err != nil {
+		t.Fatalf("Could not read test data: %v", err)
+	}
+	// the first train stops at a station that doesn't exist
+	data = bytes.Replace(data, []byte("Hillsdale Caltrain"), []byte("Oakland Coliseum Caltrain"), 1)
+
+	trains, err := newLinesDataset(allLines).getTrains(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(trains) != 1 || trains[0].TrainNum != "804" || trains[0].NextStop != StationHillsdale {
+		t.Fatalf("Unexpected trains: %v", trains)
+	}
+}
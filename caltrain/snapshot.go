@@ -0,0 +1,161 @@
+package caltrain
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// snapshot.go contains the helpers that save the static data to a file and
+// load it back, so a process can start without spending its API limit on
+// Initialize
+
+const (
+	// snapshotVersion is bumped whenever the snapshot format changes
+	snapshotVersion       = 1
+	defaultSnapshotMaxAge = 7 * 24 * time.Hour
+)
+
+// SnapshotAgeError is returned by LoadSnapshot when the snapshot is older
+// than the maximum age set with SetSnapshotMaxAge. The snapshot is still
+// loaded, but the data should be refreshed
+type SnapshotAgeError struct {
+	Fetched time.Time     // time the snapshot data was fetched
+	Age     time.Duration // age of the snapshot data when it was loaded
+}
+
+func (s *SnapshotAgeError) Error() string {
+	return fmt.Sprintf("snapshot data is %s old, fetched at %s", s.Age.Round(time.Minute), s.Fetched.Format(time.RFC3339))
+}
+
+// snapshot is the file format of SaveSnapshot
+type snapshot struct {
+	Version    int                         `json:"version"`
+	Fetched    time.Time                   `json:"fetched"`
+	Timetable  map[string][]timetableFrame `json:"timetable"`
+	DayService map[string][]string         `json:"dayService"`
+	Trips      map[string]string           `json:"trips,omitempty"`
+	Stations   []snapshotStation           `json:"stations"`
+	Lines      []Line                      `json:"lines"`
+	Holidays   []time.Time                 `json:"holidays"`
+}
+
+// snapshotStation is the file format of a stationInfo
+type snapshotStation struct {
+	Name      string   `json:"name"`
+	NorthCode string   `json:"northCode"`
+	SouthCode string   `json:"southCode"`
+	Codes     []string `json:"codes,omitempty"`
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+}
+
+// SetSnapshotMaxAge sets the age at which LoadSnapshot returns a
+// SnapshotAgeError. The default is 7 days, zero disables the check
+func (c *CaltrainClient) SetSnapshotMaxAge(maxAge time.Duration) {
+	c.maxAge = maxAge
+}
+
+// LastFetched returns the time that the timetable was last fetched from the
+// API, loaded from a GTFS feed, or the time it was fetched for a loaded
+// snapshot
+func (c *CaltrainClient) LastFetched() time.Time {
+	return c.dataset().fetched
+}
+
+// SaveSnapshot writes the timetable, stations, lines, and holidays to w so
+// they can be restored with LoadSnapshot
+func (c *CaltrainClient) SaveSnapshot(w io.Writer) error {
+	logrus.Debug("Saving snapshot...")
+	d := c.dataset()
+	snap := snapshot{
+		Version:    snapshotVersion,
+		Fetched:    d.fetched,
+		Timetable:  d.timetable,
+		DayService: d.dayService,
+		Trips:      d.trips,
+		Stations:   make([]snapshotStation, 0, len(d.stations)),
+		Lines:      d.lines,
+		Holidays:   d.holidays,
+	}
+	// keep the stations in order so the same data gives the same file
+	for _, st := range d.order {
+		info := d.stations[st]
+		snap.Stations = append(snap.Stations, snapshotStation{
+			Name:      d.StationName(st),
+			NorthCode: info.northCode,
+			SouthCode: info.southCode,
+			Codes:     info.codes,
+			Latitude:  info.latitude,
+			Longitude: info.longitude,
+		})
+	}
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return nil
+}
+
+// LoadSnapshot replaces the timetable, stations, lines, and holidays with a
+// snapshot written by SaveSnapshot. It does not make an API call, so it can
+// be used in place of Initialize. If the snapshot is older than the maximum
+// age, the data is loaded and a SnapshotAgeError is returned
+func (c *CaltrainClient) LoadSnapshot(r io.Reader) error {
+	logrus.Debug("Loading snapshot...")
+	snap := snapshot{}
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d, expected %d", snap.Version, snapshotVersion)
+	}
+	if len(snap.Timetable) == 0 || len(snap.Stations) == 0 || len(snap.Lines) == 0 {
+		return fmt.Errorf("snapshot is missing data: %d lines, %d stations, %d timetables", len(snap.Lines), len(snap.Stations), len(snap.Timetable))
+	}
+
+	if snap.DayService == nil {
+		snap.DayService = make(map[string][]string)
+	}
+
+	err := c.update(func(d *Dataset) error {
+		r := d.registry.clone()
+		stations := make(map[Station]*stationInfo, len(snap.Stations))
+		for _, s := range snap.Stations {
+			st, ok, err := resolveStation(s.Name, c.stationPolicy, r)
+			if err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			stations[st] = &stationInfo{
+				name:      st,
+				northCode: s.NorthCode,
+				southCode: s.SouthCode,
+				codes:     s.Codes,
+				latitude:  s.Latitude,
+				longitude: s.Longitude,
+			}
+		}
+		d.lines = snap.Lines
+		d.stations = stations
+		d.registry = r
+		d.holidays = snap.Holidays
+		d.timetable = snap.Timetable
+		d.dayService = snap.DayService
+		d.patterns = nil
+		d.trips = snap.Trips
+		d.fetched = snap.Fetched
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	if age := c.clock.Now().Sub(snap.Fetched); c.maxAge > 0 && age > c.maxAge {
+		return &SnapshotAgeError{Fetched: snap.Fetched, Age: age}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package caltrain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mock := clock.NewMock()
+	mock.Set(time.Date(2019, time.November, 22, 12, 0, 0, 0, time.UTC))
+	src := New(fakeKey)
+	src.clock = mock
+	r := gtfsFixture(t)
+	if err := src.LoadGTFSReader(r, r.Size()); err != nil {
+		t.Fatalf("Unexpected error loading GTFS feed: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := src.SaveSnapshot(buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c := New(fakeKey)
+	c.clock = mock
+	c.APIClient = &apiClientMock{GetResult: []byte("the API should not be called")}
+	if err := c.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !c.LastFetched().Equal(mock.Now()) {
+		t.Fatalf("Unexpected fetch time. Expected %s, received %s", mock.Now(), c.LastFetched())
+	}
+	if len(c.AllLines()) != 2 || len(c.Holidays()) != 2 || len(c.dataset().stations) != 6 {
+		t.Fatalf("Snapshot did not restore the data: %d lines, %d holidays, %d stations", len(c.AllLines()), len(c.Holidays()), len(c.dataset().stations))
+	}
+	if exp, hd := src.dataset().stations[StationHillsdale], c.dataset().stations[StationHillsdale]; !reflect.DeepEqual(hd, exp) {
+		t.Fatalf("Unexpected station info for Hillsdale\nExpected: %v\nReceived: %v", exp, hd)
+	}
+
+	date := time.Date(2019, time.November, 22, 0, 0, 0, 0, c.tz)
+	exp, err := src.GetTrainsBetweenStationsForDate(ctx, StationSanJose, StationSanFrancisco, date)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	routes, err := c.GetTrainsBetweenStationsForDate(ctx, StationSanJose, StationSanFrancisco, date)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	assertTrainNums(t, trainNums(exp), routes)
+
+	// the same data gives the same snapshot
+	again := &bytes.Buffer{}
+	if err := c.SaveSnapshot(again); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if again.String() != buf.String() {
+		t.Fatalf("Snapshot changed after a round trip")
+	}
+
+	// an old snapshot is loaded but flagged
+	mock.Add(8 * 24 * time.Hour)
+	c = New(fakeKey)
+	c.clock = mock
+	err = c.LoadSnapshot(bytes.NewReader(buf.Bytes()))
+	var ageErr *SnapshotAgeError
+	if !errors.As(err, &ageErr) {
+		t.Fatalf("Expected a SnapshotAgeError, received %v", err)
+	}
+	if ageErr.Age != 8*24*time.Hour {
+		t.Fatalf("Unexpected age. Expected %s, received %s", 8*24*time.Hour, ageErr.Age)
+	}
+	if len(c.AllLines()) != 2 {
+		t.Fatalf("Old snapshot was not loaded")
+	}
+
+	c.SetSnapshotMaxAge(0)
+	if err := c.LoadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Unexpected error with the age check disabled: %v", err)
+	}
+}
+
+func TestLoadSnapshotErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "NotJSON", data: "not json"},
+		{name: "Version", data: `{"version": 99}`},
+		{name: "Empty", data: `{"version": 1}`},
+		{name: "Station", data: `{"version": 1, "timetable": {"Local": []}, "lines": [{"Id": "Local"}], "stations": [{"name": "Nowhere"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(fakeKey)
+			if err := c.LoadSnapshot(strings.NewReader(tt.data)); err == nil {
+				t.Fatalf("LoadSnapshot improperly succeeded")
+			}
+			if len(c.AllLines()) != 0 {
+				t.Fatalf("Failed snapshot modified the client")
+			}
+		})
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
-// timetable.go contains helpers relating to the timetable. All functions must
-// have ttLock read locked before calling
+// timetable.go contains helpers relating to the timetable. They are methods of
+// the Dataset so that a query reads a single version of the timetable
 
 // TrainNotFoundError is returned when a provided train number does not exist
 // in the current timetable.
@@ -23,13 +23,13 @@ func (t *TrainNotFoundError) Error() string {
 
 // getTimetableForStation returns a list of trains that stop at a given station
 // code and directions
-func (c *CaltrainClient) getTimetableForStation(stationCode string, dir Direction, day time.Weekday) ([]timetableRouteJourney, error) {
+func (d *Dataset) getTimetableForStation(stationCode string, dir Direction, day time.Weekday) ([]timetableRouteJourney, error) {
 	allJourneys := []timetableRouteJourney{}
 
 	weekday := strings.ToLower(day.String())
 
-	for lineId, ttArray := range c.timetable {
-		line, err := c.getLine(lineId)
+	for lineId, ttArray := range d.timetable {
+		line, err := d.getLine(lineId)
 		if err != nil {
 			logrus.Errorf("failed to get line!")
 			line = Line{Id: "unknown", Name: "Unknown"}
@@ -38,7 +38,7 @@ func (c *CaltrainClient) getTimetableForStation(stationCode string, dir Directio
 		}
 		for _, frame := range ttArray {
 			// Check the day reference
-			if !c.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
+			if !d.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
 				continue
 			}
 			// Checkc the direction
@@ -60,10 +60,10 @@ func (c *CaltrainClient) getTimetableForStation(stationCode string, dir Directio
 
 // getRouteForTrain returns a TimetableRouteJourney and the route's line for
 // the given train number
-func (c *CaltrainClient) getRouteForTrain(trainNum string) (timetableRouteJourney, error) {
+func (d *Dataset) getRouteForTrain(trainNum string) (timetableRouteJourney, error) {
 	// TODO: the train number has metadata on the line type, and the day, it
 	// could save time to use that to limit the search
-	for line, ttArray := range c.timetable {
+	for line, ttArray := range d.timetable {
 		for _, frame := range ttArray {
 			journeys := frame.VehicleJourneys.TimetableRouteJourney
 			for _, journey := range journeys {
@@ -79,8 +79,8 @@ func (c *CaltrainClient) getRouteForTrain(trainNum string) (timetableRouteJourne
 
 // getTrainRoutesBetweenStations returns a slice of routes from src to dst on a
 // given weekday
-func (c *CaltrainClient) getTrainRoutesBetweenStations(src, dst Station, day time.Weekday) ([]timetableRouteJourney, error) {
-	sCode, dCode, err := c.getRouteCodes(src, dst)
+func (d *Dataset) getTrainRoutesBetweenStations(src, dst Station, day time.Weekday) ([]timetableRouteJourney, error) {
+	sCode, dCode, err := d.getRouteCodes(src, dst)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get station codes: %w", err)
 	}
@@ -88,10 +88,10 @@ func (c *CaltrainClient) getTrainRoutesBetweenStations(src, dst Station, day tim
 	weekday := strings.ToLower(day.String())
 
 	routes := []timetableRouteJourney{}
-	for line, ttArray := range c.timetable {
+	for line, ttArray := range d.timetable {
 		for _, frame := range ttArray {
 			// Check the day reference
-			if !c.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
+			if !d.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
 				continue
 			}
 
@@ -109,23 +109,23 @@ func (c *CaltrainClient) getTrainRoutesBetweenStations(src, dst Station, day tim
 
 // getTrainRoutesForAllStops
 // TODO: unit test this
-func (c *CaltrainClient) getTrainRoutesForAllStops(stops []Station, dir Direction, day time.Weekday) ([]timetableRouteJourney, error) {
+func (d *Dataset) getTrainRoutesForAllStops(stops []Station, dir Direction, day time.Weekday) ([]timetableRouteJourney, error) {
 	codes := make([]string, len(stops))
 	for i, st := range stops {
-		c, err := c.getStationCode(st, dir)
+		code, err := d.getStationCode(st, dir)
 		if err != nil {
 			return nil, err
 		}
-		codes[i] = c
+		codes[i] = code
 	}
 
 	weekday := strings.ToLower(day.String())
 
 	routes := []timetableRouteJourney{}
-	for line, ttArray := range c.timetable {
+	for line, ttArray := range d.timetable {
 		for _, frame := range ttArray {
 			// Check the day reference
-			if !c.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
+			if !d.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
 				continue
 			}
 
@@ -142,13 +142,13 @@ func (c *CaltrainClient) getTrainRoutesForAllStops(stops []Station, dir Directio
 }
 
 // isForToday returns true if the frame is for the day
-func (c *CaltrainClient) isForToday(day string, ref string) bool {
-	weekdays, ok := c.dayService[ref]
+func (d *Dataset) isForToday(day string, ref string) bool {
+	weekdays, ok := d.dayService[ref]
 	if !ok {
 		return false
 	}
-	for _, d := range weekdays {
-		if d == day {
+	for _, wd := range weekdays {
+		if wd == day {
 			return true
 		}
 	}
@@ -181,3 +181,22 @@ func areStationsInJourney(stops []string, journey timetableRouteJourney) bool {
 	}
 	return true
 }
+
+// getJourneysForDay returns every journey that runs on a given weekday
+func (d *Dataset) getJourneysForDay(day time.Weekday) []timetableRouteJourney {
+	weekday := strings.ToLower(day.String())
+
+	journeys := []timetableRouteJourney{}
+	for line, ttArray := range d.timetable {
+		for _, frame := range ttArray {
+			if !d.isForToday(weekday, frame.FrameValidityConditions.AvailabilityCondition.DayTypes.DayTypeRef.Ref) {
+				continue
+			}
+			for _, journey := range frame.VehicleJourneys.TimetableRouteJourney {
+				journey.Line = line
+				journeys = append(journeys, journey)
+			}
+		}
+	}
+	return journeys
+}
@@ -12,7 +12,7 @@ func TestGetTimetableForStation(t *testing.T) {
 	// Load the timetable for only the bullet schedule
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -26,11 +26,7 @@ func TestGetTimetableForStation(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	tests := []struct {
 		station  Station
@@ -47,13 +43,13 @@ func TestGetTimetableForStation(t *testing.T) {
 	for _, tt := range tests {
 		name := tt.station.String() + "/" + tt.dir.String() + "/" + tt.day.String()
 		t.Run(name, func(t *testing.T) {
-			code, err := c.getStationCode(StationHillsdale, tt.dir)
+			code, err := c.dataset().getStationCode(StationHillsdale, tt.dir)
 			if err != nil {
 				t.Fatalf("failed to get station code: %v", err)
 			}
 
 			// Now we know what to expect
-			journeys, err := c.getTimetableForStation(code, tt.dir, tt.day)
+			journeys, err := c.dataset().getTimetableForStation(code, tt.dir, tt.day)
 			if err != nil {
 				t.Fatalf("failed to get timetable for station: %v", err)
 			}
@@ -69,7 +65,7 @@ func TestGetTrainRoutesBetweenStations(t *testing.T) {
 	// Load the timetable for only the bullet schedule
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -83,11 +79,7 @@ func TestGetTrainRoutesBetweenStations(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	tests := []struct {
 		src  Station
@@ -108,7 +100,7 @@ func TestGetTrainRoutesBetweenStations(t *testing.T) {
 		name := tt.src.String() + "_" + tt.dst.String()
 		t.Run(name, func(t *testing.T) {
 			// test north
-			d1, err := c.getTrainRoutesBetweenStations(tt.src, tt.dst, tt.day)
+			d1, err := c.dataset().getTrainRoutesBetweenStations(tt.src, tt.dst, tt.day)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get train routes for %s: %v", name, err)
 			} else if err == nil && tt.err != nil {
@@ -119,7 +111,7 @@ func TestGetTrainRoutesBetweenStations(t *testing.T) {
 			}
 
 			// test south
-			d2, err := c.getTrainRoutesBetweenStations(tt.dst, tt.src, tt.day)
+			d2, err := c.dataset().getTrainRoutesBetweenStations(tt.dst, tt.src, tt.day)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get train routes for %s: %v", name, err)
 			} else if err == nil && tt.err != nil {
@@ -136,7 +128,7 @@ func TestGetRouteForTrain(t *testing.T) {
 	// Load the timetable for only the bullet schedule
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -150,11 +142,7 @@ func TestGetRouteForTrain(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	tests := []struct {
 		train string
@@ -168,7 +156,7 @@ func TestGetRouteForTrain(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.train, func(t *testing.T) {
-			r, err := c.getRouteForTrain(tt.train)
+			r, err := c.dataset().getRouteForTrain(tt.train)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get train info for %s: %v", tt.train, err)
 			} else if err == nil && tt.err != nil {
@@ -185,7 +173,7 @@ func TestGetTrainRoutesForAllStops(t *testing.T) {
 	// Load the timetable for only the bullet schedule
 	ctx := context.Background()
 	c := New(fakeKey)
-	c.lines = allLines
+	setLines(c, allLines)
 	m := &apiClientMock{}
 	m.GetResultFilePath = "testdata/bulletSchedule.json"
 	c.APIClient = m
@@ -199,11 +187,7 @@ func TestGetTrainRoutesForAllStops(t *testing.T) {
 	}
 	// c.UpdateTimeTable currently populates each line with bulletSchedule.
 	// remove the other instances
-	delete(c.timetable, "Limited")
-	delete(c.timetable, "Limited A")
-	delete(c.timetable, "Limited B")
-	delete(c.timetable, "Local")
-	delete(c.timetable, "Special")
+	keepTimetable(c, "Bullet")
 
 	tests := []struct {
 		stops []Station
@@ -222,7 +206,7 @@ func TestGetTrainRoutesForAllStops(t *testing.T) {
 		name := fmt.Sprintf("test %d", i)
 		t.Run(name, func(t *testing.T) {
 			// test north
-			d1, err := c.getTrainRoutesForAllStops(tt.stops, North, tt.day)
+			d1, err := c.dataset().getTrainRoutesForAllStops(tt.stops, North, tt.day)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get train routes for %s: %v", name, err)
 			} else if err == nil && tt.err != nil {
@@ -233,7 +217,7 @@ func TestGetTrainRoutesForAllStops(t *testing.T) {
 			}
 
 			// test south
-			d2, err := c.getTrainRoutesForAllStops(tt.stops, South, tt.day)
+			d2, err := c.dataset().getTrainRoutesForAllStops(tt.stops, South, tt.day)
 			if err != nil && tt.err == nil {
 				t.Fatalf("Failed to get train routes for %s: %v", name, err)
 			} else if err == nil && tt.err != nil {
@@ -253,7 +237,10 @@ func TestIsInDayRef(t *testing.T) {
 		"8006": {"saturday", "sunday"},
 		"8007": {"saturday"},
 	}
-	c.dayService = services
+	c.update(func(d *Dataset) error {
+		d.dayService = services
+		return nil
+	})
 
 	tests := []struct {
 		day string
@@ -275,7 +262,7 @@ func TestIsInDayRef(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.day+"/"+tt.ref, func(t *testing.T) {
-			val := c.isForToday(tt.day, tt.ref)
+			val := c.dataset().isForToday(tt.day, tt.ref)
 			if val != tt.exp {
 				t.Fatalf("isForToday unexpectedly returned %t", val)
 			}
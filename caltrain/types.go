@@ -14,6 +14,15 @@ type TrainStatus struct {
 	Delay     time.Duration // Amount of time behind schedule
 	Arrival   time.Time     // Expected arrival time at NextStop
 	NextStop  Station       // Name of the station that the train will stop at next
+	Vehicle   string        // Vehicle reference reported by the feed
+	Location  Location      // GPS location of the train, zero if not reported
+	Bearing   float64       // Heading in degrees clockwise from North, zero if not reported
+	Occupancy Occupancy     // How crowded the train is, OccupancyUnknown if not reported
+}
+
+// HasLocation returns true if the feed reported the GPS location of the train
+func (t TrainStatus) HasLocation() bool {
+	return t.Location != Location{}
 }
 
 // Route contains metadata for a given train and the stops that it will make on
@@ -24,6 +33,7 @@ type Route struct {
 	Line      Line        // bullet, limited, etc.
 	NumStops  int         // Total number of stops on this route
 	Stops     []TrainStop // Slice of stops on this route
+	Alerts    []Alert     // Active service alerts that affect this route, set by WithAlerts
 }
 
 // TrainStop is a single stop on a route
@@ -34,19 +44,104 @@ type TrainStop struct {
 	Departure time.Time // scheduled departure time from this station
 }
 
+// LiveRoute is a Route merged with the live status of its train
+type LiveRoute struct {
+	Route   *Route        // scheduled route
+	State   LiveState     // tracking state of the train
+	Delay   time.Duration // current delay of the train
+	Stops   []LiveStop    // stops on the route with live predictions
+	Updated time.Time     // time that the live status was fetched
+}
+
+// LiveStop is a TrainStop merged with the live status of its train
+type LiveStop struct {
+	TrainStop
+	ExpectedArrival   time.Time     // expected arrival time at this station
+	ExpectedDeparture time.Time     // expected departure time from this station
+	Delay             time.Duration // amount of time behind schedule at this station
+	Passed            bool          // true if the train has left this station
+	Predicted         bool          // true if the live feed has a prediction for this station
+}
+
+// A LiveState specifies the live tracking state of a train
+type LiveState int
+
+const (
+	// Scheduled trains have not started their route
+	Scheduled LiveState = iota
+	// Tracked trains are reported in the live feed
+	Tracked
+	// NotTracked trains should be running but are missing from the live
+	// feed
+	NotTracked
+	// Completed trains have finished their route
+	Completed
+	// Cancelled trains are reported as cancelled by the live feed
+	Cancelled
+)
+
+var liveStates = [...]string{
+	"Scheduled",
+	"Tracked",
+	"Not Tracked",
+	"Completed",
+	"Cancelled",
+}
+
+// String returns the string name of the live state
+func (s LiveState) String() string {
+	if Scheduled <= s && s <= Cancelled {
+		return liveStates[s]
+	}
+	return fmt.Sprintf("unknown live state %d", s)
+}
+
+// Itinerary is a planned trip between two stations that can be made up of
+// several trains
+type Itinerary struct {
+	Legs            []Leg         // trains to take, in order
+	Transfers       []Transfer    // changes between the legs, in order
+	Departure       time.Time     // departure time from the source station
+	Arrival         time.Time     // arrival time at the destination station
+	Duration        time.Duration // total travel time, including transfers
+	MinTransferTime time.Duration // shortest connection between two legs
+}
+
+// Leg is a single train ridden as part of an Itinerary
+type Leg struct {
+	TrainNum  string    // Train reference number
+	Direction Direction // Direction the train is travelling: North or South
+	Line      Line      // bullet, limited, etc.
+	From      Station   // station to board the train
+	To        Station   // station to get off the train
+	Departure time.Time // scheduled departure time from From
+	Arrival   time.Time // scheduled arrival time at To
+}
+
+// Transfer is a change between two trains as part of an Itinerary
+type Transfer struct {
+	Station Station       // station where the change is made
+	Wait    time.Duration // time between arriving and departing the station
+}
+
 type stationInfo struct {
 	name      Station
-	northCode string
-	southCode string
+	northCode string   // code of the northbound platform
+	southCode string   // code of the southbound platform
+	codes     []string // every stop code of the station, sorted
 	latitude  float64
 	longitude float64
 }
 
-// A Station specifies a recognized Caltrain station
+// A Station specifies a recognized Caltrain station. The constants are the
+// well known stations, and stations that are not known are registered by the
+// client that loads them from the stops feed, see UnknownStationPolicy. A
+// registered Station is only meaningful to the client that registered it
 type Station int
 
 // The constant ordering is also the station order from north to south along
-// the line
+// the line. The Stations method of a Dataset returns the order of its
+// timetable, which includes the registered stations
 const (
 	StationSanFrancisco Station = iota // "San Francisco"
 	Station22ndStreet                  // "22nd Street"
@@ -82,94 +177,6 @@ const (
 	StationGilroy                      // "Gilroy"
 )
 
-var stationsMap = map[Station]string{
-	StationSanFrancisco: "San Francisco",
-	Station22ndStreet:   "22nd Street",
-	StationBayshore:     "Bayshore",
-	StationSouthSF:      "South San Francisco",
-	StationSanBruno:     "San Bruno",
-	StationMillbrae:     "Millbrae",
-	StationBroadway:     "Broadway",
-	StationBurlingame:   "Burlingame",
-	StationSanMateo:     "San Mateo",
-	StationHaywardPark:  "Hayward Park",
-	StationHillsdale:    "Hillsdale",
-	StationBelmont:      "Belmont",
-	StationSanCarlos:    "San Carlos",
-	StationRedwoodCity:  "Redwood City",
-	StationAtherton:     "Atherton",
-	StationMenloPark:    "Menlo Park",
-	StationPaloAlto:     "Palo Alto",
-	StationStanford:     "Stanford",
-	StationCalAve:       "California Ave",
-	StationSanAntonio:   "San Antonio",
-	StationMountainView: "Mountain View",
-	StationSunnyvale:    "Sunnyvale",
-	StationLawrence:     "Lawrence",
-	StationSantaClara:   "Santa Clara",
-	StationCollegePark:  "College Park",
-	StationSanJose:      "San Jose Diridon",
-	StationTamien:       "Tamien",
-	StationCapitol:      "Capitol",
-	StationBlossomHill:  "Blossom Hill",
-	StationMorganHill:   "Morgan Hill",
-	StationSanMartin:    "San Martin",
-	StationGilroy:       "Gilroy",
-}
-
-var stationSlice = []Station{
-	StationSanFrancisco,
-	Station22ndStreet,
-	StationBayshore,
-	StationSouthSF,
-	StationSanBruno,
-	StationMillbrae,
-	StationBroadway,
-	StationBurlingame,
-	StationSanMateo,
-	StationHaywardPark,
-	StationHillsdale,
-	StationBelmont,
-	StationSanCarlos,
-	StationRedwoodCity,
-	StationAtherton,
-	StationMenloPark,
-	StationPaloAlto,
-	StationStanford,
-	StationCalAve,
-	StationSanAntonio,
-	StationMountainView,
-	StationSunnyvale,
-	StationLawrence,
-	StationSantaClara,
-	StationCollegePark,
-	StationSanJose,
-	StationTamien,
-	StationCapitol,
-	StationBlossomHill,
-	StationMorganHill,
-	StationSanMartin,
-	StationGilroy,
-}
-
-// String returns the string name of the station. String values are show in
-// the Station constant definition
-func (s Station) String() string {
-	return stationsMap[s]
-}
-
-// ParseStation returns a Station from the string passed in. If the string is
-// not a recognized station, it will return an error
-func ParseStation(s string) (Station, error) {
-	l := strings.ToLower(s)
-	for k, v := range stationsMap {
-		if strings.ToLower(v) == l {
-			return k, nil
-		}
-	}
-	return 0, fmt.Errorf("%s is not a recognized station", s)
-}
-
 // A Direction specifies a Caltrain route direction (North or South)
 type Direction int
 
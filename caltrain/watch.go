@@ -0,0 +1,330 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watch.go contains a watcher that polls the live status of the trains and
+// streams the changes as events
+
+const (
+	defaultWatchInterval = 2 * time.Minute
+	// 511.org allows 60 requests an hour, polling once a minute would use
+	// the whole budget on a single watcher
+	minWatchInterval      = 2 * time.Minute
+	maxWatchBackoff       = time.Hour
+	defaultWatchThreshold = 5 * time.Minute
+	defaultDelayChange    = 2 * time.Minute
+)
+
+// A TrainEventType specifies the kind of change reported by a TrainEvent
+type TrainEventType int
+
+const (
+	// TrainDelayed is sent when a train's delay reaches the threshold
+	TrainDelayed TrainEventType = iota
+	// DelayIncreased is sent when a delayed train's delay grows by at least
+	// the change threshold
+	DelayIncreased
+	// DelayDecreased is sent when a delayed train's delay shrinks by at
+	// least the change threshold
+	DelayDecreased
+	// TrainArrived is sent when a train's next stop changes. The event
+	// Station is the station the train arrived at
+	TrainArrived
+	// TrainDisappeared is sent when a train is no longer in the live feed.
+	// The event Train is the last known status of the train
+	TrainDisappeared
+	// WatchError is sent when a poll fails. The event Err is set
+	WatchError
+)
+
+var trainEventTypes = [...]string{
+	"Train Delayed",
+	"Delay Increased",
+	"Delay Decreased",
+	"Train Arrived",
+	"Train Disappeared",
+	"Watch Error",
+}
+
+// String returns the string name of the event type
+func (e TrainEventType) String() string {
+	if TrainDelayed <= e && e <= WatchError {
+		return trainEventTypes[e]
+	}
+	return fmt.Sprintf("unknown train event type %d", e)
+}
+
+// TrainEvent is a change in the live status of a train
+type TrainEvent struct {
+	Type          TrainEventType
+	Train         TrainStatus   // status of the train when the event happened
+	PreviousDelay time.Duration // delay of the train in the last event sent for it
+	Station       Station       // station arrived at, for TrainArrived events
+	Time          time.Time     // time of the poll that produced the event
+	Err           error         // poll error, for WatchError events
+}
+
+// WatchOptions configures Watch. Empty filters match every train
+type WatchOptions struct {
+	Interval       time.Duration // time between polls. Defaults to 2 minutes, minimum 2 minutes
+	DelayThreshold time.Duration // delay for a train to be considered delayed. Defaults to 5 minutes
+	DelayChange    time.Duration // change in delay to report. Defaults to 2 minutes
+	Stations       []Station     // only report trains stopping at these stations
+	Directions     []Direction   // only report trains going in these directions
+	Lines          []Line        // only report trains on these lines
+	Trains         []string      // only report these train numbers
+}
+
+// watchedTrain is the state kept between polls for a single train
+type watchedTrain struct {
+	status   TrainStatus   // status at the train's next stop
+	stops    []Station     // upcoming stops in the live feed
+	reported time.Duration // delay sent in the last delay event
+	delayed  bool          // true if the train has been reported as delayed
+}
+
+// Watch polls the live status of the trains and sends an event on the
+// returned channel every time a train becomes delayed, its delay changes,
+// it arrives at a station or it leaves the feed. Polls are spaced by the
+// options Interval, or further apart to leave headroom in the request budget
+// as described by watchInterval, and back off while the API limit is
+// reached. The channel is closed when ctx is done
+func (c *CaltrainClient) Watch(ctx context.Context, opts WatchOptions) <-chan TrainEvent {
+	if opts.Interval == 0 {
+		opts.Interval = defaultWatchInterval
+	}
+	if opts.Interval < minWatchInterval {
+		opts.Interval = minWatchInterval
+	}
+	if opts.DelayThreshold == 0 {
+		opts.DelayThreshold = defaultWatchThreshold
+	}
+	if opts.DelayChange == 0 {
+		opts.DelayChange = defaultDelayChange
+	}
+
+	events := make(chan TrainEvent, 16)
+	go c.watch(ctx, opts, events)
+	return events
+}
+
+// watchInterval returns the time to wait between polls. When the API client
+// reports its request budget, the polls are spread so that a watcher uses at
+// most half of the requests above the reserve, and they are slowed further
+// while the remaining requests are within the reserve. This leaves headroom
+// for the other calls of the client and for other watchers
+func (c *CaltrainClient) watchInterval(interval time.Duration) time.Duration {
+	s, ok := c.RateLimitStatus()
+	if !ok || s.Limit <= 0 || s.Period <= 0 {
+		return interval
+	}
+	// the GTFS-Realtime feed takes a request for the trip updates and one
+	// for the vehicle positions
+	perPoll := 1
+	if c.liveFeed == GTFSRealtimeFeed {
+		perPoll = 2
+	}
+	share := (s.Limit - s.Reserve) / 2
+	if share < perPoll {
+		share = perPoll
+	}
+	if pace := s.Period * time.Duration(perPoll) / time.Duration(share); pace > interval {
+		interval = pace
+	}
+	if short := s.Reserve + perPoll - s.Remaining; short > 0 {
+		// wait for the budget to refill above the reserve
+		if refill := s.Period * time.Duration(short) / time.Duration(s.Limit); refill > interval {
+			interval = refill
+		}
+	}
+	return interval
+}
+
+// watch is the poll loop of Watch
+func (c *CaltrainClient) watch(ctx context.Context, opts WatchOptions, events chan<- TrainEvent) {
+	defer close(events)
+	send := func(e TrainEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	state := make(map[string]*watchedTrain)
+	wait := c.watchInterval(opts.Interval)
+	for {
+		// the timer is started before the poll so that the interval does not
+		// drift by the length of the request
+		timer := c.clock.Timer(wait)
+		now := c.clock.Now()
+		trains, _, err := c.getLiveTrains(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				timer.Stop()
+				return
+			}
+			var limErr *APILimitError
+			if errors.As(err, &limErr) {
+				wait *= 2
+				if wait > maxWatchBackoff {
+					wait = maxWatchBackoff
+				}
+				logrus.Warnf("API limit reached, backing off to %s", wait)
+				timer.Stop()
+				timer = c.clock.Timer(wait)
+			}
+			if !send(TrainEvent{Type: WatchError, Time: now, Err: err}) {
+				timer.Stop()
+				return
+			}
+		} else {
+			wait = c.watchInterval(opts.Interval)
+			for _, e := range diffTrains(state, trains, opts, now) {
+				if !send(e) {
+					timer.Stop()
+					return
+				}
+			}
+		}
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// diffTrains updates state with the latest poll and returns the events for
+// the trains that match the options filters
+func diffTrains(state map[string]*watchedTrain, trains []TrainStatus, opts WatchOptions, now time.Time) []TrainEvent {
+	// the feed can have a status for each upcoming stop of a train, the
+	// earliest one is the train's next stop
+	current := make(map[string]*watchedTrain)
+	order := []string{}
+	for _, t := range trains {
+		w, ok := current[t.TrainNum]
+		if !ok {
+			current[t.TrainNum] = &watchedTrain{status: t, stops: []Station{t.NextStop}}
+			order = append(order, t.TrainNum)
+			continue
+		}
+		w.stops = append(w.stops, t.NextStop)
+		if w.status.Arrival.IsZero() || (!t.Arrival.IsZero() && t.Arrival.Before(w.status.Arrival)) {
+			w.status = t
+		}
+	}
+
+	events := []TrainEvent{}
+	add := func(e TrainEvent, stops []Station) {
+		e.Time = now
+		if watchMatches(opts, e, stops) {
+			events = append(events, e)
+		}
+	}
+
+	for _, num := range order {
+		cur := current[num]
+		prev, seen := state[num]
+		if seen {
+			cur.reported = prev.reported
+			cur.delayed = prev.delayed
+			if cur.status.NextStop != prev.status.NextStop {
+				add(TrainEvent{Type: TrainArrived, Train: cur.status, PreviousDelay: prev.reported, Station: prev.status.NextStop}, nil)
+			}
+		}
+
+		delay := cur.status.Delay
+		switch {
+		case !cur.delayed && delay >= opts.DelayThreshold:
+			add(TrainEvent{Type: TrainDelayed, Train: cur.status, PreviousDelay: cur.reported}, cur.stops)
+			cur.reported = delay
+			cur.delayed = true
+		case cur.delayed && delay-cur.reported >= opts.DelayChange:
+			add(TrainEvent{Type: DelayIncreased, Train: cur.status, PreviousDelay: cur.reported}, cur.stops)
+			cur.reported = delay
+		case cur.delayed && cur.reported-delay >= opts.DelayChange:
+			add(TrainEvent{Type: DelayDecreased, Train: cur.status, PreviousDelay: cur.reported}, cur.stops)
+			cur.reported = delay
+			cur.delayed = delay >= opts.DelayThreshold
+		}
+		state[num] = cur
+	}
+
+	gone := []string{}
+	for num := range state {
+		if _, ok := current[num]; !ok {
+			gone = append(gone, num)
+		}
+	}
+	sort.Strings(gone)
+	for _, num := range gone {
+		prev := state[num]
+		add(TrainEvent{Type: TrainDisappeared, Train: prev.status, PreviousDelay: prev.reported}, prev.stops)
+		delete(state, num)
+	}
+	return events
+}
+
+// watchMatches returns true if the event passes the options filters. The
+// station filter matches any of the train's upcoming stops, or the station
+// arrived at for TrainArrived events
+func watchMatches(opts WatchOptions, e TrainEvent, stops []Station) bool {
+	if len(opts.Trains) > 0 && !containsString(opts.Trains, e.Train.TrainNum) {
+		return false
+	}
+	if len(opts.Directions) > 0 {
+		found := false
+		for _, d := range opts.Directions {
+			found = found || d == e.Train.Direction
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(opts.Lines) > 0 {
+		found := false
+		for _, l := range opts.Lines {
+			found = found || l.Id == e.Train.Line.Id
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(opts.Stations) > 0 {
+		if e.Type == TrainArrived {
+			stops = []Station{e.Station}
+		}
+		found := false
+		for _, s := range opts.Stations {
+			for _, stop := range stops {
+				found = found || s == stop
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsString returns true if s is in list
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
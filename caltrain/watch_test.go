@@ -0,0 +1,281 @@
+package caltrain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+)
+
+// apiClientSequence returns the next response on each call, repeating the
+// last one once they run out
+type apiClientSequence struct {
+	mu        sync.Mutex
+	responses [][]byte
+	err       error
+	calls     int
+}
+
+func (a *apiClientSequence) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := a.calls
+	if i >= len(a.responses) {
+		i = len(a.responses) - 1
+	}
+	a.calls++
+	if a.err != nil {
+		return nil, a.err
+	}
+	return a.responses[i], nil
+}
+
+// watchVisit is a single MonitoredStopVisit for stopMonitoringJSON
+type watchVisit struct {
+	train   string
+	dir     string
+	station string
+	aimed   time.Time
+	delay   time.Duration
+}
+
+// stopMonitoringJSON returns a StopMonitoring response for the visits
+func stopMonitoringJSON(visits ...watchVisit) []byte {
+	parts := make([]string, len(visits))
+	for i, v := range visits {
+		aimed := v.aimed.Format(time.RFC3339)
+		expected := v.aimed.Add(v.delay).Format(time.RFC3339)
+		parts[i] = fmt.Sprintf(`{"MonitoredVehicleJourney": {
+			"LineRef": "Local",
+			"DirectionRef": %q,
+			"FramedVehicleJourneyRef": {"DatedVehicleJourneyRef": %q},
+			"MonitoredCall": {
+				"StopPointName": "%s Caltrain",
+				"AimedArrivalTime": %q,
+				"ExpectedArrivalTime": %q,
+				"AimedDepartureTime": %q,
+				"ExpectedDepartureTime": %q
+			}
+		}}`, v.dir, v.train, v.station, aimed, expected, aimed, expected)
+	}
+	return []byte(fmt.Sprintf(`{"ServiceDelivery": {"StopMonitoringDelivery": {"MonitoredStopVisit": [%s]}}}`, strings.Join(parts, ",")))
+}
+
+// watchResponses returns three polls of the live feed. Train 102 becomes
+// delayed, arrives at Millbrae with a longer delay then makes up time. Train
+// 101 becomes delayed then leaves the feed
+func watchResponses() [][]byte {
+	utc := func(h, m int) time.Time {
+		return time.Date(2019, time.November, 22, h, m, 0, 0, time.UTC)
+	}
+	return [][]byte{
+		stopMonitoringJSON(
+			watchVisit{train: "101", dir: "North", station: "Hillsdale", aimed: utc(14, 35), delay: time.Minute},
+			watchVisit{train: "102", dir: "South", station: "Hayward Park", aimed: utc(14, 32), delay: 6 * time.Minute},
+			watchVisit{train: "102", dir: "South", station: "Millbrae", aimed: utc(14, 20), delay: 6 * time.Minute},
+		),
+		stopMonitoringJSON(
+			watchVisit{train: "101", dir: "North", station: "Hillsdale", aimed: utc(14, 35), delay: 6 * time.Minute},
+			watchVisit{train: "102", dir: "South", station: "Hayward Park", aimed: utc(14, 32), delay: 9 * time.Minute},
+		),
+		stopMonitoringJSON(
+			watchVisit{train: "102", dir: "South", station: "Hayward Park", aimed: utc(14, 32), delay: 6 * time.Minute},
+		),
+	}
+}
+
+// receiveEvents reads n events from the channel, failing if they take too long
+func receiveEvents(t *testing.T, events <-chan TrainEvent, n int) []TrainEvent {
+	t.Helper()
+	ret := []TrainEvent{}
+	for len(ret) < n {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("Event channel closed after %d events, expected %d", len(ret), n)
+			}
+			ret = append(ret, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out after %d events, expected %d", len(ret), n)
+		}
+	}
+	return ret
+}
+
+type expEvent struct {
+	typ      TrainEventType
+	train    string
+	delay    time.Duration
+	previous time.Duration
+	station  Station
+}
+
+func assertEvents(t *testing.T, exp []expEvent, events []TrainEvent) {
+	t.Helper()
+	if len(exp) != len(events) {
+		t.Fatalf("Incorrect number of events. Expected %d, received %d: %+v", len(exp), len(events), events)
+	}
+	for i, e := range exp {
+		ev := events[i]
+		if ev.Type != e.typ || ev.Train.TrainNum != e.train || ev.Train.Delay != e.delay || ev.PreviousDelay != e.previous || (e.typ == TrainArrived && ev.Station != e.station) {
+			t.Fatalf("Unexpected event %d\nExpected: %+v\nReceived: %+v", i, e, ev)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientSequence{responses: watchResponses()}
+	mock := clock.NewMock()
+	c.clock = mock
+
+	events := c.Watch(ctx, WatchOptions{Interval: 2 * time.Minute})
+
+	assertEvents(t, []expEvent{
+		{typ: TrainDelayed, train: "102", delay: 6 * time.Minute},
+	}, receiveEvents(t, events, 1))
+
+	mock.Add(2 * time.Minute)
+	assertEvents(t, []expEvent{
+		{typ: TrainDelayed, train: "101", delay: 6 * time.Minute},
+		{typ: TrainArrived, train: "102", delay: 9 * time.Minute, previous: 6 * time.Minute, station: StationMillbrae},
+		{typ: DelayIncreased, train: "102", delay: 9 * time.Minute, previous: 6 * time.Minute},
+	}, receiveEvents(t, events, 3))
+
+	mock.Add(2 * time.Minute)
+	assertEvents(t, []expEvent{
+		{typ: DelayDecreased, train: "102", delay: 6 * time.Minute, previous: 9 * time.Minute},
+		{typ: TrainDisappeared, train: "101", delay: 6 * time.Minute, previous: 6 * time.Minute},
+	}, receiveEvents(t, events, 2))
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatchFilters(t *testing.T) {
+	c := newGTFSClient(t)
+	local, err := parseLine("Local", c.AllLines())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	bullet, err := parseLine("Bullet", c.AllLines())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		opts WatchOptions
+		exp  [][]string // train numbers of the events for each poll
+	}{
+		{name: "None", exp: [][]string{{"102"}, {"101", "102", "102"}, {"102", "101"}}},
+		{name: "Trains", opts: WatchOptions{Trains: []string{"101"}}, exp: [][]string{{}, {"101"}, {"101"}}},
+		{name: "Direction", opts: WatchOptions{Directions: []Direction{South}}, exp: [][]string{{"102"}, {"102", "102"}, {"102"}}},
+		{name: "Station", opts: WatchOptions{Stations: []Station{StationMillbrae}}, exp: [][]string{{"102"}, {"102"}, {}}},
+		{name: "Line", opts: WatchOptions{Lines: []Line{local}}, exp: [][]string{{"102"}, {"101", "102", "102"}, {"102", "101"}}},
+		{name: "OtherLine", opts: WatchOptions{Lines: []Line{bullet}}, exp: [][]string{{}, {}, {}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.DelayThreshold = defaultWatchThreshold
+			opts.DelayChange = defaultDelayChange
+			state := make(map[string]*watchedTrain)
+			for i, raw := range watchResponses() {
+				trains, err := c.Dataset().getTrains(raw)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				events := diffTrains(state, trains, opts, time.Now())
+				nums := []string{}
+				for _, e := range events {
+					nums = append(nums, e.Train.TrainNum)
+				}
+				if strings.Join(nums, ",") != strings.Join(tt.exp[i], ",") {
+					t.Fatalf("Unexpected events for poll %d\nExpected: %v\nReceived: %v", i, tt.exp[i], nums)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newGTFSClient(t)
+	c.APIClient = &apiClientSequence{responses: watchResponses(), err: &APILimitError{}}
+	c.clock = clock.NewMock()
+
+	events := c.Watch(ctx, WatchOptions{})
+	e := receiveEvents(t, events, 1)[0]
+	var limErr *APILimitError
+	if e.Type != WatchError || !errors.As(e.Err, &limErr) {
+		t.Fatalf("Unexpected event. Expected an API limit error, received %+v", e)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+// apiClientBudget is a mock that reports a fixed request budget
+type apiClientBudget struct {
+	apiClientMock
+	status RateLimitStatus
+}
+
+func (a *apiClientBudget) RateLimitStatus() RateLimitStatus {
+	return a.status
+}
+
+func TestWatchInterval(t *testing.T) {
+	full := RateLimitStatus{Limit: 60, Remaining: 60, Period: time.Hour, Reserve: 10}
+	low := full
+	low.Remaining = 5
+	tests := []struct {
+		name   string
+		client APIClient
+		feed   LiveFeed
+		exp    time.Duration
+	}{
+		{name: "NoBudget", client: &apiClientMock{}, exp: minWatchInterval},
+		{name: "Full", client: &apiClientBudget{status: full}, exp: 144 * time.Second},
+		{name: "GTFSRealtime", client: &apiClientBudget{status: full}, feed: GTFSRealtimeFeed, exp: 288 * time.Second},
+		{name: "Reserve", client: &apiClientBudget{status: low}, exp: 6 * time.Minute},
+		{name: "Default", client: New(fakeKey).APIClient, exp: 144 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(fakeKey)
+			c.APIClient = tt.client
+			c.SetLiveFeed(tt.feed)
+			interval := c.watchInterval(minWatchInterval)
+			if interval != tt.exp {
+				t.Fatalf("Unexpected interval. Expected %s, received %s", tt.exp, interval)
+			}
+
+			// a watcher must leave at least half of the budget above the
+			// reserve for everything else, or half of the whole budget when
+			// the client doesn't report it
+			s, ok := c.RateLimitStatus()
+			if !ok {
+				s = RateLimitStatus{Limit: defaultRateLimit, Period: defaultRatePeriod}
+			}
+			perPoll := 1
+			if tt.feed == GTFSRealtimeFeed {
+				perPoll = 2
+			}
+			if used := int(s.Period/interval) * perPoll; used > (s.Limit-s.Reserve)/2 {
+				t.Fatalf("A watcher polling every %s uses %d of %d requests", interval, used, s.Limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Command caltrain-server serves Caltrain schedules and live train status as
+// a JSON REST API. See the server package for the endpoints
+//
+// Usage:
+//
+//	caltrain-server [-addr :8080] [-key KEY] [-cache 5m] [-max-stale 30m] [-stale-while-revalidate] [-refresh 24h] [-offline feed.zip] [-snapshot data.json]
+//
+// With -snapshot the server boots from the snapshot file instead of calling
+// Initialize, unless the file is missing or too old, in which case the
+// timetable is fetched and the file is rewritten
+// The 511.org API key is read from the -key flag or the CALTRAIN_API_KEY
+// environment variable
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/efritz09/go-caltrain/caltrain"
+	"github.com/efritz09/go-caltrain/server"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	key := flag.String("key", os.Getenv("CALTRAIN_API_KEY"), "511.org API key (default $CALTRAIN_API_KEY)")
+	cacheTimeout := flag.Duration("cache", 5*time.Minute, "how long live responses are cached")
+	refresh := flag.Duration("refresh", 24*time.Hour, "time between holiday refreshes, the timetable is refreshed nightly")
+	refreshHour := flag.Int("refresh-hour", 2, "pacific hour the nightly timetable refresh window starts")
+	offline := flag.String("offline", "", "path to a saved GTFS feed to serve instead of the API timetable")
+	cacheDir := flag.String("cache-dir", "", "directory to cache live responses in, shared by servers on the same host")
+	maxStale := flag.Duration("max-stale", 30*time.Minute, "oldest cached live response served when the API fails")
+	revalidate := flag.Bool("stale-while-revalidate", false, "serve expired live responses while refreshing them in the background")
+	snapshot := flag.String("snapshot", "", "path to a snapshot file to boot from, written after fetching the timetable")
+	debug := flag.Bool("debug", false, "enable debug logging")
+	flag.Parse()
+
+	if *debug {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+	if *key == "" && *offline == "" {
+		logrus.Fatal("no API key, set -key or CALTRAIN_API_KEY, or use -offline")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := caltrain.New(*key)
+	if *cacheDir != "" {
+		backend, err := caltrain.NewFileCache(*cacheDir)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		c.SetupCache(*cacheTimeout, backend)
+	} else {
+		c.SetupCache(*cacheTimeout)
+	}
+	c.SetMaxStale(*maxStale)
+	c.SetStaleWhileRevalidate(*revalidate)
+	opts := server.Options{
+		Refresh:      caltrain.RefreshPolicy{HolidayInterval: *refresh, TimetableHour: *refreshHour},
+		CacheTimeout: *cacheTimeout,
+	}
+	if *offline != "" {
+		if err := c.LoadGTFS(*offline); err != nil {
+			logrus.Fatalf("failed to load offline data: %v", err)
+		}
+		opts.NoRefresh = true
+	} else if !loadSnapshot(c, *snapshot) {
+		if err := c.Initialize(ctx); err != nil {
+			logrus.Fatalf("failed to initialize: %v", err)
+		}
+		saveSnapshot(c, *snapshot)
+	}
+
+	srv := server.New(c, opts)
+	srv.Run(ctx)
+	go c.RunCacheJanitor(ctx, time.Minute)
+
+	httpServer := &http.Server{Addr: *addr, Handler: srv}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdown); err != nil {
+			logrus.Errorf("failed to shut down: %v", err)
+		}
+	}()
+
+	logrus.Infof("Listening on %s", *addr)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logrus.Fatal(err)
+	}
+}
+
+// loadSnapshot loads the snapshot at path and returns true if it can be used
+// in place of Initialize
+func loadSnapshot(c *caltrain.CaltrainClient, path string) bool {
+	if path == "" {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("failed to open snapshot: %v", err)
+		}
+		return false
+	}
+	defer f.Close()
+	if err := c.LoadSnapshot(f); err != nil {
+		logrus.Warnf("not using snapshot: %v", err)
+		return false
+	}
+	logrus.Infof("Loaded snapshot fetched at %s", c.LastFetched().Format(time.RFC3339))
+	return true
+}
+
+// saveSnapshot writes the client's data to path, if it is set
+func saveSnapshot(c *caltrain.CaltrainClient, path string) {
+	if path == "" {
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		logrus.Warnf("failed to save snapshot: %v", err)
+		return
+	}
+	if err := c.SaveSnapshot(f); err != nil {
+		logrus.Warnf("failed to save snapshot: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		logrus.Warnf("failed to save snapshot: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/efritz09/go-caltrain/caltrain"
+)
+
+// timeFormat is the format used for times in the output
+const timeFormat = "15:04"
+
+// commands maps the subcommand names to their implementations
+var commands = map[string]*command{
+	"next":     nextCommand(),
+	"station":  stationCommand(),
+	"train":    trainCommand(),
+	"delays":   delaysCommand(),
+	"holidays": {data: needHolidays, run: runHolidays},
+	"lines":    {data: needLines, run: runLines},
+	"snapshot": {args: "<path>", data: needAll, run: runSnapshot},
+}
+
+// nextCommand lists the next trains between two stations
+func nextCommand() *command {
+	var count int
+	var after string
+	return &command{
+		args: "<src> <dst>",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&count, "n", 5, "number of trains to show, 0 for all")
+			fs.StringVar(&after, "after", "", "show trains departing after this time as HH:MM (default now)")
+		},
+		data: needAll,
+		run: func(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+			if len(e.args) != 2 {
+				return nil, errors.New("next requires a source and destination station")
+			}
+			src, err := c.ParseStation(e.args[0])
+			if err != nil {
+				return nil, err
+			}
+			dst, err := c.ParseStation(e.args[1])
+			if err != nil {
+				return nil, err
+			}
+			start, err := departAfter(e, after)
+			if err != nil {
+				return nil, err
+			}
+			opts := []caltrain.QueryOption{caltrain.DepartAfter(start)}
+			if count > 0 {
+				opts = append(opts, caltrain.NextN(count))
+			}
+			routes, err := c.GetTrainsBetweenStationsForDate(ctx, src, dst, e.date, opts...)
+			if err != nil {
+				return nil, err
+			}
+
+			t := newTable("Train", "Line", "Depart", "Arrive", "Duration")
+			for _, r := range routes {
+				dep := stopAt(r, src).Departure
+				arr := stopAt(r, dst).Arrival
+				t.add(r.TrainNum, r.Line.Name, dep.Format(timeFormat), arr.Format(timeFormat), arr.Sub(dep).String())
+			}
+			return t, nil
+		},
+	}
+}
+
+// stationCommand lists the next trains at a station with their live status
+func stationCommand() *command {
+	var count int
+	var dir string
+	return &command{
+		args: "<name>",
+		flags: func(fs *flag.FlagSet) {
+			fs.IntVar(&count, "n", 5, "number of trains to show in each direction, 0 for all")
+			fs.StringVar(&dir, "dir", "", "direction of the trains, north or south (default both)")
+		},
+		data: needAll,
+		run: func(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+			if len(e.args) != 1 {
+				return nil, errors.New("station requires a station name")
+			}
+			st, err := c.ParseStation(e.args[0])
+			if err != nil {
+				return nil, err
+			}
+			dirs := []caltrain.Direction{caltrain.North, caltrain.South}
+			if dir != "" {
+				d, err := caltrain.ParseDirection(dir)
+				if err != nil {
+					return nil, err
+				}
+				dirs = []caltrain.Direction{d}
+			}
+			start, err := departAfter(e, "")
+			if err != nil {
+				return nil, err
+			}
+			opts := []caltrain.QueryOption{caltrain.DepartAfter(start)}
+			if count > 0 {
+				opts = append(opts, caltrain.NextN(count))
+			}
+
+			routes := []*caltrain.Route{}
+			for _, d := range dirs {
+				r, err := c.GetStationTimetableContext(ctx, st, d, e.date, opts...)
+				if err != nil {
+					return nil, err
+				}
+				routes = append(routes, r...)
+			}
+			live, err := annotate(ctx, c, e, routes)
+			if err != nil {
+				return nil, err
+			}
+
+			t := newTable("Train", "Direction", "Line", "Scheduled", "Expected", "Delay", "Status")
+			for _, l := range live {
+				stop := liveStopAt(l, st)
+				t.add(l.Route.TrainNum, l.Route.Direction.String(), l.Route.Line.Name, stop.Departure.Format(timeFormat),
+					expected(e, l, stop.ExpectedDeparture), delay(l, stop.Delay), status(l))
+			}
+			return t, nil
+		},
+	}
+}
+
+// trainCommand lists the stops of a train with its live status
+func trainCommand() *command {
+	return &command{
+		args: "<num>",
+		data: needAll,
+		run: func(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+			if len(e.args) != 1 {
+				return nil, errors.New("train requires a train number")
+			}
+			route, err := c.GetTrainRoute(e.args[0])
+			if err != nil {
+				return nil, err
+			}
+			live, err := annotate(ctx, c, e, []*caltrain.Route{route})
+			if err != nil {
+				return nil, err
+			}
+
+			l := live[0]
+			t := newTable("Station", "Arrival", "Departure", "Expected", "Delay", "Passed")
+			for _, s := range l.Stops {
+				t.add(c.StationName(s.Station), s.Arrival.Format(timeFormat), s.Departure.Format(timeFormat),
+					expected(e, l, s.ExpectedArrival), delay(l, s.Delay), fmt.Sprint(s.Passed))
+			}
+			return t, nil
+		},
+	}
+}
+
+// delaysCommand lists the trains that are currently delayed
+func delaysCommand() *command {
+	var threshold time.Duration
+	return &command{
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&threshold, "threshold", 5*time.Minute, "minimum delay to show")
+		},
+		data: needLines,
+		run: func(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+			if e.offline {
+				return nil, errors.New("delays requires the live API and can't be used with --offline")
+			}
+			trains, _, err := c.GetDelays(ctx, threshold)
+			if err != nil {
+				return nil, err
+			}
+
+			t := newTable("Train", "Direction", "Line", "Delay", "Next Stop", "Arrival")
+			for _, tr := range trains {
+				t.add(tr.TrainNum, tr.Direction.String(), tr.Line.Name, tr.Delay.String(), c.StationName(tr.NextStop),
+					tr.Arrival.In(e.now.Location()).Format(timeFormat))
+			}
+			return t, nil
+		},
+	}
+}
+
+// runHolidays lists the days on a holiday schedule
+func runHolidays(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+	t := newTable("Date", "Weekday")
+	for _, h := range c.Holidays() {
+		t.add(h.Format("2006-01-02"), h.Weekday().String())
+	}
+	return t, nil
+}
+
+// runLines lists the available train lines
+func runLines(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+	t := newTable("ID", "Name")
+	for _, l := range c.AllLines() {
+		t.add(l.Id, l.Name)
+	}
+	return t, nil
+}
+
+// runSnapshot saves the loaded data to a file for use with --offline
+func runSnapshot(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) {
+	if len(e.args) != 1 {
+		return nil, errors.New("snapshot requires a file path")
+	}
+	f, err := os.Create(e.args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := c.SaveSnapshot(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	t := newTable("Path", "Fetched")
+	t.add(e.args[0], c.LastFetched().In(e.now.Location()).Format(time.RFC3339))
+	return t, nil
+}
+
+// departAfter returns the time to search for trains from. It is the --after
+// flag on the query date if it is set, now if the query date is today, and
+// the start of the query date otherwise
+func departAfter(e env, after string) (time.Time, error) {
+	if after != "" {
+		t, err := time.Parse(timeFormat, after)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q, expected HH:MM: %w", after, err)
+		}
+		return e.date.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute), nil
+	}
+	if e.now.After(e.date) && e.now.Before(e.date.AddDate(0, 0, 1)) {
+		return e.now, nil
+	}
+	return e.date, nil
+}
+
+// annotate merges the live status into the routes. Offline, or when the live
+// status can't be fetched, the routes are returned without it
+func annotate(ctx context.Context, c *caltrain.CaltrainClient, e env, routes []*caltrain.Route) ([]*caltrain.LiveRoute, error) {
+	if !e.offline {
+		live, err := c.AnnotateRoutes(ctx, routes)
+		if err == nil {
+			return live, nil
+		}
+		var limErr *caltrain.APILimitError
+		if !errors.As(err, &limErr) {
+			return nil, err
+		}
+	}
+	ret := make([]*caltrain.LiveRoute, len(routes))
+	for i, r := range routes {
+		l := &caltrain.LiveRoute{Route: r, Stops: make([]caltrain.LiveStop, len(r.Stops))}
+		for j, s := range r.Stops {
+			l.Stops[j] = caltrain.LiveStop{TrainStop: s}
+		}
+		ret[i] = l
+	}
+	return ret, nil
+}
+
+// stopAt returns the stop of the route at st
+func stopAt(r *caltrain.Route, st caltrain.Station) caltrain.TrainStop {
+	for _, s := range r.Stops {
+		if s.Station == st {
+			return s
+		}
+	}
+	return caltrain.TrainStop{}
+}
+
+// liveStopAt returns the live stop of the route at st
+func liveStopAt(l *caltrain.LiveRoute, st caltrain.Station) caltrain.LiveStop {
+	for _, s := range l.Stops {
+		if s.Station == st {
+			return s
+		}
+	}
+	return caltrain.LiveStop{}
+}
+
+// expected formats an expected time, which is only known for tracked trains
+func expected(e env, l *caltrain.LiveRoute, t time.Time) string {
+	if l.State != caltrain.Tracked || t.IsZero() {
+		return ""
+	}
+	return t.In(e.now.Location()).Format(timeFormat)
+}
+
+// delay formats a delay, which is only known for tracked trains
+func delay(l *caltrain.LiveRoute, d time.Duration) string {
+	if l.State != caltrain.Tracked {
+		return ""
+	}
+	return d.String()
+}
+
+// status formats the live state of a route. Routes without a live status
+// are left empty
+func status(l *caltrain.LiveRoute) string {
+	if l.Updated.IsZero() {
+		return ""
+	}
+	return strings.ToLower(l.State.String())
+}
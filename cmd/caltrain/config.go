@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// keyEnv is the environment variable that holds the 511.org API key
+const keyEnv = "CALTRAIN_API_KEY"
+
+// config is the contents of the config file
+type config struct {
+	Key    string `json:"key"`    // 511.org API key
+	Format string `json:"format"` // default output format
+}
+
+// defaultConfigPath returns the path of the config file in the user's config
+// directory
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "caltrain", "config.json")
+}
+
+// loadConfig reads the config file at path. If path is empty the default
+// config file is used, and it is not an error for it to be missing
+func loadConfig(path string) (config, error) {
+	cfg := config{}
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfigPath()
+		if path == "" {
+			return cfg, nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, os.ErrNotExist) {
+			return cfg, nil
+		}
+		return cfg, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// resolveKey returns the API key from the flag, the environment or the config
+// file, in that order
+func resolveKey(flagKey string, getenv func(string) string, cfg config) string {
+	if flagKey != "" {
+		return flagKey
+	}
+	if key := getenv(keyEnv); key != "" {
+		return key
+	}
+	return cfg.Key
+}
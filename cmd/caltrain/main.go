@@ -0,0 +1,254 @@
+// Command caltrain queries Caltrain schedules and live train status from the
+// command line
+//
+// Usage:
+//
+//	caltrain [flags] <command> [args]
+//
+// Commands:
+//
+//	next <src> <dst>     next trains between two stations
+//	station <name>       next trains at a station, with live status
+//	train <num>          stops for a train, with live status
+//	delays               trains that are currently delayed
+//	holidays             days that run on a holiday schedule
+//	lines                available train lines
+//	snapshot <path>      save the timetable for use with --offline
+//
+// The 511.org API key is read from the --key flag, the CALTRAIN_API_KEY
+// environment variable, or the "key" field of the config file, in that order.
+// With --offline the data is loaded from a GTFS feed or a snapshot saved by
+// the snapshot command instead of the API
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/efritz09/go-caltrain/caltrain"
+)
+
+const usage = `Usage: caltrain [flags] <command> [args]
+
+Commands:
+  next <src> <dst>   next trains between two stations
+  station <name>     next trains at a station, with live status
+  train <num>        stops for a train, with live status
+  delays             trains that are currently delayed
+  holidays           days that run on a holiday schedule
+  lines              available train lines
+  snapshot <path>    save the timetable for use with --offline
+
+Run 'caltrain <command> -h' for the flags of a command
+`
+
+func main() {
+	ctx := context.Background()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Getenv); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "caltrain: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// options contains the flags shared by every command
+type options struct {
+	key     string
+	config  string
+	format  string
+	offline string
+	date    string
+}
+
+// command is a single caltrain subcommand
+type command struct {
+	args  string                                                                       // positional arguments, for the usage message
+	flags func(fs *flag.FlagSet)                                                       // registers the command's own flags
+	data  dataset                                                                      // data loaded before running
+	run   func(ctx context.Context, c *caltrain.CaltrainClient, e env) (*table, error) // runs the command
+}
+
+// env is the state passed to a running command
+type env struct {
+	args    []string  // positional arguments
+	now     time.Time // current time in pacific time
+	date    time.Time // service date to query
+	offline bool      // true if the live API can't be used
+}
+
+// run parses the arguments, loads the client and runs the command, writing
+// the result to out
+func run(ctx context.Context, args []string, out io.Writer, getenv func(string) string) error {
+	global := flag.NewFlagSet("caltrain", flag.ContinueOnError)
+	opts := &options{}
+	addGlobalFlags(global, opts)
+	global.Usage = func() {
+		fmt.Fprint(global.Output(), usage)
+		fmt.Fprintln(global.Output(), "\nFlags:")
+		global.PrintDefaults()
+	}
+	if err := global.Parse(args); err != nil {
+		return err
+	}
+	if global.NArg() == 0 {
+		global.Usage()
+		return flag.ErrHelp
+	}
+
+	name := global.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	// the global flags can also come after the command
+	addGlobalFlags(fs, opts)
+	if cmd.flags != nil {
+		cmd.flags(fs)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: caltrain %s [flags] %s\n\nFlags:\n", name, cmd.args)
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, global.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(opts.config)
+	if err != nil {
+		return err
+	}
+	if opts.format == "" {
+		opts.format = cfg.Format
+	}
+	format, err := parseFormat(opts.format)
+	if err != nil {
+		return err
+	}
+
+	key := resolveKey(opts.key, getenv, cfg)
+	if key == "" && opts.offline == "" {
+		return errors.New("no API key, set --key, CALTRAIN_API_KEY or the config file key, or use --offline")
+	}
+	c := caltrain.New(key)
+	if err := load(ctx, c, opts, cmd.data); err != nil {
+		return err
+	}
+
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+	e := env{args: positional, now: time.Now().In(tz), offline: opts.offline != ""}
+	e.date = time.Date(e.now.Year(), e.now.Month(), e.now.Day(), 0, 0, 0, 0, tz)
+	if opts.date != "" {
+		e.date, err = time.ParseInLocation("2006-01-02", opts.date, tz)
+		if err != nil {
+			return fmt.Errorf("invalid date %q, expected YYYY-MM-DD: %w", opts.date, err)
+		}
+	}
+
+	t, err := cmd.run(ctx, c, e)
+	if err != nil {
+		return err
+	}
+	return t.write(out, format)
+}
+
+// addGlobalFlags registers the shared flags on fs
+func addGlobalFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.key, "key", opts.key, "511.org API key")
+	fs.StringVar(&opts.config, "config", opts.config, "path to the config file (default $XDG_CONFIG_HOME/caltrain/config.json)")
+	fs.StringVar(&opts.format, "format", opts.format, "output format: table, json or csv (default table)")
+	fs.StringVar(&opts.offline, "offline", opts.offline, "path to a GTFS feed or saved snapshot to use instead of the API")
+	fs.StringVar(&opts.date, "date", opts.date, "service date as YYYY-MM-DD (default today)")
+}
+
+// parseInterspersed parses flags that are mixed in with positional
+// arguments, such as 'station "Palo Alto" --dir south', and returns the
+// positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// dataset specifies the data that a command needs loaded
+type dataset int
+
+const (
+	// needLines commands only use the lines
+	needLines dataset = iota
+	// needHolidays commands only use the holidays
+	needHolidays
+	// needAll commands use the timetable, stations, lines and holidays
+	needAll
+)
+
+// load populates the client from the API or from the offline GTFS feed,
+// making only the API calls the command needs
+func load(ctx context.Context, c *caltrain.CaltrainClient, opts *options, need dataset) error {
+	if opts.offline != "" {
+		if err := loadOffline(c, opts.offline); err != nil {
+			return fmt.Errorf("failed to load offline data: %w", err)
+		}
+		return nil
+	}
+
+	var err error
+	switch need {
+	case needLines:
+		err = c.UpdateLines(ctx)
+	case needHolidays:
+		err = c.UpdateHolidays(ctx)
+	case needAll:
+		err = c.Initialize(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load data from 511.org: %w", err)
+	}
+	return nil
+}
+
+// loadOffline populates the client from a GTFS zip archive or a snapshot
+// saved by the snapshot command. An old snapshot is used with a warning
+func loadOffline(c *caltrain.CaltrainClient, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if bytes.Equal(magic, []byte("PK\x03\x04")) {
+		return c.LoadGTFS(path)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	err = c.LoadSnapshot(f)
+	var ageErr *caltrain.SnapshotAgeError
+	if errors.As(err, &ageErr) {
+		fmt.Fprintf(os.Stderr, "caltrain: warning: %v\n", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gtfsFixture zips the library's GTFS test feed into a temporary file and
+// returns its path
+func gtfsFixture(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join("..", "..", "caltrain", "testdata", "gtfs")
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the GTFS fixture: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "feed.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(src, file.Name()))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		w, err := zw.Create(file.Name())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return path
+}
+
+func noEnv(string) string { return "" }
+
+func TestRunOffline(t *testing.T) {
+	ctx := context.Background()
+	feed := gtfsFixture(t)
+
+	tests := []struct {
+		name string
+		args []string
+		exp  string
+	}{
+		{
+			name: "Next",
+			args: []string{"next", "San Jose Diridon", "San Francisco", "--offline", feed, "--date", "2019-11-22", "--after", "06:10", "-n", "2"},
+			exp:  "Train  Line    Depart  Arrive  Duration\n501    Bullet  06:30   07:15   45m0s\n103    Local   07:00   08:10   1h10m0s\n",
+		},
+		{
+			name: "Station",
+			args: []string{"--offline", feed, "--format", "csv", "--date", "2019-11-22", "station", "hillsdale", "--dir", "south", "-n", "1"},
+			exp:  "Train,Direction,Line,Scheduled,Expected,Delay,Status\n102,South,Local,06:35,,,\n",
+		},
+		{
+			name: "Train",
+			args: []string{"train", "501", "--offline", feed, "--format", "csv"},
+			exp:  "Station,Arrival,Departure,Expected,Delay,Passed\nSan Jose Diridon,06:30,06:30,,,false\nPalo Alto,06:42,06:42,,,false\nHillsdale,06:52,06:52,,,false\nMillbrae,07:00,07:00,,,false\nSan Francisco,07:15,07:15,,,false\n",
+		},
+		{
+			name: "Holidays",
+			args: []string{"holidays", "--offline", feed, "--format", "csv"},
+			exp:  "Date,Weekday\n2019-11-28,Thursday\n2019-12-25,Wednesday\n",
+		},
+		{
+			name: "Lines",
+			args: []string{"lines", "--offline", feed, "--format", "csv"},
+			exp:  "ID,Name\nLocal,Local\nBullet,Bullet\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(ctx, tt.args, &out, noEnv); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if out.String() != tt.exp {
+				t.Fatalf("Unexpected output\nExpected:\n%s\nReceived:\n%s", tt.exp, out.String())
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	ctx := context.Background()
+	feed := gtfsFixture(t)
+	empty := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(empty, []byte(`{}`), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "NoCommand", args: []string{}},
+		{name: "UnknownCommand", args: []string{"--offline", feed, "fly"}},
+		{name: "NoKey", args: []string{"lines", "--config", empty}},
+		{name: "BadFormat", args: []string{"lines", "--offline", feed, "--format", "xml"}},
+		{name: "BadStation", args: []string{"next", "Nowhere", "San Francisco", "--offline", feed}},
+		{name: "MissingArgs", args: []string{"next", "San Francisco", "--offline", feed}},
+		{name: "DelaysOffline", args: []string{"delays", "--offline", feed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := run(ctx, tt.args, &out, noEnv); err == nil {
+				t.Fatalf("run improperly succeeded")
+			}
+		})
+	}
+}
+
+func TestResolveKey(t *testing.T) {
+	env := func(key string) func(string) string {
+		return func(name string) string {
+			if name == keyEnv {
+				return key
+			}
+			return ""
+		}
+	}
+	cfg := config{Key: "config"}
+
+	if key := resolveKey("flag", env("env"), cfg); key != "flag" {
+		t.Fatalf("Unexpected key. Expected %s, received %s", "flag", key)
+	}
+	if key := resolveKey("", env("env"), cfg); key != "env" {
+		t.Fatalf("Unexpected key. Expected %s, received %s", "env", key)
+	}
+	if key := resolveKey("", env(""), cfg); key != "config" {
+		t.Fatalf("Unexpected key. Expected %s, received %s", "config", key)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(`{"key": "abc", "format": "csv"}`), 0600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Key != "abc" || cfg.Format != "csv" {
+		t.Fatalf("Unexpected config: %+v", cfg)
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatalf("loadConfig improperly succeeded for a missing file")
+	}
+}
+
+func TestTableJSON(t *testing.T) {
+	tb := newTable("Train", "Next Stop")
+	tb.add("101", "Palo Alto")
+	var out bytes.Buffer
+	if err := tb.write(&out, formatJSON); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rows := []map[string]string{}
+	if err := json.Unmarshal(out.Bytes(), &rows); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["train"] != "101" || rows[0]["next_stop"] != "Palo Alto" {
+		t.Fatalf("Unexpected json: %s", strings.TrimSpace(out.String()))
+	}
+}
+
+func TestRunSnapshot(t *testing.T) {
+	ctx := context.Background()
+	feed := gtfsFixture(t)
+	snap := filepath.Join(t.TempDir(), "snapshot.json")
+
+	var out bytes.Buffer
+	if err := run(ctx, []string{"snapshot", snap, "--offline", feed}, &out, noEnv); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	out.Reset()
+	args := []string{"next", "San Jose Diridon", "San Francisco", "--offline", snap, "--date", "2019-11-22", "--after", "06:10", "-n", "1", "--format", "csv"}
+	if err := run(ctx, args, &out, noEnv); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if exp := "Train,Line,Depart,Arrive,Duration\n501,Bullet,06:30,07:15,45m0s\n"; out.String() != exp {
+		t.Fatalf("Unexpected output\nExpected:\n%s\nReceived:\n%s", exp, out.String())
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// A format specifies how a table is written
+type format int
+
+const (
+	formatTable format = iota
+	formatJSON
+	formatCSV
+)
+
+// parseFormat returns the format for the --format flag. An empty string is
+// the table format
+func parseFormat(s string) (format, error) {
+	switch strings.ToLower(s) {
+	case "", "table":
+		return formatTable, nil
+	case "json":
+		return formatJSON, nil
+	case "csv":
+		return formatCSV, nil
+	default:
+		return formatTable, fmt.Errorf("unknown format %q, must be table, json or csv", s)
+	}
+}
+
+// table is the result of a command. Every format is written from the same
+// headers and rows so the output has the same fields
+type table struct {
+	headers []string
+	rows    [][]string
+}
+
+// newTable returns an empty table with the given headers
+func newTable(headers ...string) *table {
+	return &table{headers: headers, rows: [][]string{}}
+}
+
+// add appends a row to the table
+func (t *table) add(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// write writes the table to w in the given format
+func (t *table) write(w io.Writer, f format) error {
+	switch f {
+	case formatJSON:
+		return t.writeJSON(w)
+	case formatCSV:
+		return t.writeCSV(w)
+	default:
+		return t.writeTable(w)
+	}
+}
+
+// writeTable writes the table as aligned columns
+func (t *table) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.headers, "\t"))
+	for _, row := range t.rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes the table as csv with a header row
+func (t *table) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.headers); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(t.rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// writeJSON writes the table as an array of objects keyed by the headers in
+// snake case
+func (t *table) writeJSON(w io.Writer) error {
+	keys := make([]string, len(t.headers))
+	for i, h := range t.headers {
+		keys[i] = strings.ReplaceAll(strings.ToLower(h), " ", "_")
+	}
+	objects := make([]map[string]string, len(t.rows))
+	for i, row := range t.rows {
+		obj := make(map[string]string, len(keys))
+		for j, key := range keys {
+			if j < len(row) {
+				obj[key] = row[j]
+			}
+		}
+		objects[i] = obj
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(objects)
+}
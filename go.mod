@@ -1,8 +1,14 @@
 module github.com/efritz09/go-caltrain
 
-go 1.16
+go 1.23
 
 require (
+	github.com/MobilityData/gtfs-realtime-bindings/golang/gtfs v1.0.0
 	github.com/benbjohnson/clock v1.1.0
 	github.com/sirupsen/logrus v1.8.1
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/sync v0.5.0
+	google.golang.org/protobuf v1.36.12
 )
+
+require golang.org/x/sys v0.4.0 // indirect
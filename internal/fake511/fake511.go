@@ -0,0 +1,273 @@
+// Package fake511 is a local stand-in for the 511.org transit API that serves
+// the caltrain test fixtures over HTTP, for end-to-end tests of the client.
+// Scenarios such as delayed trains, rate limiting and bad responses are
+// scripted with the Server methods
+package fake511
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Fixture file names, relative to the fixture directory
+const (
+	LinesFile          = "lines.json"
+	StationsFile       = "stations.json"
+	HolidaysFile       = "holiday.json"
+	StopMonitoringFile = "parseDelayData2.json"
+)
+
+// Endpoint paths served by the Server
+const (
+	LinesPath          = "/transit/lines"
+	StationsPath       = "/transit/stops"
+	HolidaysPath       = "/transit/holidays"
+	TimetablePath      = "/transit/timetable"
+	StopMonitoringPath = "/transit/StopMonitoring"
+)
+
+// timetableFiles maps each line id to its timetable fixture
+var timetableFiles = map[string]string{
+	"Local":   "localSchedule.json",
+	"Limited": "limitedSchedule.json",
+	"LTD A":   "limitedASchedule.json",
+	"LTD B":   "limitedBSchedule.json",
+	"Bullet":  "bulletSchedule.json",
+	"Special": "specialSchedule.json",
+}
+
+// bom is the byte order mark that 511.org prefixes to its responses
+var bom = []byte("\xef\xbb\xbf")
+
+// Server is a fake 511.org API. Its URL is passed to caltrain.WithBaseURL to
+// send a client's requests to it. Requests without an api_key are refused
+// with 401 Unauthorized, like the real API
+type Server struct {
+	*httptest.Server
+	dir string
+
+	lock       sync.Mutex
+	calls      map[string]int           // map of path to number of requests
+	total      int                      // number of requests to every path
+	limitAfter int                      // requests allowed before 429s, 0 for no limit
+	bom        bool                     // prefix the responses with a byte order mark
+	malformed  map[string]bool          // paths that return malformed JSON
+	failures   map[string]int           // paths that return a status code
+	delays     map[string]time.Duration // map of train number to injected delay
+}
+
+// New starts a Server that serves the fixtures in dir, the caltrain package's
+// testdata directory. The server must be closed with Close
+func New(dir string) *Server {
+	s := &Server{
+		dir:       dir,
+		calls:     make(map[string]int),
+		malformed: make(map[string]bool),
+		failures:  make(map[string]int),
+		delays:    make(map[string]time.Duration),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// DelayTrain sets the expected times of train in the StopMonitoring responses
+// to d after the aimed times
+func (s *Server) DelayTrain(train string, d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.delays[train] = d
+}
+
+// LimitAfter returns 429 Too Many Requests for every request after the next
+// n. Zero removes the limit
+func (s *Server) LimitAfter(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.limitAfter = 0
+	if n > 0 {
+		s.limitAfter = s.total + n
+	}
+}
+
+// SetBOM prefixes every response with a byte order mark, as 511.org does
+func (s *Server) SetBOM(on bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.bom = on
+}
+
+// Malform makes the endpoint at path return malformed JSON
+func (s *Server) Malform(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.malformed[path] = true
+}
+
+// Fail makes the endpoint at path respond with the status code. A code of
+// zero removes the failure
+func (s *Server) Fail(path string, code int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if code == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = code
+}
+
+// Calls returns the number of requests made to the endpoint at path
+func (s *Server) Calls(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls[path]
+}
+
+// TotalCalls returns the number of requests made to every endpoint
+func (s *Server) TotalCalls() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.total
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	s.calls[r.URL.Path]++
+	s.total++
+	limited := s.limitAfter > 0 && s.total > s.limitAfter
+	failure := s.failures[r.URL.Path]
+	malformed := s.malformed[r.URL.Path]
+	withBOM := s.bom
+	delays := make(map[string]time.Duration, len(s.delays))
+	for k, v := range s.delays {
+		delays[k] = v
+	}
+	s.lock.Unlock()
+
+	q := r.URL.Query()
+	switch {
+	case q.Get("api_key") == "":
+		http.Error(w, "missing api_key", http.StatusUnauthorized)
+		return
+	case limited:
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	case failure != 0:
+		http.Error(w, http.StatusText(failure), failure)
+		return
+	}
+
+	var body []byte
+	var err error
+	switch r.URL.Path {
+	case LinesPath:
+		body, err = s.fixture(LinesFile)
+	case StationsPath:
+		body, err = s.fixture(StationsFile)
+	case HolidaysPath:
+		body, err = s.fixture(HolidaysFile)
+	case TimetablePath:
+		name, ok := timetableFiles[q.Get("line_id")]
+		if !ok {
+			http.Error(w, "unknown line_id", http.StatusBadRequest)
+			return
+		}
+		body, err = s.fixture(name)
+	case StopMonitoringPath:
+		body, err = s.stopMonitoring(q.Get("stopCode"), delays)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if malformed {
+		body = body[:len(body)/2]
+	}
+	if withBOM {
+		body = append(append([]byte{}, bom...), body...)
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
+
+// fixture reads a fixture without its byte order mark
+func (s *Server) fixture(name string) ([]byte, error) {
+	body, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimPrefix(body, bom), nil
+}
+
+// stopMonitoring returns the StopMonitoring fixture with the injected delays,
+// filtered to the visits at stopCode if it is set
+func (s *Server) stopMonitoring(stopCode string, delays map[string]time.Duration) ([]byte, error) {
+	raw, err := s.fixture(StopMonitoringFile)
+	if err != nil {
+		return nil, err
+	}
+	if stopCode == "" && len(delays) == 0 {
+		return raw, nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", StopMonitoringFile, err)
+	}
+	delivery, _ := lookup(doc, "ServiceDelivery", "StopMonitoringDelivery").(map[string]interface{})
+	if delivery == nil {
+		return nil, fmt.Errorf("%s has no StopMonitoringDelivery", StopMonitoringFile)
+	}
+	visits, _ := delivery["MonitoredStopVisit"].([]interface{})
+	kept := make([]interface{}, 0, len(visits))
+	for _, v := range visits {
+		visit, _ := v.(map[string]interface{})
+		if stopCode != "" && visit["MonitoringRef"] != stopCode {
+			continue
+		}
+		train, _ := lookup(visit, "MonitoredVehicleJourney", "FramedVehicleJourneyRef", "DatedVehicleJourneyRef").(string)
+		if d, ok := delays[train]; ok {
+			call, _ := lookup(visit, "MonitoredVehicleJourney", "MonitoredCall").(map[string]interface{})
+			delayCall(call, d)
+		}
+		kept = append(kept, visit)
+	}
+	delivery["MonitoredStopVisit"] = kept
+	return json.Marshal(doc)
+}
+
+// delayCall sets the expected times of a MonitoredCall to d after its aimed
+// times
+func delayCall(call map[string]interface{}, d time.Duration) {
+	for _, kind := range []string{"Arrival", "Departure"} {
+		aimed, _ := call["Aimed"+kind+"Time"].(string)
+		t, err := time.Parse(time.RFC3339, aimed)
+		if err != nil {
+			continue
+		}
+		call["Expected"+kind+"Time"] = t.Add(d).Format(time.RFC3339)
+	}
+}
+
+// lookup returns the value at the path of keys in nested JSON objects, or nil
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
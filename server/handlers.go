@@ -0,0 +1,392 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/efritz09/go-caltrain/caltrain"
+)
+
+// errorJSON is the body of an error response
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// lineJSON is the json form of a Line
+type lineJSON struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// stationJSON is the json form of a Station
+type stationJSON struct {
+	Name string `json:"name"`
+}
+
+// stopJSON is the json form of a TrainStop on a given date
+type stopJSON struct {
+	Order     int       `json:"order"`
+	Station   string    `json:"station"`
+	Arrival   time.Time `json:"arrival"`
+	Departure time.Time `json:"departure"`
+}
+
+// routeJSON is the json form of a Route on a given date
+type routeJSON struct {
+	Train     string     `json:"train"`
+	Direction string     `json:"direction"`
+	Line      lineJSON   `json:"line"`
+	Stops     []stopJSON `json:"stops"`
+}
+
+// statusJSON is the json form of a TrainStatus
+type statusJSON struct {
+	Train        string    `json:"train"`
+	Direction    string    `json:"direction"`
+	Line         lineJSON  `json:"line"`
+	DelaySeconds int       `json:"delay_seconds"`
+	Arrival      time.Time `json:"arrival"`
+	NextStop     string    `json:"next_stop"`
+	Occupancy    string    `json:"occupancy,omitempty"`
+}
+
+// refreshJSON is the json form of a RefreshStatus
+type refreshJSON struct {
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	Next        *time.Time `json:"next,omitempty"`
+}
+
+// autoRefreshJSON is the json form of an AutoRefreshStatus
+type autoRefreshJSON struct {
+	Enabled   bool        `json:"enabled"`
+	Holidays  refreshJSON `json:"holidays"`
+	Timetable refreshJSON `json:"timetable"`
+}
+
+// handleStations serves GET /stations
+func (s *Server) handleStations(w http.ResponseWriter, r *http.Request) {
+	d := s.client.Dataset()
+	ret := []stationJSON{}
+	for _, st := range d.Stations() {
+		ret = append(ret, stationJSON{Name: d.StationName(st)})
+	}
+	s.writeStatic(w, r, ret, d.Fetched())
+}
+
+// handleLines serves GET /lines
+func (s *Server) handleLines(w http.ResponseWriter, r *http.Request) {
+	d := s.client.Dataset()
+	ret := []lineJSON{}
+	for _, l := range d.Lines() {
+		ret = append(ret, toLineJSON(l))
+	}
+	s.writeStatic(w, r, ret, d.Fetched())
+}
+
+// handleRoutes serves GET /routes?src=&dst=&date=. The optional after and n
+// parameters limit the results to the next n trains after a time
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	fetched := s.client.LastFetched()
+	q := r.URL.Query()
+	src, err := s.parseStation(q.Get("src"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid src: %w", err))
+		return
+	}
+	dst, err := s.parseStation(q.Get("dst"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid dst: %w", err))
+		return
+	}
+	date, opts, err := s.parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	routes, err := s.client.GetTrainsBetweenStationsForDate(r.Context(), src, dst, date, opts...)
+	if err != nil {
+		writeLiveError(w, err)
+		return
+	}
+	s.writeDated(w, r, s.toRoutesJSON(routes, date), fetched, date)
+}
+
+// handleTimetable serves GET /stations/{name}/timetable?dir=&date=. Both
+// directions are returned if dir is not set
+func (s *Server) handleTimetable(w http.ResponseWriter, r *http.Request) {
+	fetched := s.client.LastFetched()
+	st, err := s.parseStation(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	dirs, err := parseDirections(r.URL.Query().Get("dir"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	date, opts, err := s.parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ret := []routeJSON{}
+	for _, d := range dirs {
+		routes, err := s.client.GetStationTimetableContext(r.Context(), st, d, date, opts...)
+		if err != nil {
+			writeLiveError(w, err)
+			return
+		}
+		ret = append(ret, s.toRoutesJSON(routes, date)...)
+	}
+	s.writeDated(w, r, ret, fetched, date)
+}
+
+// handleStationStatus serves GET /stations/{name}/status?dir=. Both
+// directions are returned if dir is not set
+func (s *Server) handleStationStatus(w http.ResponseWriter, r *http.Request) {
+	st, err := s.parseStation(r.PathValue("name"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	dirs, err := parseDirections(r.URL.Query().Get("dir"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ret := []statusJSON{}
+	var fetched time.Time
+	var stale error
+	for _, d := range dirs {
+		trains, t, err := s.client.GetStationStatus(r.Context(), st, d)
+		if err != nil {
+			if trains == nil {
+				writeLiveError(w, err)
+				return
+			}
+			stale = err
+		}
+		// the response is as old as its oldest part
+		if fetched.IsZero() || t.Before(fetched) {
+			fetched = t
+		}
+		ret = append(ret, s.toStatusJSON(trains)...)
+	}
+	if stale != nil {
+		setStale(w, stale)
+	}
+	s.writeLive(w, r, ret, fetched)
+}
+
+// handleTrain serves GET /trains/{num}?date=
+func (s *Server) handleTrain(w http.ResponseWriter, r *http.Request) {
+	fetched := s.client.LastFetched()
+	route, err := s.client.GetTrainRoute(r.PathValue("num"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	date, _, err := s.parseQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.writeDated(w, r, s.toRouteJSON(route, date), fetched, date)
+}
+
+// handleDelays serves GET /delays?threshold=. The threshold is a duration
+// such as 10m and defaults to 5 minutes
+func (s *Server) handleDelays(w http.ResponseWriter, r *http.Request) {
+	threshold := 5 * time.Minute
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		var err error
+		threshold, err = time.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid threshold: %w", err))
+			return
+		}
+	}
+
+	trains, fetched, err := s.client.GetDelays(r.Context(), threshold)
+	if err != nil {
+		if trains == nil {
+			writeLiveError(w, err)
+			return
+		}
+		setStale(w, err)
+	}
+	s.writeLive(w, r, s.toStatusJSON(trains), fetched)
+}
+
+// handleRefresh serves GET /refresh
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	status := s.client.AutoRefreshStatus()
+	writeJSON(w, http.StatusOK, autoRefreshJSON{
+		Enabled:   !s.opts.NoRefresh,
+		Holidays:  toRefreshJSON(status.Holidays),
+		Timetable: toRefreshJSON(status.Timetable),
+	})
+}
+
+// parseQuery returns the date and query options from the date, after and n
+// parameters. The date defaults to today
+func (s *Server) parseQuery(r *http.Request) (time.Time, []caltrain.QueryOption, error) {
+	q := r.URL.Query()
+	now := s.clock.Now().In(s.tz)
+	date := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.tz)
+	if v := q.Get("date"); v != "" {
+		var err error
+		date, err = time.ParseInLocation("2006-01-02", v, s.tz)
+		if err != nil {
+			return date, nil, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", v)
+		}
+	}
+
+	opts := []caltrain.QueryOption{}
+	if v := q.Get("after"); v != "" {
+		t, err := time.Parse("15:04", v)
+		if err != nil {
+			return date, nil, fmt.Errorf("invalid after %q, expected HH:MM", v)
+		}
+		opts = append(opts, caltrain.DepartAfter(date.Add(time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)))
+	}
+	if v := q.Get("n"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return date, nil, fmt.Errorf("invalid n %q", v)
+		}
+		opts = append(opts, caltrain.NextN(n))
+	}
+	return date, opts, nil
+}
+
+// parseStation returns the Station for a name, including the stations the
+// client registered from the stops feed. Dashes and underscores can be used
+// in place of spaces so names fit in a path
+func (s *Server) parseStation(name string) (caltrain.Station, error) {
+	if name == "" {
+		return 0, errors.New("station is required")
+	}
+	name = strings.NewReplacer("-", " ", "_", " ").Replace(name)
+	return s.client.ParseStation(name)
+}
+
+// parseDirections returns the direction in dir, or both directions if it is
+// empty
+func parseDirections(dir string) ([]caltrain.Direction, error) {
+	if dir == "" {
+		return []caltrain.Direction{caltrain.North, caltrain.South}, nil
+	}
+	d, err := caltrain.ParseDirection(dir)
+	if err != nil {
+		return nil, err
+	}
+	return []caltrain.Direction{d}, nil
+}
+
+// setStale marks a response as stale data served because of err
+func setStale(w http.ResponseWriter, err error) {
+	w.Header().Set("Warning", fmt.Sprintf(`110 - "Response is Stale: %s"`, err))
+}
+
+// toRefreshJSON converts a RefreshStatus, leaving out the zero times
+func toRefreshJSON(status caltrain.RefreshStatus) refreshJSON {
+	ret := refreshJSON{
+		LastSuccess: timeOrNil(status.LastSuccess),
+		LastFailure: timeOrNil(status.LastFailure),
+		Next:        timeOrNil(status.Next),
+	}
+	if status.LastError != nil {
+		ret.LastError = status.LastError.Error()
+	}
+	return ret
+}
+
+// timeOrNil returns nil for the zero time
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// writeLiveError writes the response for a failed client request. API errors
+// are returned as 429 or 502, and anything else as 500
+func writeLiveError(w http.ResponseWriter, err error) {
+	var limErr *caltrain.APILimitError
+	var apiErr *caltrain.APIError
+	switch {
+	case errors.As(err, &limErr):
+		writeError(w, http.StatusTooManyRequests, err)
+	case errors.As(err, &apiErr):
+		writeError(w, http.StatusBadGateway, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func toLineJSON(l caltrain.Line) lineJSON {
+	return lineJSON{ID: l.Id, Name: l.Name}
+}
+
+func (s *Server) toStatusJSON(trains []caltrain.TrainStatus) []statusJSON {
+	ret := make([]statusJSON, len(trains))
+	for i, t := range trains {
+		ret[i] = statusJSON{
+			Train:        t.TrainNum,
+			Direction:    t.Direction.String(),
+			Line:         toLineJSON(t.Line),
+			DelaySeconds: int(t.Delay.Seconds()),
+			Arrival:      t.Arrival,
+			NextStop:     s.client.StationName(t.NextStop),
+		}
+		if t.Occupancy != caltrain.OccupancyUnknown {
+			ret[i].Occupancy = t.Occupancy.String()
+		}
+	}
+	return ret
+}
+
+func (s *Server) toRoutesJSON(routes []*caltrain.Route, date time.Time) []routeJSON {
+	ret := make([]routeJSON, len(routes))
+	for i, r := range routes {
+		ret[i] = s.toRouteJSON(r, date)
+	}
+	return ret
+}
+
+// toRouteJSON converts a route to json with its stop times on the date
+func (s *Server) toRouteJSON(r *caltrain.Route, date time.Time) routeJSON {
+	ret := routeJSON{
+		Train:     r.TrainNum,
+		Direction: r.Direction.String(),
+		Line:      toLineJSON(r.Line),
+		Stops:     make([]stopJSON, len(r.Stops)),
+	}
+	for i, stop := range r.Stops {
+		ret.Stops[i] = stopJSON{
+			Order:     stop.Order,
+			Station:   s.client.StationName(stop.Station),
+			Arrival:   onDate(date, stop.Arrival),
+			Departure: onDate(date, stop.Departure),
+		}
+	}
+	return ret
+}
+
+// onDate returns the wall clock time of a timetable time on the given date.
+// Timetable times past midnight are a day after the timetable's base date
+func onDate(date, t time.Time) time.Time {
+	days := t.YearDay() - 1
+	return time.Date(date.Year(), date.Month(), date.Day()+days, t.Hour(), t.Minute(), t.Second(), 0, date.Location())
+}
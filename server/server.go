@@ -0,0 +1,194 @@
+// Package server exposes a CaltrainClient as a JSON REST API
+//
+// The endpoints are:
+//
+//	GET /stations                     all stations
+//	GET /lines                        all train lines
+//	GET /routes?src=&dst=&date=       trains between two stations
+//	GET /stations/{name}/timetable    trains that stop at a station
+//	GET /stations/{name}/status       live status of the trains at a station
+//	GET /trains/{num}                 stops for a train
+//	GET /delays                       trains that are currently delayed
+//	GET /refresh                      status of the background refreshes
+//
+// Responses include an ETag and Cache-Control header. Live responses are
+// tagged with the time their data was fetched from 511.org, so clients can
+// revalidate them without using the API limit, and static responses are
+// tagged with the time the timetable was fetched. Responses for a date that
+// defaulted to today expire at the next midnight in California
+package server
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/efritz09/go-caltrain/caltrain"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultStaticMaxAge is the longest max-age of a static response
+	defaultStaticMaxAge = 24 * time.Hour
+	defaultCacheTimeout = 5 * time.Minute
+)
+
+// Options configures a Server
+type Options struct {
+	// Refresh is the policy of the background refreshes started by Run.
+	// Zero fields use the client's defaults
+	Refresh caltrain.RefreshPolicy
+	// CacheTimeout is the cache expiration passed to the client's
+	// SetupCache. It sets the max-age of live responses. Defaults to 5 minutes
+	CacheTimeout time.Duration
+	// NoRefresh disables the background refresh, such as when the client was
+	// loaded from a GTFS feed
+	NoRefresh bool
+}
+
+// Server serves the JSON API for a CaltrainClient
+type Server struct {
+	client *caltrain.CaltrainClient
+	opts   Options
+	mux    *http.ServeMux
+	tz     *time.Location
+
+	clock clock.Clock // time package for unit testing
+}
+
+// New returns a Server for a CaltrainClient. The client must already be
+// initialized. If the client uses a cache, opts.CacheTimeout should match it
+func New(c *caltrain.CaltrainClient, opts Options) *Server {
+	if opts.CacheTimeout == 0 {
+		opts.CacheTimeout = defaultCacheTimeout
+	}
+	tz, _ := time.LoadLocation("America/Los_Angeles")
+	s := &Server{
+		client: c,
+		opts:   opts,
+		mux:    http.NewServeMux(),
+		tz:     tz,
+		clock:  clock.New(),
+	}
+
+	s.mux.HandleFunc("GET /stations", s.handleStations)
+	s.mux.HandleFunc("GET /lines", s.handleLines)
+	s.mux.HandleFunc("GET /routes", s.handleRoutes)
+	s.mux.HandleFunc("GET /stations/{name}/timetable", s.handleTimetable)
+	s.mux.HandleFunc("GET /stations/{name}/status", s.handleStationStatus)
+	s.mux.HandleFunc("GET /trains/{num}", s.handleTrain)
+	s.mux.HandleFunc("GET /delays", s.handleDelays)
+	s.mux.HandleFunc("GET /refresh", s.handleRefresh)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run starts the client's refreshes of the static data in the background,
+// which run until ctx is done. Their status is served at /refresh
+func (s *Server) Run(ctx context.Context) {
+	if s.opts.NoRefresh {
+		return
+	}
+	s.client.StartAutoRefresh(ctx, s.opts.Refresh)
+}
+
+// writeStatic writes a response built from the static data fetched at the
+// given time, which is read before the body is built. It can be cached until
+// the next refresh
+func (s *Server) writeStatic(w http.ResponseWriter, r *http.Request, body interface{}, fetched time.Time) {
+	s.write(w, r, body, fetched, s.staticMaxAge(fetched), "")
+}
+
+// writeDated writes a static response for the date of the request. When the
+// date defaulted to today, the response is tagged with the date and expires
+// at the next midnight at the latest
+func (s *Server) writeDated(w http.ResponseWriter, r *http.Request, body interface{}, fetched, date time.Time) {
+	maxAge := s.staticMaxAge(fetched)
+	if r.URL.Query().Get("date") != "" {
+		s.write(w, r, body, fetched, maxAge, "")
+		return
+	}
+	midnight := time.Date(date.Year(), date.Month(), date.Day()+1, 0, 0, 0, 0, s.tz)
+	if left := midnight.Sub(s.clock.Now()); left < maxAge {
+		maxAge = left
+	}
+	s.write(w, r, body, fetched, maxAge, date.Format("2006-01-02"))
+}
+
+// staticMaxAge returns the time until the static data fetched at the given
+// time is a day old, or until the next refresh if that is sooner
+func (s *Server) staticMaxAge(fetched time.Time) time.Duration {
+	if s.opts.NoRefresh {
+		return defaultStaticMaxAge
+	}
+	now := s.clock.Now()
+	maxAge := defaultStaticMaxAge - now.Sub(fetched)
+	if maxAge > defaultStaticMaxAge {
+		maxAge = defaultStaticMaxAge
+	}
+	status := s.client.AutoRefreshStatus()
+	for _, next := range []time.Time{status.Holidays.Next, status.Timetable.Next} {
+		if !next.IsZero() && next.Sub(now) < maxAge {
+			maxAge = next.Sub(now)
+		}
+	}
+	return maxAge
+}
+
+// writeLive writes a response built from live data fetched at the given
+// time. It can be cached until the client's cache entry expires. The Age
+// header is set from the time of the data, and stale data without an error
+// warning is marked stale
+func (s *Server) writeLive(w http.ResponseWriter, r *http.Request, body interface{}, fetched time.Time) {
+	f := s.client.Freshness(fetched)
+	w.Header().Set("Age", strconv.Itoa(int(f.Age.Seconds())))
+	if f.Stale && w.Header().Get("Warning") == "" {
+		w.Header().Set("Warning", `110 - "Response is Stale"`)
+	}
+	maxAge := s.opts.CacheTimeout - s.clock.Now().Sub(fetched)
+	s.write(w, r, body, fetched, maxAge, "")
+}
+
+// write sets the caching headers and writes the body as json. The ETag is
+// derived from the request, the time of the data and the version, so it
+// changes whenever the data does. A matching If-None-Match gets a 304
+// without a body
+func (s *Server) write(w http.ResponseWriter, r *http.Request, body interface{}, t time.Time, maxAge time.Duration, version string) {
+	if maxAge < 0 {
+		maxAge = 0
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%d|%s", r.URL.RequestURI(), t.UnixNano(), version)))
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// writeJSON writes the body as json with the given status code
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logrus.Errorf("failed to write response: %v", err)
+	}
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, errorJSON{Error: err.Error()})
+}
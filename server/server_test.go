@@ -0,0 +1,317 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/efritz09/go-caltrain/caltrain"
+)
+
+const testdata = "../caltrain/testdata"
+
+// apiClientFiles returns the contents of a test file chosen by the request
+// url, or err if it is set
+type apiClientFiles struct {
+	files map[string]string // map of url suffix to file name
+	err   error
+}
+
+func (a *apiClientFiles) Get(ctx context.Context, url string, query map[string]string) ([]byte, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	for suffix, name := range a.files {
+		if strings.HasSuffix(url, suffix) {
+			return ioutil.ReadFile(filepath.Join(testdata, name))
+		}
+	}
+	return nil, &caltrain.APIError{Status: "404 Not Found", Code: http.StatusNotFound, Url: url}
+}
+
+// newTestServer returns a Server for a client loaded from the GTFS test feed
+func newTestServer(t *testing.T) (*Server, *caltrain.CaltrainClient, *clock.Mock) {
+	t.Helper()
+	src := filepath.Join(testdata, "gtfs")
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		t.Fatalf("Unexpected error reading the GTFS fixture: %v", err)
+	}
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(src, file.Name()))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		w, err := zw.Create(file.Name())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	c := caltrain.New("fake-key")
+	r := bytes.NewReader(buf.Bytes())
+	if err := c.LoadGTFSReader(r, r.Size()); err != nil {
+		t.Fatalf("Unexpected error loading GTFS feed: %v", err)
+	}
+	c.APIClient = &apiClientFiles{files: map[string]string{
+		"StopMonitoring": "liveRoute.json",
+		"timetable":      "bulletSchedule.json",
+		"holidays":       "holiday.json",
+	}}
+	c.SetupCache(5 * time.Minute)
+
+	mock := clock.NewMock()
+	mock.Set(time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC))
+	s := New(c, Options{})
+	s.clock = mock
+	return s, c, mock
+}
+
+// get makes a request to the server and decodes the json body into v
+func get(t *testing.T, s *Server, path string, header http.Header, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, vals := range header {
+		req.Header[k] = vals
+	}
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("Unexpected error decoding %s: %v", path, err)
+		}
+	}
+	return rec
+}
+
+func TestStaticEndpoints(t *testing.T) {
+	s, c, _ := newTestServer(t)
+
+	var stations []stationJSON
+	if rec := get(t, s, "/stations", nil, &stations); rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	if len(stations) != len(c.Dataset().Stations()) || stations[0].Name != "San Francisco" {
+		t.Fatalf("Unexpected stations: %v", stations)
+	}
+
+	var lines []lineJSON
+	get(t, s, "/lines", nil, &lines)
+	if len(lines) != 2 || lines[0].ID != "Local" {
+		t.Fatalf("Unexpected lines: %v", lines)
+	}
+
+	var routes []routeJSON
+	get(t, s, "/routes?src=san-jose-diridon&dst=San%20Francisco&date=2019-11-22&after=06:15&n=2", nil, &routes)
+	if len(routes) != 2 || routes[0].Train != "501" || routes[1].Train != "103" {
+		t.Fatalf("Unexpected routes: %v", routes)
+	}
+	dep := routes[0].Stops[0].Departure
+	if exp := time.Date(2019, time.November, 22, 14, 30, 0, 0, time.UTC); !dep.Equal(exp) {
+		t.Fatalf("Unexpected departure. Expected %s, received %s", exp, dep)
+	}
+
+	routes = nil
+	get(t, s, "/stations/hillsdale/timetable?dir=south&date=2019-11-22", nil, &routes)
+	if len(routes) != 3 {
+		t.Fatalf("Unexpected timetable: %v", routes)
+	}
+
+	var route routeJSON
+	get(t, s, "/trains/199?date=2019-11-22", nil, &route)
+	last := route.Stops[len(route.Stops)-1]
+	if exp := time.Date(2019, time.November, 23, 8, 40, 0, 0, time.UTC); !last.Arrival.Equal(exp) {
+		t.Fatalf("Unexpected arrival after midnight. Expected %s, received %s", exp, last.Arrival)
+	}
+
+	errs := []struct {
+		path string
+		code int
+	}{
+		{path: "/routes?src=nowhere&dst=San%20Francisco", code: http.StatusBadRequest},
+		{path: "/routes?src=Palo%20Alto&dst=San%20Francisco&date=tomorrow", code: http.StatusBadRequest},
+		// a known station missing from the timetable is not a bad request
+		{path: "/routes?src=Mountain%20View&dst=San%20Francisco", code: http.StatusInternalServerError},
+		{path: "/stations/nowhere/timetable", code: http.StatusNotFound},
+		{path: "/stations/hillsdale/timetable?dir=west", code: http.StatusBadRequest},
+		{path: "/trains/999", code: http.StatusNotFound},
+		{path: "/delays?threshold=soon", code: http.StatusBadRequest},
+	}
+	for _, e := range errs {
+		if rec := get(t, s, e.path, nil, nil); rec.Code != e.code {
+			t.Fatalf("Unexpected status for %s. Expected %d, received %d", e.path, e.code, rec.Code)
+		}
+	}
+}
+
+func TestStaticCaching(t *testing.T) {
+	ctx := context.Background()
+	s, c, mock := newTestServer(t)
+	// the static responses are as old as the data
+	mock.Set(c.LastFetched())
+
+	rec := get(t, s, "/lines", nil, nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("Missing ETag")
+	}
+	if lm := rec.Header().Get("Last-Modified"); lm != c.LastFetched().UTC().Format(http.TimeFormat) {
+		t.Fatalf("Unexpected Last-Modified: %s", lm)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=86400" {
+		t.Fatalf("Unexpected Cache-Control: %s", cc)
+	}
+
+	mock.Add(time.Hour)
+	rec = get(t, s, "/lines", http.Header{"If-None-Match": {etag}}, nil)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("Unexpected status. Expected %d, received %d", http.StatusNotModified, rec.Code)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "public, max-age=82800" {
+		t.Fatalf("Unexpected Cache-Control: %s", cc)
+	}
+
+	// a refresh changes the tag
+	if err := c.UpdateTimeTable(caltrain.WithRefresh(ctx)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	rec = get(t, s, "/lines", http.Header{"If-None-Match": {etag}}, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Fatalf("ETag did not change after a refresh")
+	}
+}
+
+func TestRefreshStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, _, _ := newTestServer(t)
+
+	var status autoRefreshJSON
+	get(t, s, "/refresh", nil, &status)
+	if !status.Enabled || status.Holidays.Next != nil || status.Timetable.Next != nil {
+		t.Fatalf("Unexpected status before Run: %+v", status)
+	}
+
+	s.Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for status.Holidays.Next == nil || status.Timetable.Next == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("Refreshes were not scheduled: %+v", status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		get(t, s, "/refresh", nil, &status)
+	}
+	if status.Holidays.LastSuccess != nil || status.Holidays.LastError != "" {
+		t.Fatalf("Unexpected holiday status: %+v", status.Holidays)
+	}
+
+	s.opts.NoRefresh = true
+	get(t, s, "/refresh", nil, &status)
+	if status.Enabled {
+		t.Fatalf("Refresh reported as enabled with NoRefresh")
+	}
+}
+
+func TestDatedCaching(t *testing.T) {
+	s, _, mock := newTestServer(t)
+
+	// 06:30 in California, the routes for today expire at midnight
+	tests := []struct {
+		path   string
+		maxAge string
+	}{
+		{path: "/routes?src=San%20Jose%20Diridon&dst=San%20Francisco", maxAge: "public, max-age=63000"},
+		{path: "/stations/hillsdale/timetable", maxAge: "public, max-age=63000"},
+		{path: "/trains/101", maxAge: "public, max-age=63000"},
+		{path: "/routes?src=San%20Jose%20Diridon&dst=San%20Francisco&date=2019-11-22", maxAge: "public, max-age=86400"},
+	}
+	etags := make(map[string]string)
+	for _, tt := range tests {
+		rec := get(t, s, tt.path, nil, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Unexpected status for %s: %d", tt.path, rec.Code)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != tt.maxAge {
+			t.Fatalf("Unexpected Cache-Control for %s. Expected %s, received %s", tt.path, tt.maxAge, cc)
+		}
+		etags[tt.path] = rec.Header().Get("ETag")
+	}
+
+	// the next day has new routes, so the tag of a defaulted date changes
+	mock.Add(24 * time.Hour)
+	path := tests[0].path
+	rec := get(t, s, path, http.Header{"If-None-Match": {etags[path]}}, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etags[path] {
+		t.Fatalf("ETag did not change on the next day")
+	}
+}
+
+func TestLiveEndpoints(t *testing.T) {
+	s, c, _ := newTestServer(t)
+
+	var delays []statusJSON
+	rec := get(t, s, "/delays?threshold=1m", nil, &delays)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", rec.Code, rec.Body)
+	}
+	if len(delays) == 0 || delays[0].Train != "101" || delays[0].DelaySeconds < 60 {
+		t.Fatalf("Unexpected delays: %v", delays)
+	}
+
+	// the second request is served from the client's cache, so the tag
+	// matches
+	etag := rec.Header().Get("ETag")
+	rec = get(t, s, "/delays?threshold=1m", http.Header{"If-None-Match": {etag}}, nil)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("Unexpected status. Expected %d, received %d", http.StatusNotModified, rec.Code)
+	}
+
+	var status []statusJSON
+	if rec := get(t, s, "/stations/Hillsdale/status?dir=north", nil, &status); rec.Code != http.StatusOK {
+		t.Fatalf("Unexpected status %d: %s", rec.Code, rec.Body)
+	}
+
+	c.APIClient = &apiClientFiles{err: &caltrain.APILimitError{}}
+	if rec := get(t, s, "/stations/Hillsdale/status?dir=south", nil, nil); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Unexpected status. Expected %d, received %d", http.StatusTooManyRequests, rec.Code)
+	}
+}
+
+func TestLiveStale(t *testing.T) {
+	s, c, _ := newTestServer(t)
+	c.SetupCache(time.Millisecond)
+
+	if rec := get(t, s, "/delays?threshold=1m", nil, nil); rec.Code != http.StatusOK || rec.Header().Get("Age") != "0" {
+		t.Fatalf("Unexpected response %d, Age %q: %s", rec.Code, rec.Header().Get("Age"), rec.Body)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	// any failure serves the stale status with a warning
+	c.APIClient = &apiClientFiles{err: errors.New("connection refused")}
+	var delays []statusJSON
+	rec := get(t, s, "/delays?threshold=1m", nil, &delays)
+	if rec.Code != http.StatusOK || len(delays) == 0 {
+		t.Fatalf("Unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if w := rec.Header().Get("Warning"); !strings.Contains(w, "connection refused") {
+		t.Fatalf("Expected a stale warning, received %q", w)
+	}
+}